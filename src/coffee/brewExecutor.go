@@ -0,0 +1,292 @@
+package coffee
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	JobBrew   = "brew"    // runs a recipe
+	JobWarmUp = "warm up" // switches the machine on ahead of a brew
+)
+
+// ErrMachineBusy is returned when a job is submitted while the machine is busy and the queue is full
+var ErrMachineBusy = errors.New("machine is busy")
+
+type BrewExecutorConfig struct {
+	// QueueSize is how many jobs may wait while the machine is busy, further jobs are rejected. 0 rejects all of them.
+	QueueSize int `yaml:"queue_size"`
+}
+
+var BrewExecutorConfigDefaults = BrewExecutorConfig{
+	QueueSize: 1,
+}
+
+// BrewJob is a snapshot of a job submitted to the BrewExecutor
+type BrewJob struct {
+	ID       int
+	Kind     string
+	Recipe   string
+	Source   string // who asked for it, e.g. "timer" or "web"
+	Queued   time.Time
+	Started  time.Time
+	Finished time.Time
+	// Step of Steps is the step being run, described by StepName
+	Step, Steps int
+	StepName    string
+	Err         error
+}
+
+func (j BrewJob) String() string {
+	return fmt.Sprintf("%s job %d (%s from %s)", j.Kind, j.ID, j.Recipe, j.Source)
+}
+
+type brewJob struct {
+	BrewJob
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// BrewExecutor owns the coffee machine and runs one job at a time, so no two button sequences ever overlap
+type BrewExecutor struct {
+	mu        sync.Mutex
+	machine   CoffeeMachine
+	queueSize int
+	queue     []*brewJob
+	current   *brewJob
+	last      *BrewJob
+	lastID    int
+	idle      *sync.Cond
+	started   func(job BrewJob)
+	finished  func(job BrewJob)
+}
+
+func NewBrewExecutor(cfg BrewExecutorConfig, machine CoffeeMachine) *BrewExecutor {
+	e := &BrewExecutor{machine: machine, queueSize: cfg.QueueSize}
+	e.idle = sync.NewCond(&e.mu)
+	return e
+}
+
+// Machine returns the machine the executor runs its jobs on
+func (e *BrewExecutor) Machine() CoffeeMachine {
+	return e.machine
+}
+
+// Brew submits a job making the given recipe. It starts at once if the machine is idle,
+// waits in the queue if there is room, and is rejected with ErrMachineBusy otherwise.
+func (e *BrewExecutor) Brew(recipe string, source string) (BrewJob, error) {
+	return e.submit(JobBrew, recipe, source)
+}
+
+// WarmUp submits a job switching the machine on ahead of making the given recipe.
+// It is dropped if the machine is busy, as it will be on by then anyway.
+func (e *BrewExecutor) WarmUp(recipe string, source string) (BrewJob, error) {
+
+	e.mu.Lock()
+	busy := e.current != nil
+	e.mu.Unlock()
+	if busy {
+		log.Println("Machine is busy, not warming it up for", recipe)
+		return BrewJob{}, ErrMachineBusy
+	}
+	return e.submit(JobWarmUp, recipe, source)
+}
+
+// BrewFunc returns a brew func for the CoffeeTimer, submitting each brew from the given source
+func (e *BrewExecutor) BrewFunc(source string) func(brew string) {
+	return func(brew string) {
+		if _, err := e.Brew(brew, source); err != nil {
+			log.Printf("Not making %s for %s: %v\n", brew, source, err)
+		}
+	}
+}
+
+// PowerOnFunc returns a power-on func for the CoffeeTimer, submitting a warm-up job from the given source
+func (e *BrewExecutor) PowerOnFunc(source string) func(brew string) {
+	return func(brew string) {
+		e.WarmUp(brew, source)
+	}
+}
+
+func (e *BrewExecutor) submit(kind string, recipe string, source string) (BrewJob, error) {
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.current != nil && len(e.queue) >= e.queueSize {
+		log.Printf("Rejecting %s of %s from %s, as the machine is busy with %s\n", kind, recipe, source, e.current.BrewJob)
+		return BrewJob{}, ErrMachineBusy
+	}
+
+	e.lastID++
+	ctx, cancel := context.WithCancel(context.Background())
+	j := &brewJob{BrewJob: BrewJob{ID: e.lastID, Kind: kind, Recipe: recipe, Source: source, Queued: time.Now()}, ctx: ctx, cancel: cancel}
+
+	if e.current != nil {
+		log.Printf("Queueing %s behind %s\n", j.BrewJob, e.current.BrewJob)
+		e.queue = append(e.queue, j)
+		return j.BrewJob, nil
+	}
+
+	e.start(j)
+	return j.BrewJob, nil
+}
+
+// start runs the job on its own goroutine, and then the queued jobs one after the other
+func (e *BrewExecutor) start(j *brewJob) {
+
+	e.current = j
+	j.Started = time.Now()
+	log.Printf("Starting %s\n", j.BrewJob)
+
+	go func() {
+		for j != nil {
+			e.mu.Lock()
+			started, job := e.started, j.BrewJob
+			e.mu.Unlock()
+			if started != nil {
+				started(job)
+			}
+
+			ctx := withProgress(j.ctx, func(step, steps int, description string) {
+				e.mu.Lock()
+				j.Step, j.Steps, j.StepName = step, steps, description
+				e.mu.Unlock()
+			})
+
+			var err error
+			if j.Kind == JobWarmUp {
+				err = e.machine.PowerOn(ctx, j.Recipe)
+			} else {
+				err = e.machine.RunRecipe(ctx, j.Recipe)
+			}
+			j.cancel()
+
+			e.mu.Lock()
+			j.Finished, j.Err = time.Now(), err
+			if err != nil {
+				log.Printf("%s failed after %s: %v\n", j.BrewJob, j.Finished.Sub(j.Started).Truncate(time.Millisecond), err)
+			} else {
+				log.Printf("%s done after %s\n", j.BrewJob, j.Finished.Sub(j.Started).Truncate(time.Millisecond))
+			}
+			last := j.BrewJob
+			e.last = &last
+
+			j = nil
+			e.current = nil
+			if len(e.queue) > 0 {
+				j, e.queue = e.queue[0], e.queue[1:]
+				e.current = j
+				j.Started = time.Now()
+				log.Printf("Starting %s\n", j.BrewJob)
+			} else {
+				e.idle.Broadcast()
+			}
+			finished := e.finished
+			e.mu.Unlock()
+
+			if finished != nil {
+				finished(last)
+			}
+		}
+	}()
+}
+
+// SetStartedFunc sets a func called with each job as it starts
+func (e *BrewExecutor) SetStartedFunc(f func(job BrewJob)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.started = f
+}
+
+// SetFinishedFunc sets a func called with each job that has finished, successfully or not
+func (e *BrewExecutor) SetFinishedFunc(f func(job BrewJob)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.finished = f
+}
+
+// Current returns the job being run, ok is false if the machine is idle
+func (e *BrewExecutor) Current() (job BrewJob, ok bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.current == nil {
+		return BrewJob{}, false
+	}
+	return e.current.BrewJob, true
+}
+
+// Queued lists the jobs waiting for the machine, in the order they will run
+func (e *BrewExecutor) Queued() []BrewJob {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	jobs := make([]BrewJob, len(e.queue))
+	for i, j := range e.queue {
+		jobs[i] = j.BrewJob
+	}
+	return jobs
+}
+
+// Last returns the job finished last, with its error if it failed
+func (e *BrewExecutor) Last() (job BrewJob, ok bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.last == nil {
+		return BrewJob{}, false
+	}
+	return *e.last, true
+}
+
+// Cancel stops the job with the given ID, or takes it off the queue. A running recipe stops with its buttons released.
+func (e *BrewExecutor) Cancel(id int) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.current != nil && e.current.ID == id {
+		log.Printf("Cancelling %s\n", e.current.BrewJob)
+		e.current.cancel()
+		return nil
+	}
+	for i, j := range e.queue {
+		if j.ID == id {
+			log.Printf("Taking %s off the queue\n", j.BrewJob)
+			j.cancel()
+			e.queue = append(e.queue[:i], e.queue[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("no job with ID %d", id)
+}
+
+// Wait blocks until the machine is idle and nothing is queued
+func (e *BrewExecutor) Wait() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for e.current != nil {
+		e.idle.Wait()
+	}
+}
+
+// Shutdown drops the queued jobs, cancels the running one and waits until its buttons have been released
+func (e *BrewExecutor) Shutdown() {
+	e.mu.Lock()
+	for _, j := range e.queue {
+		j.cancel()
+	}
+	e.queue = nil
+	if e.current != nil {
+		log.Printf("Cancelling %s for shutting down\n", e.current.BrewJob)
+		e.current.cancel()
+	}
+	e.mu.Unlock()
+
+	e.Wait()
+}
@@ -0,0 +1,116 @@
+package coffee
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBrewExecutorRunsOneJobAtATime(t *testing.T) {
+
+	n, events := newTestMachine([]RecipeConfig{
+		{Name: "slow", Steps: []RecipeStep{{Press: "espresso", DurationMs: 50}}},
+		{Name: "quick", Steps: []RecipeStep{{Press: "lungo", DurationMs: 5}}},
+	})
+	e := NewBrewExecutor(BrewExecutorConfig{QueueSize: 1}, n)
+	finished := make(chan BrewJob, 2)
+	e.SetFinishedFunc(func(job BrewJob) { finished <- job })
+
+	if _, err := e.Brew("slow", "timer"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.Brew("quick", "web"); err != nil {
+		t.Fatalf("expected the second job to be queued, got %v", err)
+	}
+	if _, err := e.Brew("quick", "web"); !errors.Is(err, ErrMachineBusy) {
+		t.Fatalf("expected the third job to be rejected, got %v", err)
+	}
+	if queued := e.Queued(); len(queued) != 1 || queued[0].Recipe != "quick" {
+		t.Fatalf("expected quick to be queued, got %v", queued)
+	}
+	e.Wait()
+
+	// the quick job must not press its button before the slow one has released its own
+	if got := strings.Join(events(), ","); got != "press espresso,release espresso,press lungo,release lungo" {
+		t.Fatalf("unexpected button sequence %s", got)
+	}
+	if last, ok := e.Last(); !ok || last.Recipe != "quick" || last.Err != nil {
+		t.Fatalf("expected quick to have finished last, got %v", last)
+	}
+	for _, recipe := range []string{"slow", "quick"} {
+		select {
+		case job := <-finished:
+			if job.Recipe != recipe || job.Finished.IsZero() {
+				t.Errorf("expected %s to have finished, got %v", recipe, job)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected %s to be reported finished", recipe)
+		}
+	}
+
+}
+
+func TestBrewExecutorCancelReleasesButton(t *testing.T) {
+
+	n, events := newTestMachine([]RecipeConfig{{Name: "long", Steps: []RecipeStep{{Press: "lungo", DurationMs: 10000}}}})
+	e := NewBrewExecutor(BrewExecutorConfig{}, n)
+
+	job, err := e.Brew("long", "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(time.Second); ; {
+		if current, _ := e.Current(); current.Steps > 0 {
+			if current.Step != 1 || current.StepName != "press lungo for 10000ms" {
+				t.Fatalf("unexpected progress %d/%d %s", current.Step, current.Steps, current.StepName)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("no progress reported")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := e.Cancel(job.ID); err != nil {
+		t.Fatal(err)
+	}
+	e.Wait()
+
+	if got := strings.Join(events(), ","); got != "press lungo,release lungo" {
+		t.Fatalf("expected the button to be released, got %s", got)
+	}
+	if last, _ := e.Last(); !errors.Is(last.Err, context.Canceled) {
+		t.Fatalf("expected the job to have been cancelled, got %v", last.Err)
+	}
+	if err := e.Cancel(job.ID); err == nil {
+		t.Fatal("expected error cancelling a finished job")
+	}
+
+}
+
+func TestBrewExecutorShutdownDropsQueue(t *testing.T) {
+
+	n, events := newTestMachine([]RecipeConfig{{Name: "long", Steps: []RecipeStep{{Press: "lungo", DurationMs: 10000}}}})
+	e := NewBrewExecutor(BrewExecutorConfig{QueueSize: 2}, n)
+
+	e.Brew("long", "timer")
+	e.Brew("long", "web")
+	e.Brew("long", "web")
+	// a warm-up is not worth queueing, the machine will be on anyway
+	if _, err := e.WarmUp("long", "timer"); !errors.Is(err, ErrMachineBusy) {
+		t.Fatalf("expected warm-up to be dropped, got %v", err)
+	}
+	e.Shutdown()
+
+	if _, ok := e.Current(); ok || len(e.Queued()) != 0 {
+		t.Fatal("expected the executor to be idle after shutdown")
+	}
+	// the first job may be cancelled before it has even pressed its button, the others must never press theirs
+	if got := strings.Join(events(), ","); got != "press lungo,release lungo" && got != "" {
+		t.Fatalf("expected only the first job to have pressed its button, got %s", got)
+	}
+
+}
@@ -0,0 +1,429 @@
+package coffee
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+)
+
+// buttonMachine makes coffee by running recipes, which press the machine's buttons through relays.
+// The machine models only differ in the buttons they have and their default recipes.
+type buttonMachine struct {
+	buttonPressLengthMs int
+	heatingDuration     time.Duration
+	powerOnEarly        bool
+	autoOff             time.Duration
+	cupWait             time.Duration
+	buttons             map[string]func(press bool)
+	recipes             []RecipeConfig
+
+	state *machineStateTracker
+
+	mu           sync.Mutex
+	sensors      map[string]func() bool
+	inventory    *Inventory
+	maintenance  *Maintenance
+	heldBackFunc func(reason string)
+	current      CurrentSensor
+	currentCfg   CurrentSensorConfig
+}
+
+func newButtonMachine(cfg MachineConfig, buttons map[string]func(press bool), defaultRecipes []RecipeConfig) *buttonMachine {
+
+	// configs written before the warm-up phase only waited for the length of a button press
+	heatingDurationMs := cfg.HeatingDurationMs
+	if heatingDurationMs <= 0 {
+		heatingDurationMs = cfg.ButtonPressDurationMs
+	}
+	autoOffMinutes := cfg.AutoOffMinutes
+	if autoOffMinutes <= 0 {
+		autoOffMinutes = MachineConfigDefaults.AutoOffMinutes
+	}
+
+	n := &buttonMachine{
+		buttonPressLengthMs: cfg.ButtonPressDurationMs,
+		heatingDuration:     time.Duration(heatingDurationMs) * time.Millisecond,
+		powerOnEarly:        cfg.PowerOnEarly,
+		autoOff:             time.Duration(autoOffMinutes) * time.Minute,
+		cupWait:             time.Duration(cfg.CupWaitMs) * time.Millisecond,
+		buttons:             buttons,
+		sensors:             map[string]func() bool{},
+		heldBackFunc:        func(string) {},
+	}
+	n.state = newMachineStateTracker(SystemClock, n.heatingDuration, n.autoOff)
+
+	recipes := cfg.Recipes
+	if len(recipes) == 0 {
+		recipes = defaultRecipes
+	}
+	for _, r := range recipes {
+		if err := r.validate(n.buttons); err != nil {
+			log.Println("Skipping recipe from config:", err)
+			continue
+		}
+		if _, ok := n.recipe(r.Name); ok {
+			log.Printf("Skipping recipe '%s' from config, as it is defined twice\n", r.Name)
+			continue
+		}
+		n.recipes = append(n.recipes, r)
+	}
+
+	return n
+}
+
+// Recipes lists the names of the configured recipes, in the order of the config
+func (n *buttonMachine) Recipes() []string {
+	names := make([]string, len(n.recipes))
+	for i, r := range n.recipes {
+		names[i] = r.Name
+	}
+	return names
+}
+
+// SetSensor makes a sensor available to the wait_for steps of the recipes
+func (n *buttonMachine) SetSensor(name string, ready func() bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.sensors[name] = ready
+}
+
+// RunRecipe runs the steps of the named recipe. If ctx is cancelled, it stops after releasing the button being held.
+func (n *buttonMachine) RunRecipe(ctx context.Context, name string) error {
+
+	r, ok := n.recipe(name)
+	if !ok {
+		return fmt.Errorf("unknown recipe '%s'", name)
+	}
+
+	log.Printf("Making %s\n", r.Name)
+	for i, step := range r.Steps {
+		// don't start pressing buttons for a recipe that has been cancelled in the meantime
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("recipe '%s' cancelled before step %d: %w", r.Name, i+1, err)
+		}
+		log.Printf("Recipe %s step %d/%d: %s\n", r.Name, i+1, len(r.Steps), step)
+		ReportProgress(ctx, i+1, len(r.Steps), step.String())
+		if step.Press != "" && n.brews(step.Press) {
+			if err := n.checkCup(ctx, i+1, len(r.Steps)); err != nil {
+				return fmt.Errorf("recipe '%s' step %d (%s): %w", r.Name, i+1, step, err)
+			}
+		}
+		if err := n.runStep(ctx, step); err != nil {
+			n.brewed(r, r.Name+" failed")
+			return fmt.Errorf("recipe '%s' step %d (%s): %w", r.Name, i+1, step, err)
+		}
+	}
+
+	n.brewed(r, r.Name+" made")
+	return nil
+}
+
+// SetInventory makes each recipe run take the capsules it uses out of the inventory
+func (n *buttonMachine) SetInventory(inv *Inventory) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.inventory = inv
+}
+
+// SetHeldBackFunc sets a func called with the reason whenever a brew is held back, e.g. as there is no cup in place
+func (n *buttonMachine) SetHeldBackFunc(f func(reason string)) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.heldBackFunc = f
+}
+
+// checkCup holds back a press that makes coffee until the cup sensor reports a cup in place, for at most the configured wait.
+// Machines without a cup sensor always brew.
+func (n *buttonMachine) checkCup(ctx context.Context, step, steps int) error {
+
+	n.mu.Lock()
+	sensor, ok := n.sensors[SensorCup]
+	heldBack := n.heldBackFunc
+	n.mu.Unlock()
+	if !ok || sensor() {
+		return nil
+	}
+
+	if n.cupWait <= 0 {
+		log.Println("No cup in place, not brewing")
+		heldBack("no cup in place")
+		return errors.New("no cup in place")
+	}
+
+	reason := fmt.Sprintf("no cup in place, waiting up to %s", n.cupWait)
+	log.Println("Brew held back:", reason)
+	heldBack(reason)
+	ReportProgress(ctx, step, steps, reason)
+	if err := waitFor(ctx, SensorCup, sensor, n.cupWait); err != nil {
+		if ctx.Err() != nil {
+			return err
+		}
+		return fmt.Errorf("no cup in place after waiting %s", n.cupWait)
+	}
+	return nil
+}
+
+// SetCurrentSensor makes each brew press wait until the current drawn by the machine confirms the brew has started and finished
+func (n *buttonMachine) SetCurrentSensor(sensor CurrentSensor, cfg CurrentSensorConfig) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.current, n.currentCfg = sensor, cfg
+}
+
+// confirmBrew presses the brew button, then waits for the current to rise above what the machine drew before the press,
+// and to fall again once the brew has finished. If it does not rise, the press is retried once.
+// Relay powered machines draw current while the button is held, there is nothing to confirm.
+func (n *buttonMachine) confirmBrew(ctx context.Context, button string, d time.Duration) error {
+
+	n.mu.Lock()
+	sensor, cfg := n.current, n.currentCfg
+	n.mu.Unlock()
+	if sensor == nil || !n.hasWarmUp() {
+		n.pressing(button)
+		return n.press(ctx, button, d)
+	}
+
+	startTimeout := time.Duration(cfg.StartTimeoutMs) * time.Millisecond
+	started, err := n.pressDrawingCurrent(ctx, sensor, cfg, button, d)
+	if err == nil && !started {
+		log.Printf("Brew has not started within %s of pressing %s, pressing it again\n", startTimeout, button)
+		started, err = n.pressDrawingCurrent(ctx, sensor, cfg, button, d)
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err != nil {
+		// brewing without confirmation is better than no coffee
+		log.Println("Could not read current sensor, not confirming brew:", err)
+		return nil
+	}
+	if !started {
+		return fmt.Errorf("brew has not started after pressing %s twice", button)
+	}
+	log.Println("Brew has started")
+
+	return finished(ctx, sensor, cfg)
+}
+
+// pressDrawingCurrent presses the button, and tells whether the current then rises to the brewing current within the start timeout.
+// A machine already drawing current at the press, e.g. reheating its thermoblock, has to draw more than that by the start rise.
+func (n *buttonMachine) pressDrawingCurrent(ctx context.Context, sensor CurrentSensor, cfg CurrentSensorConfig, button string, d time.Duration) (bool, error) {
+
+	baseline, baselineErr := sensor.Amps()
+	n.pressing(button)
+	if err := n.press(ctx, button, d); err != nil {
+		return false, err
+	}
+	if baselineErr != nil {
+		return false, baselineErr
+	}
+
+	amps := math.Max(cfg.BrewingAmps, baseline+cfg.StartRiseAmps)
+	return drawsCurrent(ctx, sensor, amps, time.Duration(cfg.StartTimeoutMs)*time.Millisecond)
+}
+
+// SetMaintenance makes each recipe run count towards descaling and emptying the drip tray
+func (n *buttonMachine) SetMaintenance(m *Maintenance) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.maintenance = m
+}
+
+// CheckStock returns an error if the inventory lacks the capsules the named recipe needs
+func (n *buttonMachine) CheckStock(brew string) error {
+
+	r, ok := n.recipe(brew)
+	if !ok {
+		// not making it fails for reasons other than the stock
+		return nil
+	}
+	n.mu.Lock()
+	inv := n.inventory
+	n.mu.Unlock()
+
+	if capsule, count := r.capsules(); inv != nil && count > 0 {
+		return inv.Check(capsule, count)
+	}
+	return nil
+}
+
+// State returns what the machine is doing and since when
+func (n *buttonMachine) State() (MachineState, time.Time) {
+	return n.state.State()
+}
+
+// SetStateChangedFunc sets a func called with each new state of the machine, e.g. to show it
+func (n *buttonMachine) SetStateChangedFunc(f func(state MachineState)) {
+	n.state.setChangedFunc(f)
+}
+
+// pressing tells the state tracker about a button about to be pressed
+func (n *buttonMachine) pressing(button string) {
+	switch state, _ := n.state.State(); {
+	case !state.IsOn() && n.switchesOn(button):
+		n.state.set(MachineHeating, button+" pressed while the machine is "+state.String()+", switching it on")
+	case state != MachineBrewing:
+		n.state.set(MachineBrewing, button+" pressed")
+	}
+}
+
+// brewed tells the state tracker that a recipe has finished. A machine that is switched on by its recipes stays on until
+// it switches itself off, one without warm-up steps is powered through the relay and is off once it has been released.
+// The capsule is used up and the brew counts for maintenance once the machine has started brewing, even if the recipe has failed after that.
+func (n *buttonMachine) brewed(r RecipeConfig, reason string) {
+	if state, _ := n.state.State(); state != MachineBrewing {
+		return
+	}
+
+	n.mu.Lock()
+	inv, maintenance := n.inventory, n.maintenance
+	n.mu.Unlock()
+	if capsule, count := r.capsules(); inv != nil && count > 0 {
+		inv.Use(capsule, count)
+	}
+	if maintenance != nil {
+		maintenance.Count(r.WaterMl)
+	}
+
+	if n.hasWarmUp() {
+		n.state.set(MachineReady, reason)
+	} else {
+		n.state.set(MachineOff, reason)
+	}
+}
+
+// brews tells whether pressing the button now makes coffee, rather than switching the machine on
+func (n *buttonMachine) brews(button string) bool {
+	state, _ := n.state.State()
+	return state.IsOn() || !n.switchesOn(button)
+}
+
+// switchesOn tells whether pressing the button while the machine is off switches it on, as it is a warm-up button of a recipe
+func (n *buttonMachine) switchesOn(button string) bool {
+	for _, r := range n.recipes {
+		if b, ok := r.warmUpButton(); ok && b == button {
+			return true
+		}
+	}
+	return false
+}
+
+func (n *buttonMachine) hasWarmUp() bool {
+	for _, r := range n.recipes {
+		if _, ok := r.warmUpButton(); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (n *buttonMachine) runStep(ctx context.Context, step RecipeStep) error {
+	switch {
+	case step.Press != "":
+		d := time.Duration(n.buttonPressLengthMs) * time.Millisecond
+		if step.DurationMs > 0 {
+			d = time.Duration(step.DurationMs) * time.Millisecond
+		}
+		if !n.brews(step.Press) {
+			n.pressing(step.Press)
+			return n.press(ctx, step.Press, d)
+		}
+		return n.confirmBrew(ctx, step.Press, d)
+	case step.WarmUp != "":
+		return n.warmUp(ctx, step.WarmUp)
+	case step.WaitFor != "":
+		n.mu.Lock()
+		sensor, ok := n.sensors[step.WaitFor]
+		n.mu.Unlock()
+		if !ok {
+			return fmt.Errorf("unknown sensor '%s'", step.WaitFor)
+		}
+		timeout := defaultSensorTimeout
+		if step.TimeoutMs > 0 {
+			timeout = time.Duration(step.TimeoutMs) * time.Millisecond
+		}
+		return waitFor(ctx, step.WaitFor, sensor, timeout)
+	default:
+		return sleep(ctx, time.Duration(step.WaitMs)*time.Millisecond)
+	}
+}
+
+// press holds the named button for d. The button is always released, even if ctx is cancelled.
+func (n *buttonMachine) press(ctx context.Context, button string, d time.Duration) error {
+	activate := n.buttons[button]
+	activate(true)
+	defer activate(false)
+	return sleep(ctx, d)
+}
+
+// PowerOn switches the machine on with the warm-up button of the given recipe, so it is heated up by the time it is made
+func (n *buttonMachine) PowerOn(ctx context.Context, brew string) error {
+
+	r, ok := n.recipe(brew)
+	if !ok {
+		return fmt.Errorf("unknown recipe '%s'", brew)
+	}
+	button, ok := r.warmUpButton()
+	if !ok {
+		log.Printf("Recipe '%s' has no warm-up step, not switching on\n", brew)
+		return nil
+	}
+
+	if state, _ := n.state.State(); state.IsOn() {
+		log.Println("Machine is", state, "already")
+		return nil
+	}
+
+	ReportProgress(ctx, 1, 1, "warm up with "+button)
+	return n.powerOn(ctx, button)
+}
+
+// WarmUpLead is how long ahead of the trigger time the CoffeeTimer switches the machine on, 0 if it is switched on when brewing
+func (n *buttonMachine) WarmUpLead() time.Duration {
+	if !n.powerOnEarly || !n.hasWarmUp() {
+		return 0
+	}
+	return n.heatingDuration
+}
+
+func (n *buttonMachine) powerOn(ctx context.Context, button string) error {
+	log.Println("Switching on machine")
+	err := n.press(ctx, button, time.Duration(n.buttonPressLengthMs)*time.Millisecond)
+	state, _ := n.state.State()
+	n.state.set(MachineHeating, "switched on with "+button+" while "+state.String())
+	return err
+}
+
+// warmUp switches the machine on unless it already is, and waits until it has heated up
+func (n *buttonMachine) warmUp(ctx context.Context, button string) error {
+
+	// pressing a button while the machine is on would make coffee rather than switch it on
+	if state, since := n.state.State(); state.IsOn() {
+		log.Println("Machine has been", state, "for", time.Since(since).Truncate(time.Second))
+	} else if err := n.powerOn(ctx, button); err != nil {
+		return err
+	}
+
+	if heating := n.state.heatingLeft(); heating > 0 {
+		log.Println("Waiting", heating.Truncate(time.Millisecond), "for the machine to heat up")
+		if err := sleep(ctx, heating); err != nil {
+			return err
+		}
+	}
+	// the state timer may not have fired just yet
+	n.state.set(MachineReady, "heated up")
+	return nil
+}
+
+func (n *buttonMachine) recipe(name string) (RecipeConfig, bool) {
+	for _, r := range n.recipes {
+		if r.Name == name {
+			return r, true
+		}
+	}
+	return RecipeConfig{}, false
+}
@@ -0,0 +1,192 @@
+package coffee
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"periph.io/x/conn/v3/gpio"
+	"periph.io/x/conn/v3/physic"
+)
+
+// Tone is a note played for a while, a FreqHz of 0 is a rest
+type Tone struct {
+	FreqHz   int
+	Duration time.Duration
+}
+
+// Tune is a named sequence of tones
+type Tune struct {
+	Name  string
+	Tones []Tone
+}
+
+// the notes of the tunes, in Hz
+const (
+	noteA4 = 440
+	noteA5 = 880
+	noteC6 = 1047
+	noteE6 = 1319
+	noteG6 = 1568
+	noteC7 = 2093
+)
+
+var (
+	TuneArm          = Tune{Name: "arm", Tones: []Tone{{noteC6, 100 * time.Millisecond}, {noteE6, 100 * time.Millisecond}, {noteG6, 150 * time.Millisecond}}}
+	TuneDisarm       = Tune{Name: "disarm", Tones: []Tone{{noteG6, 100 * time.Millisecond}, {noteE6, 100 * time.Millisecond}, {noteC6, 150 * time.Millisecond}}}
+	TuneBrewStarted  = Tune{Name: "brew started", Tones: []Tone{{noteA5, 80 * time.Millisecond}, {0, 60 * time.Millisecond}, {noteA5, 80 * time.Millisecond}}}
+	TuneBrewComplete = Tune{Name: "brew complete", Tones: []Tone{{noteC6, 120 * time.Millisecond}, {noteE6, 120 * time.Millisecond}, {noteG6, 120 * time.Millisecond}, {noteC7, 300 * time.Millisecond}}}
+	TuneError        = Tune{Name: "error", Tones: []Tone{{noteA4, 300 * time.Millisecond}, {0, 100 * time.Millisecond}, {noteA4, 300 * time.Millisecond}, {0, 100 * time.Millisecond}, {noteA4, 300 * time.Millisecond}}}
+)
+
+// buzzerQueueSize is how many tunes may wait while one is playing, any further ones are dropped
+const buzzerQueueSize = 4
+
+// QuietHours is a window of the day in which the buzzer keeps quiet, e.g. from 22:00 to 06:30. It may span midnight.
+// Without a From and a To, the buzzer is never quiet.
+type QuietHours struct {
+	From string `yaml:"from"`
+	To   string `yaml:"to"`
+}
+
+func (q QuietHours) validate() error {
+	if q.From == "" && q.To == "" {
+		return nil
+	}
+	if _, err := q.minute(q.From); err != nil {
+		return fmt.Errorf("quiet hours from: %w", err)
+	}
+	if _, err := q.minute(q.To); err != nil {
+		return fmt.Errorf("quiet hours to: %w", err)
+	}
+	return nil
+}
+
+// Contains tells whether t is within the quiet hours
+func (q QuietHours) Contains(t time.Time) bool {
+
+	from, err := q.minute(q.From)
+	if err != nil {
+		return false
+	}
+	to, err := q.minute(q.To)
+	if err != nil {
+		return false
+	}
+	m := t.Hour()*60 + t.Minute()
+	if from <= to {
+		return from <= m && m < to
+	}
+	// spanning midnight
+	return m >= from || m < to
+}
+
+// minute returns the minute of the day of a time like "22:30"
+func (q QuietHours) minute(timeStr string) (int, error) {
+	hour, min, _, err := parseClockTime(timeStr)
+	if err != nil {
+		return 0, fmt.Errorf("unexpected time format '%s', expected 'hh:mm'", timeStr)
+	}
+	return hour*60 + min, nil
+}
+
+// buzzer plays tunes on a piezo buzzer in its own goroutine, so callers never wait for them.
+// The tones are generated by the pin's PWM if it has one, or else by switching the pin in software.
+type buzzer struct {
+	out   *output
+	quiet QuietHours
+	now   func() time.Time
+	tunes chan Tune
+	stop  chan struct{}
+	done  chan struct{}
+	once  sync.Once
+	noPWM bool // only used by the goroutine
+}
+
+func newBuzzer(out *output, quiet QuietHours) *buzzer {
+	b := &buzzer{out: out, quiet: quiet, now: time.Now, tunes: make(chan Tune, buzzerQueueSize), stop: make(chan struct{}), done: make(chan struct{})}
+	go b.run()
+	return b
+}
+
+// Play plays the tune after the ones waiting, unless it is within the quiet hours
+func (b *buzzer) Play(t Tune) {
+	if b == nil {
+		return
+	}
+
+	if b.quiet.Contains(b.now()) {
+		log.Printf("Not playing the %s tune in the quiet hours\n", t.Name)
+		return
+	}
+	select {
+	case b.tunes <- t:
+	default:
+		log.Printf("Not playing the %s tune, too many tunes are waiting\n", t.Name)
+	}
+}
+
+// halt stops the goroutine, after the tone being played, and puts the pin into its idle state
+func (b *buzzer) halt() {
+	if b == nil {
+		return
+	}
+
+	b.once.Do(func() { close(b.stop) })
+	<-b.done
+}
+
+func (b *buzzer) run() {
+	defer close(b.done)
+	defer b.out.idle()
+
+	for {
+		select {
+		case <-b.stop:
+			return
+		case t := <-b.tunes:
+			log.Printf("Playing the %s tune\n", t.Name)
+			for _, tone := range t.Tones {
+				select {
+				case <-b.stop:
+					return
+				default:
+				}
+				b.play(tone)
+			}
+			b.out.idle()
+		}
+	}
+}
+
+// play plays a tone, or keeps quiet for its duration if it is a rest
+func (b *buzzer) play(tone Tone) {
+
+	if tone.FreqHz <= 0 {
+		b.out.idle()
+		time.Sleep(tone.Duration)
+		return
+	}
+
+	if !b.noPWM {
+		err := b.out.pin.PWM(gpio.DutyHalf, physic.Frequency(tone.FreqHz)*physic.Hertz)
+		if err == nil {
+			time.Sleep(tone.Duration)
+			return
+		}
+		log.Printf("No PWM on %s, generating the tones in software: %v\n", b.out, err)
+		b.noPWM = true
+	}
+
+	// switching the pin every half period, it is a little rough around the edges, but well recognisable
+	half := time.Second / time.Duration(2*tone.FreqHz)
+	on := true
+	for end := time.Now().Add(tone.Duration); time.Now().Before(end); on = !on {
+		if err := b.out.pin.Out(b.out.cfg.level(on)); err != nil {
+			log.Println("Error playing a tone on", b.out, ":", err)
+			return
+		}
+		time.Sleep(half)
+	}
+}
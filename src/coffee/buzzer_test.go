@@ -0,0 +1,116 @@
+package coffee
+
+import (
+	"testing"
+	"time"
+
+	"periph.io/x/conn/v3/gpio"
+)
+
+func TestQuietHours(t *testing.T) {
+
+	at := func(hour, min int) time.Time {
+		return time.Date(2023, time.January, 2, hour, min, 0, 0, time.Local)
+	}
+	tests := []struct {
+		name     string
+		quiet    QuietHours
+		t        time.Time
+		expected bool
+	}{
+		{"none", QuietHours{}, at(3, 0), false},
+		{"night", QuietHours{From: "22:00", To: "06:30"}, at(23, 59), true},
+		{"after midnight", QuietHours{From: "22:00", To: "06:30"}, at(6, 29), true},
+		{"morning", QuietHours{From: "22:00", To: "06:30"}, at(6, 30), false},
+		{"afternoon nap", QuietHours{From: "13:00", To: "15:00"}, at(13, 0), true},
+		{"after the nap", QuietHours{From: "13:00", To: "15:00"}, at(15, 1), false},
+	}
+
+	for _, test := range tests {
+		if got := test.quiet.Contains(test.t); got != test.expected {
+			t.Errorf("%s: expected %s to be quiet: %t, got %t", test.name, test.t.Format("15:04"), test.expected, got)
+		}
+	}
+
+	if err := (QuietHours{From: "22:00"}).validate(); err == nil {
+		t.Error("expected quiet hours without an end to be rejected")
+	}
+	if err := (QuietHours{From: "22:00", To: "7 o'clock"}).validate(); err == nil {
+		t.Error("expected an invalid time to be rejected")
+	}
+}
+
+func TestBuzzerPlaysTune(t *testing.T) {
+
+	hw := NewSimHardware()
+	pin, _ := hw.Pin(18)
+	b := newBuzzer(newOutput("Buzzer", pin, BuzzerOutputDefaults), QuietHours{})
+
+	// the simulator has no PWM, so the tone is switched in software
+	b.Play(Tune{Name: "test", Tones: []Tone{{1000, 20 * time.Millisecond}, {0, 10 * time.Millisecond}}})
+	for deadline := time.Now().Add(time.Second); len(hw.Changes()) < 10; {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the tone to switch the pin, got %v", hw.Changes())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	b.halt()
+
+	if hw.Level(18) != gpio.Low {
+		t.Error("expected the buzzer to be switched off")
+	}
+}
+
+func TestBuzzerQuietHours(t *testing.T) {
+
+	hw := NewSimHardware()
+	pin, _ := hw.Pin(18)
+	b := newBuzzer(newOutput("Buzzer", pin, BuzzerOutputDefaults), QuietHours{From: "22:00", To: "06:30"})
+	b.now = func() time.Time { return testStart }
+
+	b.Play(TuneArm)
+	time.Sleep(50 * time.Millisecond)
+	b.halt()
+
+	// only switched off on halting
+	if changes := hw.Changes(); len(changes) != 1 {
+		t.Errorf("expected no tune in the quiet hours, got %v", changes)
+	}
+
+	// calling a buzzer that isn't there does nothing
+	var none *buzzer
+	none.Play(TuneArm)
+	none.halt()
+}
+
+func TestRaspiBuzzer(t *testing.T) {
+
+	cfg := NoRaspiInUseConfig
+	cfg.BuzzerPin = "18"
+	cfg.BuzzerQuietHours = QuietHours{From: "22:00"}
+	if _, err := NewRaspi(cfg, NewSimHardware()); err == nil {
+		t.Error("expected invalid quiet hours to be rejected")
+	}
+
+	cfg.BuzzerQuietHours = QuietHours{}
+	hw := NewSimHardware()
+	r, err := NewRaspi(cfg, hw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.PlayTune(TuneBrewStarted)
+	// switched off at startup, then switched by the tone
+	for deadline := time.Now().Add(time.Second); len(hw.Changes()) < 3; {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the tune to be played, got %v", hw.Changes())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	r.Disconnect()
+	if hw.Level(18) != gpio.Low {
+		t.Error("expected the buzzer to be switched off")
+	}
+
+	// a raspi without a buzzer keeps quiet
+	newTestRaspi().PlayTune(TuneError)
+}
@@ -0,0 +1,80 @@
+package coffee
+
+import (
+	"time"
+)
+
+// Clock is the source of time for CoffeeTimer, so tests can run on a fake clock instead of waiting for real time to pass
+type Clock interface {
+	Now() time.Time
+	// Monotonic is the time passed since an arbitrary start on a clock that is never set, unlike the wall clock Now reads
+	Monotonic() time.Duration
+	AfterFunc(d time.Duration, f func()) ClockTimer
+}
+
+// ClockTimer is a timer created by a Clock, *time.Timer satisfies it
+type ClockTimer interface {
+	Stop() bool
+}
+
+type systemClock struct{}
+
+// SystemClock is the Clock backed by the time package
+var SystemClock Clock = systemClock{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// systemClockStart is what the system clock's monotonic readings are taken from
+var systemClockStart = time.Now()
+
+func (systemClock) Monotonic() time.Duration {
+	return time.Since(systemClockStart)
+}
+
+func (systemClock) AfterFunc(d time.Duration, f func()) ClockTimer {
+	return time.AfterFunc(d, f)
+}
+
+// wallClockTime returns the instant at which the wall clock in loc shows the given date and time.
+// On the night the clocks go forward, a time that is skipped resolves to the moment of the switch-over,
+// so the coffee is made as soon as the clocks have jumped.
+// On the night the clocks go back, a time that occurs twice resolves to its first occurrence, so the coffee is made only once.
+func wallClockTime(year int, month time.Month, day, hour, min, sec int, loc *time.Location) time.Time {
+
+	// normalise the date first, e.g. 32 January becomes 1 February - noon is never affected by a switch-over
+	date := time.Date(year, month, day, 12, 0, 0, 0, loc)
+	year, month, day = date.Date()
+
+	want := time.Date(year, month, day, hour, min, sec, 0, time.UTC)
+	t := time.Date(year, month, day, hour, min, sec, 0, loc)
+
+	if !wallClockOf(t).Equal(want) {
+		// the wall clock skips this time, find the first instant at which it shows a later time
+		lo, hi := t.Add(-3*time.Hour), t.Add(3*time.Hour)
+		for hi.Sub(lo) > time.Second {
+			mid := lo.Add(hi.Sub(lo) / 2)
+			if wallClockOf(mid).Before(want) {
+				lo = mid
+			} else {
+				hi = mid
+			}
+		}
+		return hi.Truncate(time.Second)
+	}
+
+	// the wall clock may show this time twice, prefer the earlier instant
+	for _, shift := range []time.Duration{2 * time.Hour, time.Hour, 30 * time.Minute} {
+		if earlier := t.Add(-shift); wallClockOf(earlier).Equal(want) {
+			return earlier
+		}
+	}
+
+	return t
+}
+
+// wallClockOf returns the date and time shown by the wall clock at t, as a UTC time that can be compared across offsets
+func wallClockOf(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+}
@@ -0,0 +1,155 @@
+package coffee
+
+import (
+	"sort"
+	"sync"
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+// fakeClock only moves when told to, and runs due timer funcs synchronously from Advance
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	elapsed time.Duration // moved by Advance, but not by Jump
+	timers  []*fakeTimer
+}
+
+type fakeTimer struct {
+	clock   *fakeClock
+	when    time.Time
+	f       func()
+	stopped bool
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+	return &fakeClock{now: now}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Monotonic() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.elapsed
+}
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) ClockTimer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t := &fakeTimer{clock: c, when: c.now.Add(d), f: f}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+// Advance moves the clock forward by d, running every timer that becomes due on the way in order
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	target := c.now.Add(d)
+
+	for {
+		sort.SliceStable(c.timers, func(i, j int) bool { return c.timers[i].when.Before(c.timers[j].when) })
+		if len(c.timers) == 0 || c.timers[0].when.After(target) {
+			break
+		}
+
+		t := c.timers[0]
+		c.timers = c.timers[1:]
+		if t.when.After(c.now) {
+			c.elapsed += t.when.Sub(c.now)
+			c.now = t.when
+		}
+
+		c.mu.Unlock()
+		t.f()
+		c.mu.Lock()
+	}
+
+	c.elapsed += target.Sub(c.now)
+	c.now = target
+	c.mu.Unlock()
+}
+
+// Jump sets the wall clock by d without running any timers, like NTP would. Timers run on the monotonic clock,
+// so they still fire after the same time has passed, which moves their due time on the wall clock by d as well.
+func (c *fakeClock) Jump(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+	for _, t := range c.timers {
+		t.when = t.when.Add(d)
+	}
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+
+	for i, pending := range t.clock.timers {
+		if pending == t {
+			t.clock.timers = append(t.clock.timers[:i], t.clock.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func TestWallClockTimeOnSpringForwardNight(t *testing.T) {
+
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// clocks go from 2:00 CET straight to 3:00 CEST, so 2:30 never happens
+	got := wallClockTime(2023, time.March, 26, 2, 30, 0, berlin)
+	want := time.Date(2023, time.March, 26, 1, 0, 0, 0, time.UTC)
+	if !got.Equal(want) {
+		t.Fatalf("skipped time should resolve to the switch-over at %s, got %s", want, got)
+	}
+
+}
+
+func TestWallClockTimeOnFallBackNight(t *testing.T) {
+
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// clocks go from 3:00 CEST back to 2:00 CET, so 2:30 happens twice
+	got := wallClockTime(2023, time.October, 29, 2, 30, 0, berlin)
+	want := time.Date(2023, time.October, 29, 0, 30, 0, 0, time.UTC)
+	if !got.Equal(want) {
+		t.Fatalf("repeated time should resolve to its first occurrence at %s, got %s", want, got)
+	}
+
+}
+
+func TestNextTriggerAcrossDaylightSavingChange(t *testing.T) {
+
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clock := newFakeClock(time.Date(2023, time.March, 25, 7, 0, 0, 0, berlin))
+	ct := NewCoffeeTimer(CoffeeTimerConfig{TriggerTime: "6:45", Brew: BrewEspresso}, newTestRaspi(), clock)
+
+	triggerTime, _, ok := ct.next(clock.Now())
+	want := time.Date(2023, time.March, 26, 6, 45, 0, 0, berlin)
+	if !ok || !triggerTime.Equal(want) {
+		t.Fatalf("expected trigger at %s, got %s", want, triggerTime)
+	}
+	if triggerTime.Sub(clock.Now()) != 22*time.Hour+45*time.Minute {
+		t.Fatalf("the night the clocks go forward should be an hour shorter, got %s until trigger", triggerTime.Sub(clock.Now()))
+	}
+
+}
@@ -1,164 +1,872 @@
 package coffee
 
 import (
+	"errors"
 	"fmt"
 	"log"
-	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
+// Brew types the timer can trigger on a scheduled day
+const (
+	BrewEspresso = "espresso"
+	BrewLungo    = "lungo"
+	BrewNone     = "none"
+)
+
+// Weekdays lists the days of the week in the order they are shown to the user, Monday first
+var Weekdays = []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday, time.Sunday}
+
+// ScheduleEntry overrides the trigger time and/or brew type for a single day of the week.
+// Empty fields fall back to the timer's TriggerTime and Brew.
+type ScheduleEntry struct {
+	Time string `yaml:"time,omitempty" json:"time,omitempty"`
+	Brew string `yaml:"brew,omitempty" json:"brew,omitempty"`
+}
+
 type CoffeeTimerConfig struct {
-	TriggerTime string `yaml:"trigger_time"`
+	// TriggerTime is either a time of day "hh:mm[:ss]" applying to every day, a cron expression like "30 6 * * 1-5",
+	// which replaces the weekly schedule, or a time relative to sunrise or sunset like "sunrise-20m",
+	// which replaces the times of the weekly schedule but keeps its brew types
+	TriggerTime string `yaml:"trigger_time" json:"trigger_time"`
+	Brew        string `yaml:"brew" json:"brew"`
+	// Schedule is keyed by the lower case weekday name, e.g. "monday"
+	Schedule map[string]ScheduleEntry `yaml:"schedule,omitempty" json:"schedule,omitempty"`
 }
 
 var CoffeeTimerConfigDefaults = CoffeeTimerConfig{
 	TriggerTime: "8:30",
+	Brew:        BrewEspresso,
+}
+
+// maxLookaheadDays limits the search for the next trigger, e.g. while a long vacation is being skipped
+const maxLookaheadDays = 366
+
+// UpcomingTrigger is a coffee the timer is going to make
+type UpcomingTrigger struct {
+	Time time.Time
+	Brew string
+}
+
+// SkippedTrigger is a scheduled coffee that is not made because it falls on a skip day
+type SkippedTrigger struct {
+	Time  time.Time
+	Brew  string
+	Event SkipEvent
+}
+
+type dailyTrigger struct {
+	hour, min, sec int
+	brew           string
 }
 
 type CoffeeTimer struct {
-	raspi                               raspberrypi
-	showStatusLengthMs                  int
-	isArmed                             bool
-	triggerHour, triggerMin, triggerSec int
-	triggerFunc                         func()
-	cancellableTimer                    *time.Timer
+	mu                 sync.Mutex
+	raspi              *raspberrypi
+	clock              Clock
+	showStatusLengthMs int
+	isArmed            bool
+	schedule           [7]dailyTrigger // indexed by time.Weekday
+	cron               *CronSchedule   // replaces the weekly schedule if set
+	cronBrew           string
+	sun                *sunTrigger  // replaces the times of the weekly schedule if set
+	geo                *GeoLocation // nil until SetGeoLocation, rules relative to sunrise or sunset never trigger without it
+	recipes            []string     // the brews the machine can make, any brew is accepted if nil
+	skipCalendar       *SkipCalendar
+	missedTriggers     MissedTriggerConfig
+	missed             []MissedTrigger // notified about, until dismissed
+	clockCheck         ClockTimer
+	lastClockCheck     time.Time
+	lastMonotonic      time.Duration // the clock's monotonic reading at the last clock check
+	brewFunc           func(brew string)
+	powerOnFunc        func(brew string)
+	warmUpLead         time.Duration
+	warmUpTimer        ClockTimer
+	armCheckFunc       func(brew string) error
+	armError           error // why the timer has refused to arm
+	changedFunc        func()
+	cancellableTimer   ClockTimer
+	nextTrigger        time.Time
+	snoozedFrom        time.Time // the scheduled time of a pending trigger that has been postponed
+	nextBrew           string
 }
 
-func NewCoffeeTimer(cfg CoffeeTimerConfig, raspi raspberrypi) *CoffeeTimer {
+func NewCoffeeTimer(cfg CoffeeTimerConfig, raspi *raspberrypi, clock Clock) *CoffeeTimer {
 
 	showStatusLengthMs := 2000
 
-	ct := CoffeeTimer{raspi: raspi, showStatusLengthMs: showStatusLengthMs, isArmed: false}
+	ct := CoffeeTimer{raspi: raspi, clock: clock, showStatusLengthMs: showStatusLengthMs, isArmed: false}
+
+	brew := cfg.Brew
+	if brew == "" {
+		brew = BrewEspresso
+	}
+	for d := range ct.schedule {
+		ct.schedule[d].brew = brew
+	}
+	ct.cronBrew = brew
 
 	ct.SetTriggerFunc(func() {})
-	ct.SetTriggerTime(cfg.TriggerTime)
+	ct.SetChangedFunc(func() {})
+	if isClockTime(cfg.TriggerTime) {
+		ct.SetTriggerTime(cfg.TriggerTime)
+	} else if err := ct.setTriggerRule(cfg.TriggerTime, brew, false); err != nil {
+		// the location for a rule relative to sunrise or sunset is only set afterwards, see Scheduler.AddAlarm
+		log.Println(err)
+		log.Println("Leaving trigger time unchanged")
+	}
+
+	for dayName, entry := range cfg.Schedule {
+		day, ok := ParseWeekday(dayName)
+		if !ok {
+			log.Printf("Unknown weekday '%s' in timer schedule, ignoring\n", dayName)
+			continue
+		}
+		if err := ct.SetDaySchedule(day, entry.Time, entry.Brew); err != nil {
+			log.Println(err)
+		}
+	}
 
 	return &ct
 }
 
-// Arm sets the timer for the currently configured Trigger Time, using the currently configure Trigger Func.
-// Changes ot the Trigger Time or Func are only active after re-ariming.
+// checkBrews returns an error if the timer would make a brew that isn't among the recipes. Any brew is accepted if recipes is nil.
+func (cfg CoffeeTimerConfig) checkBrews(recipes []string) error {
+
+	if err := checkBrew(cfg.Brew, recipes); err != nil {
+		return err
+	}
+	for dayName, entry := range cfg.Schedule {
+		if err := checkBrew(entry.Brew, recipes); err != nil {
+			return fmt.Errorf("%s: %w", dayName, err)
+		}
+	}
+	return nil
+}
+
+// checkBrew returns an error if brew isn't among the recipes. An empty brew, BrewNone, or any brew if recipes is nil, are accepted.
+func checkBrew(brew string, recipes []string) error {
+
+	if brew == "" || brew == BrewNone || recipes == nil {
+		return nil
+	}
+	for _, r := range recipes {
+		if r == brew {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown recipe '%s', expected one of %s or %s", brew, strings.Join(recipes, ", "), BrewNone)
+}
+
+// Arm sets the timer for the next scheduled day, using the currently configured Brew Func.
+// After triggering, the timer re-arms itself for the following scheduled day.
 func (ct *CoffeeTimer) Arm() {
+	ct.mu.Lock()
+	ct.arm()
+	ct.mu.Unlock()
+
+	ct.changed()
+}
+
+func (ct *CoffeeTimer) arm() {
 
 	if ct.cancellableTimer != nil {
 		// a timer is already going - stop it and create a new one below
-		ct.Disarm()
+		ct.disarm()
 	}
 
-	now := time.Now()
-
-	triggerTime := time.Date(now.Year(), now.Month(), now.Day(), ct.triggerHour, ct.triggerMin, ct.triggerSec, 0, now.Location())
-	if triggerTime.Before(now) {
-		triggerTime = triggerTime.Add(24 * time.Hour)
+	now := ct.clock.Now()
+	triggerTime, brew, ok := ct.next(now)
+	if !ok {
+		log.Println("CoffeeTimer has no coffee scheduled in the coming year, not arming")
+		return
+	}
+	for _, skipped := range ct.skipped(now, triggerTime) {
+		log.Printf("Skipping %s at %s because of '%s'\n", skipped.Brew, skipped.Time, skipped.Event.Summary)
+	}
+	if ct.armCheckFunc != nil {
+		if err := ct.armCheckFunc(brew); err != nil {
+			log.Printf("Not arming CoffeeTimer for %s at %s: %v\n", brew, triggerTime, err)
+			ct.armError = err
+			return
+		}
 	}
-	ct.cancellableTimer = time.AfterFunc(time.Until(triggerTime), ct.triggerFunc)
-	log.Println("CoffeeTimer triggering at", triggerTime)
+	ct.armError = nil
+
+	ct.cancellableTimer = ct.clock.AfterFunc(triggerTime.Sub(now), func() { ct.trigger(triggerTime, brew) })
+	ct.nextTrigger = triggerTime
+	ct.nextBrew = brew
+	ct.snoozedFrom = time.Time{}
+	ct.scheduleWarmUp(now)
+	log.Println("CoffeeTimer triggering", brew, "at", triggerTime)
 	ct.isArmed = true
+	ct.startClockCheck()
+
+}
+
+// Snooze postpones the pending coffee by d and shows the new time. Only this one coffee moves,
+// the schedule stays as it is and the timer re-arms for the following trigger as usual once it has been made.
+func (ct *CoffeeTimer) Snooze(d time.Duration) {
+	if _, ok := ct.Postpone(d); ok {
+		ct.ShowArmedStatus()
+	}
+}
+
+// Postpone is Snooze without showing the status. It returns the new time, ok is false if no coffee is pending.
+func (ct *CoffeeTimer) Postpone(d time.Duration) (time.Time, bool) {
+	ct.mu.Lock()
+	triggerTime, ok := ct.postpone(d)
+	ct.mu.Unlock()
+
+	if ok {
+		ct.changed()
+	}
+	return triggerTime, ok
+}
+
+func (ct *CoffeeTimer) postpone(d time.Duration) (time.Time, bool) {
+
+	if !ct.isArmed || ct.cancellableTimer == nil {
+		log.Println("CoffeeTimer is not armed, nothing to postpone")
+		return time.Time{}, false
+	}
+
+	ct.cancellableTimer.Stop()
+
+	if ct.snoozedFrom.IsZero() {
+		ct.snoozedFrom = ct.nextTrigger
+	}
+	triggerTime, brew := ct.nextTrigger.Add(d), ct.nextBrew
+	ct.cancellableTimer = ct.clock.AfterFunc(triggerTime.Sub(ct.clock.Now()), func() { ct.trigger(triggerTime, brew) })
+	ct.nextTrigger = triggerTime
+	ct.scheduleWarmUp(ct.clock.Now())
+	log.Printf("Postponing %s scheduled at %s by %s to %s\n", brew, ct.snoozedFrom, d, triggerTime)
+
+	return triggerTime, true
+}
+
+// scheduleWarmUp sets a timer for switching the machine on ahead of the pending trigger, so it has heated up by then.
+// If that time has already passed, the machine is switched on when brewing, making the coffee late by its heating time.
+func (ct *CoffeeTimer) scheduleWarmUp(now time.Time) {
+
+	if ct.warmUpTimer != nil {
+		ct.warmUpTimer.Stop()
+		ct.warmUpTimer = nil
+	}
+	if ct.warmUpLead <= 0 {
+		return
+	}
+
+	powerOnTime, triggerTime, brew := ct.nextTrigger.Add(-ct.warmUpLead), ct.nextTrigger, ct.nextBrew
+	if powerOnTime.Before(now) {
+		log.Printf("Too late for warming up the machine ahead of %s, switching it on when brewing\n", triggerTime)
+		return
+	}
+	ct.warmUpTimer = ct.clock.AfterFunc(powerOnTime.Sub(now), func() { ct.powerOn(triggerTime, brew) })
+}
+
+func (ct *CoffeeTimer) powerOn(triggerTime time.Time, brew string) {
+
+	ct.mu.Lock()
+	pending := ct.isArmed && ct.nextTrigger.Equal(triggerTime)
+	powerOnFunc := ct.powerOnFunc
+	ct.mu.Unlock()
+
+	if !pending {
+		return
+	}
+	log.Println("Warming up the machine for", brew, "at", triggerTime)
+	powerOnFunc(brew)
+}
+
+// next returns the first trigger strictly after now that does not fall on a skip day
+func (ct *CoffeeTimer) next(now time.Time) (time.Time, string, bool) {
+
+	limit := now.AddDate(0, 0, maxLookaheadDays)
+	for {
+		triggerTime, brew, ok := ct.scheduled(now)
+		if !ok || triggerTime.After(limit) {
+			return time.Time{}, "", false
+		}
+		if _, skip := ct.skipCalendar.Skips(triggerTime); !skip {
+			return triggerTime, brew, true
+		}
+		now = triggerTime
+	}
+}
+
+// skipped lists the scheduled triggers after from and up to until that fall on a skip day
+func (ct *CoffeeTimer) skipped(from, until time.Time) []SkippedTrigger {
+
+	var skipped []SkippedTrigger
+	for {
+		triggerTime, brew, ok := ct.scheduled(from)
+		if !ok || triggerTime.After(until) {
+			return skipped
+		}
+		if event, skip := ct.skipCalendar.Skips(triggerTime); skip {
+			skipped = append(skipped, SkippedTrigger{Time: triggerTime, Brew: brew, Event: event})
+		}
+		from = triggerTime
+	}
+}
+
+// scheduled returns the first trigger of the cron or weekly schedule strictly after now, regardless of skip days.
+// Each day's trigger is worked out from the wall clock, so it stays at the same local time across daylight saving changes.
+func (ct *CoffeeTimer) scheduled(now time.Time) (time.Time, string, bool) {
+
+	if ct.cron != nil {
+		triggerTime := ct.cron.Next(now)
+		return triggerTime, ct.cronBrew, !triggerTime.IsZero()
+	}
+
+	// a week covers the weekly schedule, but in polar regions the sun may not rise for much longer
+	lookaheadDays := 7
+	if ct.sun != nil {
+		if ct.geo == nil {
+			return time.Time{}, "", false
+		}
+		lookaheadDays = maxLookaheadDays
+	}
+
+	year, month, day := now.Date()
+	for i := 0; i <= lookaheadDays; i++ {
+		date := time.Date(year, month, day+i, 12, 0, 0, 0, now.Location())
+		dt := ct.schedule[date.Weekday()]
+		if dt.brew == BrewNone {
+			continue
+		}
+
+		var triggerTime time.Time
+		if ct.sun != nil {
+			var ok bool
+			if triggerTime, ok = ct.sun.at(year, month, day+i, *ct.geo, now.Location()); !ok {
+				continue
+			}
+		} else {
+			triggerTime = wallClockTime(year, month, day+i, dt.hour, dt.min, dt.sec, now.Location())
+		}
+
+		if triggerTime.After(now) {
+			return triggerTime, dt.brew, true
+		}
+	}
+
+	return time.Time{}, "", false
+}
+
+func (ct *CoffeeTimer) trigger(triggerTime time.Time, brew string) {
+
+	ct.mu.Lock()
+	now := ct.clock.Now()
+	if now.Before(triggerTime) && ct.isArmed && ct.nextTrigger.Equal(triggerTime) {
+		// the wall clock has been put back since arming, wait until the trigger time comes round on the new clock
+		log.Printf("CoffeeTimer fired %s early as the clock has been put back, waiting until %s\n", triggerTime.Sub(now), triggerTime)
+		ct.cancellableTimer = ct.clock.AfterFunc(triggerTime.Sub(now), func() { ct.trigger(triggerTime, brew) })
+		ct.mu.Unlock()
+		return
+	}
+	brewFunc := ct.brewFunc
+	ct.mu.Unlock()
+
+	if late := now.Sub(triggerTime); late > missedTriggerTolerance {
+		ct.catchUp(triggerTime, brew, late)
+	} else {
+		log.Println("TRIGGERING!")
+		brewFunc(brew)
+	}
+
+	ct.mu.Lock()
+	// only re-arm if nobody has disarmed or re-armed the timer while the coffee was being made
+	if ct.isArmed && ct.nextTrigger.Equal(triggerTime) {
+		ct.arm()
+	}
+	ct.mu.Unlock()
+
+	ct.changed()
 
 }
 
 func (ct *CoffeeTimer) Disarm() {
+	ct.mu.Lock()
+	ct.disarm()
+	ct.mu.Unlock()
+
+	ct.changed()
+}
+
+func (ct *CoffeeTimer) disarm() {
 
 	if ct.cancellableTimer != nil {
 		// a timer is going - stop it
 		ct.cancellableTimer.Stop()
 	}
+	ct.stopClockCheck()
+	if ct.warmUpTimer != nil {
+		ct.warmUpTimer.Stop()
+		ct.warmUpTimer = nil
+	}
 
 	ct.cancellableTimer = nil
+	ct.nextTrigger = time.Time{}
+	ct.nextBrew = ""
+	ct.snoozedFrom = time.Time{}
 	ct.isArmed = false
 
 }
 
 func (ct *CoffeeTimer) ToggleArmedStatus() {
 
+	ct.mu.Lock()
 	if ct.isArmed {
-		ct.Disarm()
+		ct.disarm()
 	} else {
-		ct.Arm()
+		ct.arm()
 	}
+	ct.mu.Unlock()
 
+	ct.changed()
 	ct.ShowArmedStatus()
 
 }
 
-func (ct CoffeeTimer) ShowArmedStatus() {
-	ct.raspi.ActivateArmedStatusLED(ct.isArmed, ct.showStatusLengthMs, fmt.Sprintf("%d:%02d:%02d", ct.triggerHour, ct.triggerMin, ct.triggerSec))
+func (ct *CoffeeTimer) ShowArmedStatus() {
+	ct.mu.Lock()
+	isArmed := ct.isArmed
+	triggerStr := fmt.Sprintf("%s at %s", ct.nextBrew, ct.nextTrigger.Format("Mon 15:04:05"))
+	if !ct.snoozedFrom.IsZero() {
+		triggerStr += fmt.Sprintf(" (snoozed from %s)", ct.snoozedFrom.Format("15:04:05"))
+	}
+	ct.mu.Unlock()
+
+	ct.raspi.ActivateArmedStatusLED(isArmed, ct.showStatusLengthMs, triggerStr)
 }
 
-func (ct CoffeeTimer) IsArmed() bool {
+func (ct *CoffeeTimer) IsArmed() bool {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
 	return ct.isArmed
 }
 
-func (ct *CoffeeTimer) GetTriggerTime() string {
-	return fmt.Sprintf("%02d:%02d", ct.triggerHour, ct.triggerMin)
+// NextTrigger returns the time and brew type of the pending trigger, ok is false if the timer is not armed
+func (ct *CoffeeTimer) NextTrigger() (triggerTime time.Time, brew string, ok bool) {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	return ct.nextTrigger, ct.nextBrew, ct.isArmed
+}
+
+// SnoozedFrom returns the scheduled time of the pending trigger, ok is false unless it has been postponed
+func (ct *CoffeeTimer) SnoozedFrom() (scheduled time.Time, ok bool) {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	return ct.snoozedFrom, !ct.snoozedFrom.IsZero()
 }
 
-// sets the trigger for the next occurrence of HH:MM, usually tomorrow morning - DAYLIGHT SAVINGS BEHAVIOUR UNKNOWN!
+// DaySchedule returns the trigger time as "hh:mm" (or "hh:mm:ss" if seconds are set) and the brew type for the given day
+func (ct *CoffeeTimer) DaySchedule(day time.Weekday) (string, string) {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+
+	dt := ct.schedule[day]
+	if dt.sec != 0 {
+		return fmt.Sprintf("%02d:%02d:%02d", dt.hour, dt.min, dt.sec), dt.brew
+	}
+	return fmt.Sprintf("%02d:%02d", dt.hour, dt.min), dt.brew
+}
+
+// SkippedTriggers lists the scheduled coffees from now until the given time that fall on a skip day
+func (ct *CoffeeTimer) SkippedTriggers(until time.Time) []SkippedTrigger {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+
+	return ct.skipped(ct.clock.Now(), until)
+}
+
+// SetSkipCalendar sets the calendar of days on which no coffee is made, nil means coffee every scheduled day
+func (ct *CoffeeTimer) SetSkipCalendar(c *SkipCalendar) {
+
+	defer ct.changed()
+
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+
+	ct.skipCalendar = c
+
+	// re-arm, as the next trigger may now be skipped or no longer be skipped
+	if ct.isArmed {
+		ct.arm()
+	}
+
+}
+
+// Config returns the timer's schedule with every day spelled out, so a timer created from it has the same schedule
+func (ct *CoffeeTimer) Config() CoffeeTimerConfig {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+
+	cfg := CoffeeTimerConfig{Schedule: map[string]ScheduleEntry{}}
+	for _, day := range Weekdays {
+		dt := ct.schedule[day]
+		entry := ScheduleEntry{Time: fmt.Sprintf("%d:%02d:%02d", dt.hour, dt.min, dt.sec), Brew: dt.brew}
+		cfg.Schedule[strings.ToLower(day.String())] = entry
+		if day == time.Monday {
+			cfg.TriggerTime, cfg.Brew = entry.Time, entry.Brew
+		}
+	}
+	if rule, brew := ct.triggerRule(); rule != "" {
+		cfg.TriggerTime, cfg.Brew = rule, brew
+	}
+	return cfg
+}
+
+// TriggerRule returns the cron expression or sunrise/sunset rule replacing the weekly schedule's times, and the brew type
+// made by a cron expression. rule is empty if the weekly schedule is in use.
+func (ct *CoffeeTimer) TriggerRule() (rule string, brew string) {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+
+	return ct.triggerRule()
+}
+
+func (ct *CoffeeTimer) triggerRule() (string, string) {
+	switch {
+	case ct.cron != nil:
+		return ct.cron.String(), ct.cronBrew
+	case ct.sun != nil:
+		return ct.sun.String(), ct.cronBrew
+	default:
+		return "", ct.cronBrew
+	}
+}
+
+// Upcoming lists the next n coffees the timer is going to make, leaving out skip days
+func (ct *CoffeeTimer) Upcoming(n int) []UpcomingTrigger {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+
+	var upcoming []UpcomingTrigger
+	t := ct.clock.Now()
+	for len(upcoming) < n {
+		triggerTime, brew, ok := ct.next(t)
+		if !ok {
+			break
+		}
+		upcoming = append(upcoming, UpcomingTrigger{Time: triggerTime, Brew: brew})
+		t = triggerTime
+	}
+	return upcoming
+}
+
+// SetTriggerTime sets the same trigger time "hh:mm[:ss]" for every day of the week, leaving the brew types unchanged,
+// or sets a cron expression or sunrise/sunset rule, see SetTriggerRule.
+// On daylight saving switch-over nights a skipped time triggers at the switch-over, a repeated time triggers once.
 func (ct *CoffeeTimer) SetTriggerTime(timeStr string) {
 
-	var hour, min int
-	var err error
-	fields := strings.Split(timeStr, ":")
-	if len(fields) < 2 || len(fields) > 3 {
-		log.Printf("Unexpected trigger time format '%s', expected 'hh:mm[:ss]'\n", timeStr)
-		log.Printf("Leaving trigger time unchanged at %d:%02d:%02d\n", ct.triggerHour, ct.triggerMin, ct.triggerSec)
+	if !isClockTime(timeStr) {
+		ct.mu.Lock()
+		brew := ct.cronBrew
+		ct.mu.Unlock()
+
+		if err := ct.SetTriggerRule(timeStr, brew); err != nil {
+			log.Println(err)
+			log.Println("Leaving trigger time unchanged")
+		}
 		return
 	}
 
-	hour, err = strconv.Atoi(fields[0])
+	hour, min, sec, err := parseClockTime(timeStr)
 	if err != nil {
-		log.Printf("Unexpected trigger time format '%s', expected 'hh:mm[:ss]'\n", timeStr)
-		log.Printf("Leaving trigger time unchanged at %d:%02d:%02d\n", ct.triggerHour, ct.triggerMin, ct.triggerSec)
+		log.Println(err)
+		log.Println("Leaving trigger time unchanged")
 		return
 	}
 
-	min, err = strconv.Atoi(fields[1])
-	if err != nil {
-		log.Printf("Unexpected trigger time format '%s', expected 'hh:mm[:ss]'\n", timeStr)
-		log.Printf("Leaving trigger time unchanged at %d:%02d:%02d\n", ct.triggerHour, ct.triggerMin, ct.triggerSec)
-		return
+	defer ct.changed()
+
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+
+	log.Printf("Setting trigger time for all days to %d:%02d:%02d\n", hour, min, sec)
+	ct.cron = nil
+	ct.sun = nil
+	for d := range ct.schedule {
+		ct.schedule[d].hour = hour
+		ct.schedule[d].min = min
+		ct.schedule[d].sec = sec
 	}
 
-	sec := 0
-	if len(fields) == 3 {
-		sec, err = strconv.Atoi(fields[2])
+	// re-arm with new trigger time if currently armed
+	if ct.isArmed {
+		ct.arm()
+	}
+
+}
+
+// SetDaySchedule sets the trigger time and brew type for one day of the week.
+// An empty timeStr or brew leaves the respective setting unchanged, BrewNone means no coffee on that day.
+// An invalid time or a brew that isn't among the recipes leaves the day unchanged and returns an error.
+func (ct *CoffeeTimer) SetDaySchedule(day time.Weekday, timeStr string, brew string) error {
+
+	defer ct.changed()
+
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+
+	if err := checkBrew(brew, ct.recipes); err != nil {
+		return fmt.Errorf("%s: %w", day, err)
+	}
+	if timeStr != "" {
+		hour, min, sec, err := parseClockTime(timeStr)
 		if err != nil {
-			log.Printf("Unexpected trigger time format '%s', expected 'hh:mm:ss'\n", timeStr)
-			log.Printf("Leaving trigger time unchanged at %d:%02d:%02d\n", ct.triggerHour, ct.triggerMin, ct.triggerSec)
-			return
+			return fmt.Errorf("%s: %w", day, err)
 		}
+		ct.schedule[day].hour = hour
+		ct.schedule[day].min = min
+		ct.schedule[day].sec = sec
 	}
 
-	log.Printf("Setting trigger time to %d:%02d:%02d\n", hour, min, sec)
-	ct.triggerHour = hour
-	ct.triggerMin = min
-	ct.triggerSec = sec
+	if brew != "" {
+		ct.schedule[day].brew = brew
+	}
 
-	// re-arm with new trigger time if currently armed
+	dt := ct.schedule[day]
+	log.Printf("Schedule for %s set to %s at %d:%02d:%02d\n", day, dt.brew, dt.hour, dt.min, dt.sec)
+
+	// re-arm with new schedule if currently armed
+	if ct.isArmed {
+		ct.arm()
+	}
+
+	return nil
+}
+
+// SetTriggerRule replaces the weekly schedule with a rule, which is either
+//   - a cron expression, making the given brew type every time it fires, or
+//   - a time relative to sunrise or sunset like "sunrise-20m", worked out anew for each day of the weekly schedule,
+//     making that day's brew type.
+//
+// An empty rule switches back to the times of the weekly schedule. A rule relative to sunrise or sunset is refused
+// until the location has been set, see SetGeoLocation.
+func (ct *CoffeeTimer) SetTriggerRule(rule string, brew string) error {
+	return ct.setTriggerRule(rule, brew, true)
+}
+
+func (ct *CoffeeTimer) setTriggerRule(rule string, brew string, requireGeo bool) error {
+
+	cs, st, err := parseTriggerRule(rule)
+	if err != nil {
+		return err
+	}
+
+	defer ct.changed()
+
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+
+	if st != nil && requireGeo && ct.geo == nil {
+		return ErrNoGeoLocation
+	}
+
+	if err := checkBrew(brew, ct.recipes); err != nil {
+		return err
+	}
+
+	ct.cron = cs
+	ct.sun = st
+	if brew != "" {
+		ct.cronBrew = brew
+	}
+
+	switch {
+	case cs != nil:
+		log.Printf("Setting cron trigger '%s' making %s\n", cs, ct.cronBrew)
+	case st != nil:
+		log.Printf("Setting trigger at %s\n", st)
+	default:
+		log.Println("Switching back to the weekly schedule")
+	}
+
+	// re-arm with new trigger if currently armed
 	if ct.isArmed {
-		ct.Disarm()
-		ct.Arm()
+		ct.arm()
+	}
+
+	return nil
+}
+
+// ErrNoGeoLocation refuses a rule relative to sunrise or sunset, which would otherwise be worked out for 0°N 0°E
+var ErrNoGeoLocation = errors.New("a trigger relative to sunrise or sunset needs the location of the coffee machine, see location in the config")
+
+// ValidateTriggerRule returns why a cron expression or sunrise/sunset rule cannot be used, or nil
+func ValidateTriggerRule(rule string) error {
+	_, _, err := parseTriggerRule(rule)
+	return err
+}
+
+func parseTriggerRule(rule string) (*CronSchedule, *sunTrigger, error) {
+	switch {
+	case strings.TrimSpace(rule) == "":
+		return nil, nil, nil
+	case isSunTrigger(rule):
+		st, err := parseSunTrigger(rule)
+		return nil, st, err
+	default:
+		cs, err := ParseCron(rule)
+		return cs, nil, err
 	}
+}
+
+// SetGeoLocation sets where the coffee machine is, for triggers relative to sunrise or sunset
+func (ct *CoffeeTimer) SetGeoLocation(geo GeoLocation) {
+
+	defer ct.changed()
+
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+
+	ct.geo = &geo
+
+	// re-arm, as sunrise and sunset have moved
+	if ct.isArmed && ct.sun != nil {
+		ct.arm()
+	}
+
+}
+
+// SetRecipes sets the brews the machine can make. Brews that aren't among them are refused from then on,
+// see SetDaySchedule and SetTriggerRule. Any brew is accepted if recipes is nil.
+func (ct *CoffeeTimer) SetRecipes(recipes []string) {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
 
+	ct.recipes = recipes
 }
 
+// SetTriggerFunc sets a function that is triggered regardless of the scheduled brew type
 func (ct *CoffeeTimer) SetTriggerFunc(f func()) {
+	ct.SetBrewFunc(func(string) { f() })
+}
 
-	// ensure the timer gets disarmed after it has triggered the func
-	funcWithDisarm := func() {
-		log.Println("TRIGGERING!")
-		f()
-		ct.Disarm()
-		log.Println("Disarmed")
+// SetBrewFunc sets the function that makes the scheduled brew type when the timer triggers
+func (ct *CoffeeTimer) SetBrewFunc(f func(brew string)) {
+
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+
+	ct.brewFunc = f
+
+	// re-arm with new brew func if currently armed
+	if ct.isArmed {
+		ct.arm()
 	}
 
-	ct.triggerFunc = funcWithDisarm
+}
+
+// SetPowerOnFunc sets a function switching the machine on, which is called lead ahead of each trigger.
+// The brew func then only has to wait for whatever is left of the heating time. A lead of 0 disables the warm-up phase.
+func (ct *CoffeeTimer) SetPowerOnFunc(f func(brew string), lead time.Duration) {
+
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+
+	ct.powerOnFunc = f
+	ct.warmUpLead = lead
+	if f == nil {
+		ct.warmUpLead = 0
+	}
 
-	// re-arm with new trigger func if currently armed
 	if ct.isArmed {
-		ct.Disarm()
-		ct.Arm()
+		ct.scheduleWarmUp(ct.clock.Now())
+	}
+
+}
+
+// SetArmCheckFunc sets a function that is asked before arming whether the next coffee can be made, e.g. whether there are capsules left.
+// If it returns an error, the timer stays disarmed until it is armed again.
+func (ct *CoffeeTimer) SetArmCheckFunc(f func(brew string) error) {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+
+	ct.armCheckFunc = f
+}
+
+// ArmError returns why the timer has last refused to arm, nil if it has armed since
+func (ct *CoffeeTimer) ArmError() error {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	return ct.armError
+}
+
+// RecheckArming disarms the timer if its pending coffee can no longer be made, see SetArmCheckFunc
+func (ct *CoffeeTimer) RecheckArming() {
+	ct.mu.Lock()
+	if !ct.isArmed || ct.armCheckFunc == nil {
+		ct.mu.Unlock()
+		return
+	}
+	triggerTime, brew := ct.nextTrigger, ct.nextBrew
+	err := ct.armCheckFunc(brew)
+	if err != nil {
+		log.Printf("Disarming CoffeeTimer for %s at %s: %v\n", brew, triggerTime, err)
+		ct.disarm()
+		ct.armError = err
+	}
+	ct.mu.Unlock()
+
+	if err != nil {
+		ct.changed()
+	}
+}
+
+// SetChangedFunc sets a function that is called after the schedule or armed status has changed.
+// It is called without holding the timer's lock, so it may call back into the timer.
+func (ct *CoffeeTimer) SetChangedFunc(f func()) {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+
+	ct.changedFunc = f
+}
+
+func (ct *CoffeeTimer) changed() {
+	ct.mu.Lock()
+	changedFunc := ct.changedFunc
+	ct.mu.Unlock()
+
+	changedFunc()
+}
+
+// ParseWeekday converts a weekday name like "monday" or "Mon" to a time.Weekday
+func ParseWeekday(name string) (time.Weekday, bool) {
+	name = strings.ToLower(name)
+	for _, d := range Weekdays {
+		dayName := strings.ToLower(d.String())
+		if name == dayName || name == dayName[:3] {
+			return d, true
+		}
+	}
+	return time.Sunday, false
+}
+
+// isClockTime tells a time of day like "6:45" apart from a cron expression or sunrise/sunset rule, which never contain a colon
+func isClockTime(timeStr string) bool {
+	return strings.Contains(timeStr, ":")
+}
+
+// ValidateClockTime returns why a time of day like "6:45" cannot be used, or nil
+func ValidateClockTime(timeStr string) error {
+	_, _, _, err := parseClockTime(timeStr)
+	return err
+}
+
+func parseClockTime(timeStr string) (hour, min, sec int, err error) {
+
+	for _, layout := range []string{"15:04", "15:04:05"} {
+		if t, err := time.Parse(layout, strings.TrimSpace(timeStr)); err == nil {
+			return t.Hour(), t.Minute(), t.Second(), nil
+		}
 	}
 
+	return 0, 0, 0, fmt.Errorf("unexpected trigger time format '%s', expected 'hh:mm[:ss]' or a cron expression", timeStr)
 }
@@ -5,30 +5,28 @@ import (
 	"time"
 )
 
+// testStart is a Monday morning, well before the default trigger time
+var testStart = time.Date(2023, time.January, 2, 6, 0, 0, 0, time.Local)
+
 func TestTriggerIsRearmedFollowingSettingTriggerTime(t *testing.T) {
 
-	dummyRaspi := NewRaspi(NoRaspiInUseConfig)
-	ct := NewCoffeeTimer(CoffeeTimerConfigDefaults, dummyRaspi)
+	clock := newFakeClock(testStart)
+	dummyRaspi := newTestRaspi()
+	ct := NewCoffeeTimer(CoffeeTimerConfigDefaults, dummyRaspi, clock)
 
-	// set up monitoring channel for trigger function
-	triggerFuncChannel := make(chan bool)
-	ct.SetTriggerFunc(func() { triggerFuncChannel <- true })
+	// set up monitoring flag for trigger function
+	coffeeTimerHasTriggered := false
+	ct.SetTriggerFunc(func() { coffeeTimerHasTriggered = true })
 
 	// arm the trigger
 	ct.Arm()
 
-	// set up trigger to be as soon as possible (currently finest granularity is minute)
-	testTriggerTime := time.Now().Add(1 * time.Second).Format("15:04:05")
+	// set up trigger to be as soon as possible
+	testTriggerTime := clock.Now().Add(1 * time.Second).Format("15:04:05")
 	ct.SetTriggerTime(testTriggerTime)
 
 	// if trigger is armed already, setting the trigger time should automatically re-arm it
-
-	// set up monitoring timer that sends "false" to the channel after a timeout
-	timeout := time.Now().Add(2 * time.Second)
-	ct.cancellableTimer = time.AfterFunc(time.Until(timeout), func() { triggerFuncChannel <- false })
-
-	// check which one gets triggered first: the coffeeTime triggerFunc, or the timeout
-	coffeeTimerHasTriggered := <-triggerFuncChannel
+	clock.Advance(2 * time.Second)
 
 	if !coffeeTimerHasTriggered {
 		t.Fatal("coffeeTimer has not triggered in time")
@@ -38,28 +36,23 @@ func TestTriggerIsRearmedFollowingSettingTriggerTime(t *testing.T) {
 
 func TestTriggerIsRearmedFollowingSettingTriggerFunc(t *testing.T) {
 
-	dummyRaspi := NewRaspi(NoRaspiInUseConfig)
-	ct := NewCoffeeTimer(CoffeeTimerConfigDefaults, dummyRaspi)
+	clock := newFakeClock(testStart)
+	dummyRaspi := newTestRaspi()
+	ct := NewCoffeeTimer(CoffeeTimerConfigDefaults, dummyRaspi, clock)
 
-	// set up trigger to be as soon as possible (currently finest granularity is minute)
-	testTriggerTime := time.Now().Add(1 * time.Second).Format("15:04:05")
+	// set up trigger to be as soon as possible
+	testTriggerTime := clock.Now().Add(1 * time.Second).Format("15:04:05")
 	ct.SetTriggerTime(testTriggerTime)
 
 	// arm the trigger
 	ct.Arm()
 
-	// set up monitoring channel for trigger function
-	triggerFuncChannel := make(chan bool)
-	ct.SetTriggerFunc(func() { triggerFuncChannel <- true })
+	// set up monitoring flag for trigger function
+	coffeeTimerHasTriggered := false
+	ct.SetTriggerFunc(func() { coffeeTimerHasTriggered = true })
 
 	// if trigger is armed already, setting the trigger func should automatically re-arm it
-
-	// set up monitoring timer that sends "false" to the channel after a timeout
-	timeout := time.Now().Add(2 * time.Second)
-	ct.cancellableTimer = time.AfterFunc(time.Until(timeout), func() { triggerFuncChannel <- false })
-
-	// check which one gets triggered first: the coffeeTime triggerFunc, or the timeout
-	coffeeTimerHasTriggered := <-triggerFuncChannel
+	clock.Advance(2 * time.Second)
 
 	if !coffeeTimerHasTriggered {
 		t.Fatal("coffeeTimer has not triggered in time")
@@ -69,26 +62,22 @@ func TestTriggerIsRearmedFollowingSettingTriggerFunc(t *testing.T) {
 
 func TestArmedTrigger(t *testing.T) {
 
-	dummyRaspi := NewRaspi(NoRaspiInUseConfig)
-	ct := NewCoffeeTimer(CoffeeTimerConfigDefaults, dummyRaspi)
+	clock := newFakeClock(testStart)
+	dummyRaspi := newTestRaspi()
+	ct := NewCoffeeTimer(CoffeeTimerConfigDefaults, dummyRaspi, clock)
 
-	// set up monitoring channel for trigger function
-	triggerFuncChannel := make(chan bool)
-	ct.SetTriggerFunc(func() { triggerFuncChannel <- true })
+	// set up monitoring flag for trigger function
+	coffeeTimerHasTriggered := false
+	ct.SetTriggerFunc(func() { coffeeTimerHasTriggered = true })
 
-	// set up trigger to be as soon as possible (currently finest granularity is minute)
-	testTriggerTime := time.Now().Add(1 * time.Second).Format("15:04:05")
+	// set up trigger to be as soon as possible
+	testTriggerTime := clock.Now().Add(1 * time.Second).Format("15:04:05")
 	ct.SetTriggerTime(testTriggerTime)
 
 	// arm the trigger
 	ct.Arm()
 
-	// set up monitoring timer that sends "false" to the channel after a timeout
-	timeout := time.Now().Add(2 * time.Second)
-	ct.cancellableTimer = time.AfterFunc(time.Until(timeout), func() { triggerFuncChannel <- false })
-
-	// check which one gets triggered first: the coffeeTime triggerFunc, or the timeout
-	coffeeTimerHasTriggered := <-triggerFuncChannel
+	clock.Advance(2 * time.Second)
 
 	if !coffeeTimerHasTriggered {
 		t.Fatal("coffeeTimer has not triggered in time")
@@ -98,29 +87,161 @@ func TestArmedTrigger(t *testing.T) {
 
 func TestDisarmedTrigger(t *testing.T) {
 
-	dummyRaspi := NewRaspi(NoRaspiInUseConfig)
-	ct := NewCoffeeTimer(CoffeeTimerConfigDefaults, dummyRaspi)
+	clock := newFakeClock(testStart)
+	dummyRaspi := newTestRaspi()
+	ct := NewCoffeeTimer(CoffeeTimerConfigDefaults, dummyRaspi, clock)
 
-	// set up monitoring channel for trigger function
-	triggerFuncChannel := make(chan bool)
-	ct.SetTriggerFunc(func() { triggerFuncChannel <- true })
+	// set up monitoring flag for trigger function
+	coffeeTimerHasTriggered := false
+	ct.SetTriggerFunc(func() { coffeeTimerHasTriggered = true })
 
-	// set up trigger to be as soon as possible (currently finest granularity is minute)
-	testTriggerTime := time.Now().Add(1 * time.Second).Format("15:04:05")
+	// set up trigger to be as soon as possible
+	testTriggerTime := clock.Now().Add(1 * time.Second).Format("15:04:05")
 	ct.SetTriggerTime(testTriggerTime)
 
 	// arm the trigger
 	ct.Disarm()
 
-	// set up monitoring timer that sends "false" to the channel after a timeout
-	timeout := time.Now().Add(2 * time.Second)
-	ct.cancellableTimer = time.AfterFunc(time.Until(timeout), func() { triggerFuncChannel <- false })
-
-	// check which one gets triggered first: the coffeeTime triggerFunc, or the timeout
-	coffeeTimerHasTriggered := <-triggerFuncChannel
+	clock.Advance(2 * time.Second)
 
 	if coffeeTimerHasTriggered {
 		t.Fatal("coffeeTimer has triggered though it was disarmed")
 	}
 
 }
+
+func TestTimerStaysArmedAfterTriggering(t *testing.T) {
+
+	clock := newFakeClock(testStart)
+	dummyRaspi := newTestRaspi()
+	ct := NewCoffeeTimer(CoffeeTimerConfigDefaults, dummyRaspi, clock)
+
+	triggerCount := 0
+	ct.SetTriggerFunc(func() { triggerCount++ })
+
+	ct.SetTriggerTime("6:30")
+	ct.Arm()
+
+	// a week later, the coffee should have been made every day
+	clock.Advance(7 * 24 * time.Hour)
+
+	if triggerCount != 7 {
+		t.Fatalf("coffeeTimer has triggered %d times in a week, expected 7", triggerCount)
+	}
+	if !ct.IsArmed() {
+		t.Fatal("coffeeTimer is no longer armed after triggering")
+	}
+
+}
+
+func TestNextTriggerFollowsWeeklySchedule(t *testing.T) {
+
+	cfg := CoffeeTimerConfig{
+		TriggerTime: "6:45",
+		Brew:        BrewLungo,
+		Schedule: map[string]ScheduleEntry{
+			"saturday": {Time: "9:00", Brew: BrewEspresso},
+			"sunday":   {Brew: BrewNone},
+		},
+	}
+	ct := NewCoffeeTimer(cfg, newTestRaspi(), SystemClock)
+
+	// Friday 2023-01-06, after the Friday trigger
+	now := time.Date(2023, 1, 6, 7, 0, 0, 0, time.Local)
+
+	triggerTime, brew, ok := ct.next(now)
+	if !ok || brew != BrewEspresso || !triggerTime.Equal(time.Date(2023, 1, 7, 9, 0, 0, 0, time.Local)) {
+		t.Fatalf("expected espresso on Saturday at 9:00, got %s at %s", brew, triggerTime)
+	}
+
+	// Saturday after the trigger - Sunday is off, so next is Monday
+	triggerTime, brew, ok = ct.next(triggerTime)
+	if !ok || brew != BrewLungo || !triggerTime.Equal(time.Date(2023, 1, 9, 6, 45, 0, 0, time.Local)) {
+		t.Fatalf("expected lungo on Monday at 6:45, got %s at %s", brew, triggerTime)
+	}
+
+}
+
+func TestPostponeShiftsOnlyPendingCoffee(t *testing.T) {
+
+	clock := newFakeClock(testStart)
+	ct := NewCoffeeTimer(CoffeeTimerConfig{TriggerTime: "6:45", Brew: BrewLungo}, newTestRaspi(), clock)
+
+	var brewedAt []time.Time
+	ct.SetBrewFunc(func(brew string) { brewedAt = append(brewedAt, clock.Now()) })
+	ct.Arm()
+
+	ct.Postpone(10 * time.Minute)
+	ct.Postpone(5 * time.Minute)
+
+	triggerTime, _, _ := ct.NextTrigger()
+	if want := time.Date(2023, time.January, 2, 7, 0, 0, 0, time.Local); !triggerTime.Equal(want) {
+		t.Fatalf("expected postponed coffee at %s, got %s", want, triggerTime)
+	}
+	if scheduled, ok := ct.SnoozedFrom(); !ok || scheduled.Format("15:04") != "06:45" {
+		t.Fatalf("expected coffee snoozed from 06:45, got %s", scheduled)
+	}
+	if dayTime, _ := ct.DaySchedule(time.Monday); dayTime != "06:45" {
+		t.Fatalf("postponing must not change the schedule, got %s", dayTime)
+	}
+
+	clock.Advance(50 * time.Minute)
+	if len(brewedAt) != 0 {
+		t.Fatalf("coffee made before the postponed time at %s", brewedAt[0])
+	}
+
+	clock.Advance(24 * time.Hour)
+	if len(brewedAt) != 2 || brewedAt[0].Format("15:04") != "07:00" || brewedAt[1].Format("15:04") != "06:45" {
+		t.Fatalf("expected coffee at 07:00 and at 06:45 the next day, got %v", brewedAt)
+	}
+	if _, ok := ct.SnoozedFrom(); ok {
+		t.Fatal("the next day's coffee should not be snoozed")
+	}
+
+	ct.Disarm()
+	if _, ok := ct.Postpone(10 * time.Minute); ok {
+		t.Fatal("a disarmed timer has nothing to postpone")
+	}
+
+}
+
+func TestPowerOnAheadOfTrigger(t *testing.T) {
+
+	clock := newFakeClock(testStart)
+	ct := NewCoffeeTimer(CoffeeTimerConfig{TriggerTime: "6:45", Brew: BrewLungo}, newTestRaspi(), clock)
+
+	var events []string
+	ct.SetPowerOnFunc(func(brew string) { events = append(events, "on "+clock.Now().Format("15:04:05")) }, 30*time.Second)
+	ct.SetBrewFunc(func(brew string) { events = append(events, "brew "+clock.Now().Format("15:04:05")) })
+	ct.Arm()
+	defer ct.Disarm()
+
+	clock.Advance(time.Hour)
+	if len(events) != 2 || events[0] != "on 06:44:30" || events[1] != "brew 06:45:00" {
+		t.Fatalf("expected switching on 30s ahead of the coffee, got %v", events)
+	}
+
+	// postponing the coffee moves the warm-up phase along
+	events = nil
+	ct.Postpone(10 * time.Minute)
+	clock.Advance(24 * time.Hour)
+	if len(events) != 2 || events[0] != "on 06:54:30" || events[1] != "brew 06:55:00" {
+		t.Fatalf("expected warm-up phase to move with the postponed coffee, got %v", events)
+	}
+
+}
+
+func TestValidateClockTime(t *testing.T) {
+
+	for _, valid := range []string{"6:45", "06:45", "23:59:30"} {
+		if err := ValidateClockTime(valid); err != nil {
+			t.Errorf("expected '%s' to be valid, got %v", valid, err)
+		}
+	}
+	// an empty time from the web form must not become midnight
+	for _, invalid := range []string{"", "7", "25:00", "six"} {
+		if err := ValidateClockTime(invalid); err == nil {
+			t.Errorf("expected '%s' to be refused", invalid)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package coffee
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSearchYears limits how far ahead Next looks for a match, e.g. for "0 0 30 2 *" which never matches
+const cronSearchYears = 5
+
+// CronSchedule is a parsed cron expression, either with the standard 5 fields
+// "minute hour day-of-month month day-of-week" or with 6 fields, the first one being the second.
+type CronSchedule struct {
+	expr                                  string
+	second, minute, hour, dom, month, dow uint64 // bit n is set if value n matches
+	domStar, dowStar                      bool
+}
+
+type cronField struct {
+	name     string
+	min, max int
+	names    []string // names for the values starting at min, e.g. JAN for 1
+	maxName  string   // a name that stands for max when it ends a range, e.g. SUN for 7 in MON-SUN
+}
+
+var (
+	cronSecond = cronField{name: "second", min: 0, max: 59}
+	cronMinute = cronField{name: "minute", min: 0, max: 59}
+	cronHour   = cronField{name: "hour", min: 0, max: 23}
+	cronDom    = cronField{name: "day of month", min: 1, max: 31}
+	cronMonth  = cronField{name: "month", min: 1, max: 12, names: []string{"JAN", "FEB", "MAR", "APR", "MAY", "JUN", "JUL", "AUG", "SEP", "OCT", "NOV", "DEC"}}
+	// day of week allows 7 as well as 0 for Sunday
+	cronDow = cronField{name: "day of week", min: 0, max: 7, names: []string{"SUN", "MON", "TUE", "WED", "THU", "FRI", "SAT"}, maxName: "SUN"}
+)
+
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// ParseCron parses a 5 or 6 field cron expression like "30 6 * * 1-5".
+// Fields support *, lists, ranges and steps, months and days of the week also by their English three-letter names,
+// and the descriptors @yearly, @monthly, @weekly, @daily and @hourly.
+func ParseCron(expr string) (*CronSchedule, error) {
+
+	fields := strings.Fields(expr)
+	if len(fields) == 1 {
+		if descriptor, ok := cronDescriptors[strings.ToLower(fields[0])]; ok {
+			fields = strings.Fields(descriptor)
+		}
+	}
+
+	switch len(fields) {
+	case 5:
+		fields = append([]string{"0"}, fields...)
+	case 6:
+	default:
+		return nil, fmt.Errorf("cron expression '%s' has %d fields, expected 5 or 6", expr, len(fields))
+	}
+
+	cs := CronSchedule{expr: strings.Join(strings.Fields(expr), " ")}
+	var err error
+	for i, f := range []struct {
+		field cronField
+		bits  *uint64
+	}{{cronSecond, &cs.second}, {cronMinute, &cs.minute}, {cronHour, &cs.hour}, {cronDom, &cs.dom}, {cronMonth, &cs.month}, {cronDow, &cs.dow}} {
+		*f.bits, err = f.field.parse(fields[i])
+		if err != nil {
+			return nil, fmt.Errorf("cron expression '%s': %w", expr, err)
+		}
+	}
+
+	if cs.dow&(1<<7) != 0 {
+		cs.dow |= 1 << 0
+	}
+	cs.domStar = strings.HasPrefix(fields[3], "*") || fields[3] == "?"
+	cs.dowStar = strings.HasPrefix(fields[5], "*") || fields[5] == "?"
+
+	return &cs, nil
+}
+
+func (cs *CronSchedule) String() string {
+	return cs.expr
+}
+
+// Next returns the first time strictly after t that matches the schedule, in t's location.
+// Times are matched on the wall clock: a time skipped when the clocks go forward fires at the switch-over,
+// and a time repeated when the clocks go back fires only once. The zero time is returned if nothing matches.
+func (cs *CronSchedule) Next(t time.Time) time.Time {
+
+	loc := t.Location()
+	year, month, day := t.Date()
+
+	for i := 0; i <= cronSearchYears*366; i++ {
+		date := time.Date(year, month, day+i, 12, 0, 0, 0, loc)
+		if !cs.matchesDate(date) {
+			continue
+		}
+
+		y, m, d := date.Date()
+		for h := 0; h < 24; h++ {
+			// hours well before t on t's own day cannot match, a daylight saving shift is at most two hours
+			if cs.hour&(1<<h) == 0 || (i == 0 && h < t.Hour()-2) {
+				continue
+			}
+			for min := 0; min < 60; min++ {
+				if cs.minute&(1<<min) == 0 {
+					continue
+				}
+				for sec := 0; sec < 60; sec++ {
+					if cs.second&(1<<sec) == 0 {
+						continue
+					}
+					if next := wallClockTime(y, m, d, h, min, sec, loc); next.After(t) {
+						return next
+					}
+				}
+			}
+		}
+	}
+
+	return time.Time{}
+}
+
+// matchesDate applies the usual cron rule: if both day of month and day of week are restricted, either may match
+func (cs *CronSchedule) matchesDate(date time.Time) bool {
+
+	if cs.month&(1<<int(date.Month())) == 0 {
+		return false
+	}
+
+	domMatch := cs.dom&(1<<date.Day()) != 0
+	dowMatch := cs.dow&(1<<int(date.Weekday())) != 0
+
+	switch {
+	case cs.domStar && cs.dowStar:
+		return true
+	case cs.domStar:
+		return dowMatch
+	case cs.dowStar:
+		return domMatch
+	default:
+		return domMatch || dowMatch
+	}
+}
+
+// parse turns one field of a cron expression, like "1-5", "*/15" or "MON,WED,FRI", into a bit set
+func (f cronField) parse(s string) (uint64, error) {
+
+	var bits uint64
+	for _, part := range strings.Split(s, ",") {
+
+		rangePart, step := part, 1
+		if slash := strings.Index(part, "/"); slash >= 0 {
+			var err error
+			rangePart = part[:slash]
+			step, err = strconv.Atoi(part[slash+1:])
+			if err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step in %s field '%s'", f.name, part)
+			}
+		}
+
+		var lo, hi int
+		switch {
+		case rangePart == "*" || rangePart == "?":
+			lo, hi = f.min, f.max
+		case strings.Contains(rangePart, "-"):
+			bounds := strings.SplitN(rangePart, "-", 2)
+			var err error
+			if lo, err = f.value(bounds[0]); err != nil {
+				return 0, err
+			}
+			if hi, err = f.value(bounds[1]); err != nil {
+				return 0, err
+			}
+			if f.maxName != "" && strings.EqualFold(bounds[1], f.maxName) {
+				hi = f.max
+			}
+			if lo > hi {
+				return 0, fmt.Errorf("range '%s' in %s field runs backwards", rangePart, f.name)
+			}
+		default:
+			var err error
+			if lo, err = f.value(rangePart); err != nil {
+				return 0, err
+			}
+			hi = lo
+			if step > 1 {
+				// "5/15" means every 15 from 5 onwards
+				hi = f.max
+			}
+		}
+
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << v
+		}
+	}
+
+	return bits, nil
+}
+
+func (f cronField) value(s string) (int, error) {
+
+	for i, name := range f.names {
+		if strings.EqualFold(s, name) {
+			return f.min + i, nil
+		}
+	}
+
+	v, err := strconv.Atoi(s)
+	if err != nil || v < f.min || v > f.max {
+		return 0, fmt.Errorf("invalid %s '%s', expected %d-%d", f.name, s, f.min, f.max)
+	}
+	return v, nil
+}
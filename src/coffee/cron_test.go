@@ -0,0 +1,145 @@
+package coffee
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCronRejectsInvalidExpressions(t *testing.T) {
+
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"a * * * *",
+		"* * * FOO *",
+		"@fortnightly",
+	} {
+		if _, err := ParseCron(expr); err == nil {
+			t.Errorf("expected '%s' to be rejected", expr)
+		}
+	}
+
+}
+
+func TestCronNext(t *testing.T) {
+
+	// Friday 2023-01-06 07:00
+	friday := time.Date(2023, 1, 6, 7, 0, 0, 0, time.UTC)
+
+	for _, tc := range []struct {
+		expr  string
+		after time.Time
+		want  time.Time
+	}{
+		{"30 6 * * 1-5", friday, time.Date(2023, 1, 9, 6, 30, 0, 0, time.UTC)},
+		{"30 6 * * MON-FRI", friday, time.Date(2023, 1, 9, 6, 30, 0, 0, time.UTC)},
+		{"0 9 * * 6,7", friday, time.Date(2023, 1, 7, 9, 0, 0, 0, time.UTC)},
+		{"0 9 * * SUN", friday, time.Date(2023, 1, 8, 9, 0, 0, 0, time.UTC)},
+		// SUN ends a range as 7, like 1-7
+		{"0 9 * * MON-SUN", friday, time.Date(2023, 1, 6, 9, 0, 0, 0, time.UTC)},
+		{"0 9 * * FRI-SUN", friday.AddDate(0, 0, 1).Add(3 * time.Hour), time.Date(2023, 1, 8, 9, 0, 0, 0, time.UTC)},
+		{"*/15 * * * *", friday.Add(7 * time.Minute), time.Date(2023, 1, 6, 7, 15, 0, 0, time.UTC)},
+		{"5/20 * * * *", friday.Add(30 * time.Minute), time.Date(2023, 1, 6, 7, 45, 0, 0, time.UTC)},
+		{"0 10-14/2 * * *", friday, time.Date(2023, 1, 6, 10, 0, 0, 0, time.UTC)},
+		{"15 30 6 * * *", time.Date(2023, 1, 6, 6, 30, 14, 0, time.UTC), time.Date(2023, 1, 6, 6, 30, 15, 0, time.UTC)},
+		{"0 7 * * *", friday, time.Date(2023, 1, 7, 7, 0, 0, 0, time.UTC)},
+		{"0 0 1 JAN,MAR *", friday, time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC)},
+		// day of month and day of week both restricted: either matches
+		{"0 8 13 * FRI", time.Date(2023, 1, 7, 0, 0, 0, 0, time.UTC), time.Date(2023, 1, 13, 8, 0, 0, 0, time.UTC)},
+		{"0 8 10 * FRI", time.Date(2023, 1, 7, 0, 0, 0, 0, time.UTC), time.Date(2023, 1, 10, 8, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", friday, time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"@daily", friday, time.Date(2023, 1, 7, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", friday, time.Date(2023, 1, 6, 8, 0, 0, 0, time.UTC)},
+	} {
+		cs, err := ParseCron(tc.expr)
+		if err != nil {
+			t.Errorf("'%s': %v", tc.expr, err)
+			continue
+		}
+		if got := cs.Next(tc.after); !got.Equal(tc.want) {
+			t.Errorf("'%s' after %s: expected %s, got %s", tc.expr, tc.after, tc.want, got)
+		}
+	}
+
+	monSun, err := ParseCron("0 9 * * MON-SUN")
+	if err != nil {
+		t.Fatal(err)
+	}
+	oneSeven, _ := ParseCron("0 9 * * 1-7")
+	if monSun.dow != oneSeven.dow {
+		t.Errorf("expected MON-SUN to match the same days as 1-7, got %b and %b", monSun.dow, oneSeven.dow)
+	}
+
+}
+
+func TestCronNextNeverMatching(t *testing.T) {
+
+	cs, err := ParseCron("0 0 30 2 *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next := cs.Next(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)); !next.IsZero() {
+		t.Fatal("30 February should never match, got", next)
+	}
+
+}
+
+func TestCronNextAcrossDaylightSavingChanges(t *testing.T) {
+
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 2:30 does not exist on the night the clocks go forward, so it fires at the switch-over to 3:00 CEST
+	cs, _ := ParseCron("30 2 * * *")
+	got := cs.Next(time.Date(2023, 3, 25, 12, 0, 0, 0, berlin))
+	if want := time.Date(2023, 3, 26, 1, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+
+	// 2:00 happens twice on the night the clocks go back, an hourly schedule only fires on the first one
+	cs, _ = ParseCron("0 * * * *")
+	var fired []time.Time
+	for next := time.Date(2023, 10, 29, 1, 30, 0, 0, berlin); len(fired) < 3; {
+		next = cs.Next(next)
+		fired = append(fired, next)
+	}
+	want := []time.Time{
+		time.Date(2023, 10, 29, 0, 0, 0, 0, time.UTC), // 2:00 CEST
+		time.Date(2023, 10, 29, 2, 0, 0, 0, time.UTC), // 3:00 CET
+		time.Date(2023, 10, 29, 3, 0, 0, 0, time.UTC), // 4:00 CET
+	}
+	for i := range want {
+		if !fired[i].Equal(want[i]) {
+			t.Errorf("expected hourly trigger %d at %s, got %s", i, want[i], fired[i])
+		}
+	}
+
+}
+
+func TestTimerAcceptsCronTriggerTime(t *testing.T) {
+
+	clock := newFakeClock(time.Date(2023, 1, 6, 7, 0, 0, 0, time.Local))
+	ct := NewCoffeeTimer(CoffeeTimerConfig{TriggerTime: "30 6 * * 1-5", Brew: BrewLungo}, newTestRaspi(), clock)
+
+	upcoming := ct.Upcoming(2)
+	if len(upcoming) != 2 || !upcoming[0].Time.Equal(time.Date(2023, 1, 9, 6, 30, 0, 0, time.Local)) || upcoming[0].Brew != BrewLungo {
+		t.Fatal("cron trigger time not applied, upcoming:", upcoming)
+	}
+
+	// a time of day switches back to the weekly schedule
+	ct.SetTriggerTime("8:00")
+	if expr, _ := ct.TriggerRule(); expr != "" {
+		t.Fatal("cron expression still set after setting a time of day:", expr)
+	}
+
+}
@@ -0,0 +1,251 @@
+package coffee
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"periph.io/x/conn/v3/i2c"
+)
+
+const (
+	CurrentSensorADS1115 = "ads1115" // an ACS712 hall effect current sensor read through an ADS1115 ADC on the I2C bus
+	CurrentSensorFake    = "fake"    // simulates the current drawn from the machine state, to try brew confirmation without the hardware
+)
+
+// CurrentSensorConfig sets up the sensor on the machine's power line, which tells whether a brew has started and finished
+type CurrentSensorConfig struct {
+	// Driver is one of CurrentSensorADS1115 or CurrentSensorFake, brews are not confirmed if it is empty
+	Driver string `yaml:"driver"`
+	// I2CBus is the name of the bus the ADC is on, "" for the first one found
+	I2CBus  string `yaml:"i2c_bus"`
+	Address int    `yaml:"address"`
+	// Channel is the ADC input the sensor's output is wired to, 0-3
+	Channel int `yaml:"channel"`
+	// MvPerAmp is the sensitivity of the sensor, 185 for the 5A ACS712, 100 for the 20A and 66 for the 30A version
+	MvPerAmp float64 `yaml:"mv_per_amp"`
+	// ZeroMv is the sensor's output while no current flows, half its supply voltage
+	ZeroMv float64 `yaml:"zero_mv"`
+	// SampleMs is how long each reading samples the alternating current for its RMS value, a few mains cycles
+	SampleMs int `yaml:"sample_ms"`
+	// BrewingAmps is the current above which the heater and pump are running
+	BrewingAmps float64 `yaml:"brewing_amps"`
+	// StartRiseAmps is how far the current has to rise above the reading before the brew press, at least to BrewingAmps,
+	// so a machine already heating at the press doesn't count as brewing. The pump draws a few tenths of an amp on top of the heater.
+	StartRiseAmps float64 `yaml:"start_rise_amps"`
+	// StartTimeoutMs is how long after the brew press the current has to rise, before the press is retried once
+	StartTimeoutMs int `yaml:"start_timeout_ms"`
+	// FinishQuietMs is how long the current has to stay low for the brew to count as finished
+	FinishQuietMs int `yaml:"finish_quiet_ms"`
+	// FinishTimeoutMs limits how long a brew may draw current
+	FinishTimeoutMs int `yaml:"finish_timeout_ms"`
+}
+
+var CurrentSensorConfigDefaults = CurrentSensorConfig{
+	Driver:          "",
+	I2CBus:          "",
+	Address:         0x48,
+	Channel:         0,
+	MvPerAmp:        185,
+	ZeroMv:          2500,
+	SampleMs:        100,
+	BrewingAmps:     1,
+	StartRiseAmps:   0.2,
+	StartTimeoutMs:  5000,
+	FinishQuietMs:   3000,
+	FinishTimeoutMs: 120000,
+}
+
+// CurrentSensor reads the current the machine draws
+type CurrentSensor interface {
+	Amps() (float64, error)
+}
+
+// NewCurrentSensor opens the configured sensor, on the I2C bus of the given hardware backend.
+// The fake one follows the machine state, which it reads through state.
+func NewCurrentSensor(cfg CurrentSensorConfig, hw Hardware, state func() (MachineState, time.Time)) (CurrentSensor, error) {
+	switch cfg.Driver {
+	case CurrentSensorADS1115:
+		bus, err := hw.I2C(cfg.I2CBus)
+		if err != nil {
+			return nil, err
+		}
+		return newADS1115(cfg, bus)
+	case CurrentSensorFake:
+		log.Println("Simulating the current sensor")
+		return NewFakeCurrentSensor(state, cfg.BrewingAmps*5, 25*time.Second), nil
+	default:
+		return nil, fmt.Errorf("unknown current sensor driver '%s', expected %s or %s", cfg.Driver, CurrentSensorADS1115, CurrentSensorFake)
+	}
+}
+
+// ADS1115 registers and config bits, see the data sheet
+const (
+	ads1115RegConversion = 0x00
+	ads1115RegConfig     = 0x01
+
+	ads1115StartSingle   = 0x8000 // OS: start a single conversion
+	ads1115MuxSingleEnd  = 0x4000 // MUX: AINx against GND, the channel goes in bits 12-13
+	ads1115Gain6144      = 0x0000 // PGA: +/-6.144V, as the ACS712 output swings around 2.5V
+	ads1115ModeSingle    = 0x0100 // MODE: power down after each conversion
+	ads1115Rate860       = 0x00e0 // DR: 860 samples per second
+	ads1115CompDisable   = 0x0003 // COMP_QUE: comparator off
+	ads1115MvPerBit      = 6144.0 / 32768
+	ads1115ConversionDur = 1200 * time.Microsecond // a little more than 1/860s
+)
+
+// ads1115 reads an ACS712 current sensor through an ADS1115 ADC
+type ads1115 struct {
+	mu  sync.Mutex
+	cfg CurrentSensorConfig
+	dev *i2c.Dev
+}
+
+func newADS1115(cfg CurrentSensorConfig, bus i2c.Bus) (*ads1115, error) {
+
+	if cfg.Channel < 0 || cfg.Channel > 3 {
+		return nil, fmt.Errorf("invalid ADS1115 channel %d", cfg.Channel)
+	}
+	if cfg.MvPerAmp <= 0 {
+		return nil, fmt.Errorf("invalid current sensor sensitivity %gmV/A", cfg.MvPerAmp)
+	}
+
+	log.Printf("Reading the current sensor through an ADS1115 at 0x%02x on %s, channel %d\n", cfg.Address, bus, cfg.Channel)
+
+	return &ads1115{cfg: cfg, dev: &i2c.Dev{Bus: bus, Addr: uint16(cfg.Address)}}, nil
+}
+
+// Amps samples the sensor for the configured time and returns the RMS current
+func (a *ads1115) Amps() (float64, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var sum float64
+	n := 0
+	for start := time.Now(); n == 0 || time.Since(start) < time.Duration(a.cfg.SampleMs)*time.Millisecond; n++ {
+		mv, err := a.readMv()
+		if err != nil {
+			return 0, err
+		}
+		amps := (mv - a.cfg.ZeroMv) / a.cfg.MvPerAmp
+		sum += amps * amps
+	}
+	return math.Sqrt(sum / float64(n)), nil
+}
+
+// readMv runs a single conversion and returns the voltage read
+func (a *ads1115) readMv() (float64, error) {
+
+	config := uint16(ads1115StartSingle | ads1115MuxSingleEnd | a.cfg.Channel<<12 | ads1115Gain6144 | ads1115ModeSingle | ads1115Rate860 | ads1115CompDisable)
+	if err := a.dev.Tx([]byte{ads1115RegConfig, byte(config >> 8), byte(config)}, nil); err != nil {
+		return 0, fmt.Errorf("starting ADS1115 conversion: %w", err)
+	}
+	time.Sleep(ads1115ConversionDur)
+
+	data := make([]byte, 2)
+	if err := a.dev.Tx([]byte{ads1115RegConversion}, data); err != nil {
+		return 0, fmt.Errorf("reading ADS1115 conversion: %w", err)
+	}
+	return float64(int16(binary.BigEndian.Uint16(data))) * ads1115MvPerBit, nil
+}
+
+// FakeCurrentSensor simulates the current a machine draws: the heater runs while it is heating,
+// and the heater and pump run for the first part of a brew, the pump drawing fakePumpAmps
+type FakeCurrentSensor struct {
+	mu           sync.Mutex
+	state        func() (MachineState, time.Time)
+	drawAmps     float64
+	brewDuration time.Duration
+	readings     []float64 // scripted readings, used up before simulating from the state
+}
+
+// fakePumpAmps is what the FakeCurrentSensor's pump draws on top of the heater
+const fakePumpAmps = 0.3
+
+func NewFakeCurrentSensor(state func() (MachineState, time.Time), drawAmps float64, brewDuration time.Duration) *FakeCurrentSensor {
+	return &FakeCurrentSensor{state: state, drawAmps: drawAmps, brewDuration: brewDuration}
+}
+
+// Script makes the next readings return the given currents, e.g. to simulate a press the machine has missed
+func (f *FakeCurrentSensor) Script(amps ...float64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.readings = append(f.readings, amps...)
+}
+
+func (f *FakeCurrentSensor) Amps() (float64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(f.readings) > 0 {
+		amps := f.readings[0]
+		f.readings = f.readings[1:]
+		return amps, nil
+	}
+
+	switch state, since := f.state(); {
+	case state == MachineHeating:
+		return f.drawAmps, nil
+	case state == MachineBrewing && time.Since(since) < f.brewDuration:
+		return f.drawAmps + fakePumpAmps, nil
+	default:
+		return 0.05, nil
+	}
+}
+
+// drawsCurrent polls the sensor until it reads at least amps, for at most timeout
+func drawsCurrent(ctx context.Context, sensor CurrentSensor, amps float64, timeout time.Duration) (bool, error) {
+
+	deadline := time.Now().Add(timeout)
+	for {
+		a, err := sensor.Amps()
+		if err != nil {
+			return false, err
+		}
+		if a >= amps {
+			return true, nil
+		}
+		if time.Now().After(deadline) {
+			return false, nil
+		}
+		if err := sleep(ctx, sensorPollInterval); err != nil {
+			return false, err
+		}
+	}
+}
+
+// finished polls the sensor until the current has stayed below the brewing current for the configured quiet time
+func finished(ctx context.Context, sensor CurrentSensor, cfg CurrentSensorConfig) error {
+
+	started := time.Now()
+	timeout := time.Duration(cfg.FinishTimeoutMs) * time.Millisecond
+	quiet := time.Duration(cfg.FinishQuietMs) * time.Millisecond
+
+	var quietSince time.Time
+	for {
+		a, err := sensor.Amps()
+		if err != nil {
+			log.Println("Could not read current sensor, not confirming the brew has finished:", err)
+			return nil
+		}
+		switch {
+		case a >= cfg.BrewingAmps:
+			quietSince = time.Time{}
+		case quietSince.IsZero():
+			quietSince = time.Now()
+		case time.Since(quietSince) >= quiet:
+			log.Println("Brew has finished after", quietSince.Sub(started).Truncate(time.Second))
+			return nil
+		}
+		if time.Since(started) > timeout {
+			return fmt.Errorf("machine still drawing %.1fA after %s", a, timeout)
+		}
+		if err := sleep(ctx, sensorPollInterval); err != nil {
+			return err
+		}
+	}
+}
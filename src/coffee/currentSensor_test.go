@@ -0,0 +1,132 @@
+package coffee
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"periph.io/x/conn/v3/i2c"
+	"periph.io/x/conn/v3/i2c/i2ctest"
+)
+
+func TestADS1115Amps(t *testing.T) {
+
+	bus := &i2ctest.Playback{Ops: []i2ctest.IO{
+		{Addr: 0x48, W: []byte{ads1115RegConfig, 0xd1, 0xe3}},
+		{Addr: 0x48, W: []byte{ads1115RegConversion}, R: []byte{0x37, 0xf0}}, // 14320 * 0.1875mV = 2685mV
+	}}
+	cfg := CurrentSensorConfigDefaults
+	cfg.Channel, cfg.SampleMs = 1, 0
+	a := &ads1115{cfg: cfg, dev: &i2c.Dev{Bus: bus, Addr: 0x48}}
+
+	amps, err := a.Amps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if amps < 0.999 || amps > 1.001 {
+		t.Errorf("expected 185mV above zero to read 1A, got %gA", amps)
+	}
+	if err := bus.Close(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestADS1115OnSimulatedBus(t *testing.T) {
+
+	hw := NewSimHardware()
+	cfg := CurrentSensorConfigDefaults
+	cfg.Driver, cfg.SampleMs = CurrentSensorADS1115, 0
+	hw.SetI2CDevice(uint16(cfg.Address), func(w, r []byte) error {
+		if len(w) == 1 && w[0] == ads1115RegConversion && len(r) == 2 {
+			r[0], r[1] = 0x37, 0xf0
+		}
+		return nil
+	})
+
+	sensor, err := NewCurrentSensor(cfg, hw, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if amps, err := sensor.Amps(); err != nil || amps < 0.999 || amps > 1.001 {
+		t.Errorf("expected 1A read through the simulated bus, got %gA, %v", amps, err)
+	}
+}
+
+// newCurrentTestMachine returns a test machine whose brews are confirmed by a fake current sensor
+func newCurrentTestMachine(drawAmps float64) (*buttonMachine, *FakeCurrentSensor, func() []string) {
+
+	n, events := newTestMachine(nil)
+	sensor := NewFakeCurrentSensor(n.State, drawAmps, 300*time.Millisecond)
+	cfg := CurrentSensorConfigDefaults
+	cfg.StartTimeoutMs, cfg.FinishQuietMs, cfg.FinishTimeoutMs = 100, 20, 2000
+	n.SetCurrentSensor(sensor, cfg)
+	return n, sensor, events
+}
+
+func TestBrewConfirmedByCurrent(t *testing.T) {
+
+	n, _, events := newCurrentTestMachine(5)
+	if err := n.RunRecipe(context.Background(), "espresso"); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(events(), ","); got != "press espresso,release espresso,press espresso,release espresso" {
+		t.Errorf("expected a warm-up and a brew press, got %s", got)
+	}
+}
+
+func TestBrewPressRetriedWithoutCurrent(t *testing.T) {
+
+	n, sensor, events := newCurrentTestMachine(5)
+	sensor.Script(0, 0, 0, 0, 0)
+	if err := n.RunRecipe(context.Background(), "espresso"); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Count(strings.Join(events(), ","), "press espresso,release espresso"); got != 3 {
+		t.Errorf("expected the brew press to be retried once, got %v", events())
+	}
+}
+
+func TestBrewNotStarted(t *testing.T) {
+
+	n, _, events := newCurrentTestMachine(0)
+	err := n.RunRecipe(context.Background(), "espresso")
+	if err == nil || !strings.Contains(err.Error(), "has not started") {
+		t.Fatalf("expected the brew not to start, got %v", err)
+	}
+	if got := strings.Count(strings.Join(events(), ","), "press espresso,release espresso"); got != 3 {
+		t.Errorf("expected a warm-up and two brew presses, got %v", events())
+	}
+}
+
+// steadyCurrent is a machine drawing the same current whatever is pressed, like one reheating its thermoblock
+type steadyCurrent float64
+
+func (c steadyCurrent) Amps() (float64, error) {
+	return float64(c), nil
+}
+
+func TestBrewNotConfirmedByCurrentAtPress(t *testing.T) {
+
+	// the heater is running at the press, but the brew never starts
+	n, _, events := newCurrentTestMachine(5)
+	cfg := n.currentCfg
+	n.SetCurrentSensor(steadyCurrent(5), cfg)
+	err := n.RunRecipe(context.Background(), "espresso")
+	if err == nil || !strings.Contains(err.Error(), "has not started") {
+		t.Fatalf("expected the brew not to be confirmed by the heater, got %v", err)
+	}
+	if got := strings.Count(strings.Join(events(), ","), "press espresso,release espresso"); got != 3 {
+		t.Errorf("expected a warm-up and two brew presses, got %v", events())
+	}
+
+	// the heater is running at the press, and the pump starts on top of it
+	n, sensor, events := newCurrentTestMachine(5)
+	sensor.Script(5)
+	if err := n.RunRecipe(context.Background(), "espresso"); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Count(strings.Join(events(), ","), "press espresso,release espresso"); got != 2 {
+		t.Errorf("expected a warm-up and a brew press, got %v", events())
+	}
+}
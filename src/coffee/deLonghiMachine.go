@@ -0,0 +1,24 @@
+package coffee
+
+const MachineModelDeLonghi = "delonghi"
+
+// deLonghiRecipes switch the machine on with the power button and press the brew's button once it has heated up
+var deLonghiRecipes = []RecipeConfig{
+	{Name: BrewEspresso, Steps: []RecipeStep{{WarmUp: "power"}, {Press: "espresso"}}, WaterMl: 40},
+	{Name: BrewLungo, Steps: []RecipeStep{{WarmUp: "power"}, {Press: "lungo"}}, WaterMl: 110},
+}
+
+func init() {
+	RegisterMachine(MachineModelDeLonghi, NewDeLonghiMachine)
+}
+
+// NewDeLonghiMachine drives a De'Longhi machine with a separate power button, which switches it on and off.
+// Its espresso and lungo buttons only make coffee while it is on.
+func NewDeLonghiMachine(cfg MachineConfig, raspi *raspberrypi) CoffeeMachine {
+	buttons := map[string]func(press bool){
+		"power":    raspi.ActivatePowerButton,
+		"espresso": raspi.ActivateEspressoButton,
+		"lungo":    raspi.ActivateLungoButton,
+	}
+	return newButtonMachine(cfg, buttons, deLonghiRecipes)
+}
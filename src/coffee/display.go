@@ -0,0 +1,257 @@
+package coffee
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"periph.io/x/conn/v3/i2c"
+)
+
+const (
+	DisplayHD44780 = "hd44780" // a character LCD with a PCF8574 I2C backpack
+	DisplaySSD1306 = "ssd1306" // a 128x64 OLED, showing 21 columns of 8 rows of text
+)
+
+// displayRefreshInterval refreshes the display now and then even if nothing has been reported to change, e.g. once a trigger has passed
+const displayRefreshInterval = 30 * time.Second
+
+// Display shows lines of text
+type Display interface {
+	// Size is how many characters fit on a line, and how many lines fit on the display
+	Size() (cols, rows int)
+	// Show replaces what is shown with the given lines, they have been cut to the display's size
+	Show(lines []string) error
+}
+
+// openDisplay sets up the display configured on the given I2C bus
+func openDisplay(cfg RaspiConfig, bus i2c.Bus) (Display, error) {
+	var d Display
+	var err error
+	switch cfg.DisplayDriver {
+	case DisplayHD44780:
+		d, err = newHD44780(bus, uint16(cfg.DisplayAddress), cfg.DisplayColumns, cfg.DisplayRows)
+	case DisplaySSD1306:
+		d, err = newSSD1306(bus, uint16(cfg.DisplayAddress))
+	default:
+		err = fmt.Errorf("unknown display driver '%s', expected %s or %s", cfg.DisplayDriver, DisplayHD44780, DisplaySSD1306)
+	}
+	if err != nil {
+		// not a nil driver in a non-nil interface
+		return nil, err
+	}
+	return d, nil
+}
+
+// FakeDisplay keeps the lines shown in memory, e.g. to test the layout
+type FakeDisplay struct {
+	mu         sync.Mutex
+	cols, rows int
+	lines      []string
+	shown      int
+}
+
+func NewFakeDisplay(cols, rows int) *FakeDisplay {
+	return &FakeDisplay{cols: cols, rows: rows, lines: make([]string, rows)}
+}
+
+func (f *FakeDisplay) Size() (cols, rows int) {
+	return f.cols, f.rows
+}
+
+func (f *FakeDisplay) Show(lines []string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for row := range f.lines {
+		f.lines[row] = ""
+		if row < len(lines) {
+			f.lines[row] = fitLine(lines[row], f.cols)
+		}
+	}
+	f.shown++
+	return nil
+}
+
+// Lines returns the lines shown, each padded to the width of the display
+func (f *FakeDisplay) Lines() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.lines...)
+}
+
+// Shown counts how often the display has been written
+func (f *FakeDisplay) Shown() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.shown
+}
+
+// DisplayStatus is what the status display shows
+type DisplayStatus struct {
+	Armed     bool
+	Next      time.Time // the next trigger time, zero if there is none
+	NextBrew  string
+	Machine   MachineState
+	LastError string
+}
+
+// StatusDisplay shows the status on a display, and refreshes it in the background whenever it has been told something has changed
+type StatusDisplay struct {
+	display Display
+	status  func() DisplayStatus
+	wake    chan struct{}
+
+	mu        sync.Mutex
+	lastError string
+	shown     []string
+}
+
+// NewStatusDisplay starts showing the status returned by status on the display
+func NewStatusDisplay(display Display, status func() DisplayStatus) *StatusDisplay {
+	s := &StatusDisplay{display: display, status: status, wake: make(chan struct{}, 1)}
+	go s.run()
+	s.Refresh()
+	return s
+}
+
+// Refresh makes the display show the status again. It returns at once, so it may be called while holding locks.
+func (s *StatusDisplay) Refresh() {
+	if s == nil {
+		return
+	}
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// SetLastError shows the given error until the next one
+func (s *StatusDisplay) SetLastError(msg string) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	s.lastError = msg
+	s.mu.Unlock()
+	s.Refresh()
+}
+
+func (s *StatusDisplay) run() {
+	ticker := time.NewTicker(displayRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.wake:
+		case <-ticker.C:
+		}
+		s.show()
+	}
+}
+
+// show writes the status to the display, unless it shows it already
+func (s *StatusDisplay) show() {
+
+	status := s.status()
+	s.mu.Lock()
+	status.LastError = s.lastError
+	s.mu.Unlock()
+
+	cols, rows := s.display.Size()
+	lines := layoutStatus(status, cols, rows)
+	if strings.Join(lines, "\n") == strings.Join(s.shown, "\n") {
+		return
+	}
+	if err := s.display.Show(lines); err != nil {
+		log.Println("Could not show the status on the display:", err)
+		return
+	}
+	s.shown = lines
+
+	trimmed := make([]string, len(lines))
+	for i, line := range lines {
+		trimmed[i] = strings.TrimRight(line, " ")
+	}
+	log.Println("Display:", strings.Join(trimmed, " | "))
+}
+
+// layoutStatus arranges the status on a display of the given size. Two rows show the armed status and time,
+// and the last error or else the machine state. Four rows and more show all of them, the error wrapped across the rows left.
+func layoutStatus(status DisplayStatus, cols, rows int) []string {
+
+	armed := "Disarmed"
+	if status.Armed {
+		armed = "Armed"
+	}
+	if !status.Next.IsZero() {
+		armed += " " + status.Next.Format("Mon 15:04")
+	}
+	brew := "Next: " + status.NextBrew
+	if status.NextBrew == "" || status.Next.IsZero() {
+		brew = "No coffee pending"
+	}
+	machine := "Machine " + status.Machine.String()
+
+	var lines []string
+	switch {
+	case rows < 2:
+		lines = []string{armed}
+	case rows < 4:
+		if status.LastError != "" {
+			lines = []string{armed, "!" + status.LastError}
+		} else {
+			lines = []string{armed, machine}
+		}
+	default:
+		lines = []string{armed, brew, machine}
+		if status.LastError != "" {
+			lines = append(lines, wrapLine("!"+status.LastError, cols, rows-len(lines))...)
+		}
+	}
+
+	for i := range lines {
+		lines[i] = fitLine(lines[i], cols)
+	}
+	return lines
+}
+
+// fitLine cuts or pads the line to the given width, replacing characters a display cannot show
+func fitLine(line string, cols int) string {
+	b := make([]byte, 0, cols)
+	for _, r := range line {
+		if len(b) == cols {
+			break
+		}
+		if r < ' ' || r > '~' {
+			r = '?'
+		}
+		b = append(b, byte(r))
+	}
+	for len(b) < cols {
+		b = append(b, ' ')
+	}
+	return string(b)
+}
+
+// wrapLine splits the line into at most rows lines of at most cols characters, breaking at spaces where possible
+func wrapLine(line string, cols, rows int) []string {
+	var lines []string
+	for len(line) > 0 && len(lines) < rows {
+		if len(line) <= cols {
+			lines = append(lines, line)
+			break
+		}
+		cut := strings.LastIndex(line[:cols+1], " ")
+		if cut <= 0 {
+			cut = cols
+		}
+		lines = append(lines, strings.TrimRight(line[:cut], " "))
+		line = strings.TrimLeft(line[cut:], " ")
+	}
+	return lines
+}
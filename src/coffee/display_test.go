@@ -0,0 +1,186 @@
+package coffee
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"periph.io/x/conn/v3/i2c"
+	"periph.io/x/conn/v3/i2c/i2ctest"
+)
+
+func TestLayoutStatus(t *testing.T) {
+
+	next := testStart.Add(30 * time.Minute)
+	tests := []struct {
+		name       string
+		status     DisplayStatus
+		cols, rows int
+		expected   []string
+	}{
+		{"armed", DisplayStatus{Armed: true, Next: next, NextBrew: "espresso", Machine: MachineOff}, 16, 2,
+			[]string{"Armed Mon 06:30", "Machine off"}},
+		{"disarmed", DisplayStatus{Machine: MachineHeating}, 16, 2,
+			[]string{"Disarmed", "Machine heating"}},
+		{"error", DisplayStatus{Armed: true, Next: next, NextBrew: "espresso", LastError: "no cup in place, and more"}, 16, 2,
+			[]string{"Armed Mon 06:30", "!no cup in place"}},
+		{"four rows", DisplayStatus{Armed: true, Next: next, NextBrew: "lungo", Machine: MachineBrewing}, 20, 4,
+			[]string{"Armed Mon 06:30", "Next: lungo", "Machine brewing"}},
+		{"error wrapped", DisplayStatus{Armed: true, Next: next, NextBrew: "espresso", Machine: MachineReady, LastError: "the machine has not started brewing"}, 21, 8,
+			[]string{"Armed Mon 06:30", "Next: espresso", "Machine ready", "!the machine has not", "started brewing"}},
+		{"error cut", DisplayStatus{LastError: "the machine has not started brewing"}, 20, 4,
+			[]string{"Disarmed", "No coffee pending", "Machine off", "!the machine has not"}},
+	}
+
+	for _, test := range tests {
+		lines := layoutStatus(test.status, test.cols, test.rows)
+		if len(lines) != len(test.expected) {
+			t.Errorf("%s: expected %d lines, got %q", test.name, len(test.expected), lines)
+			continue
+		}
+		for i, line := range lines {
+			if expected := fitLine(test.expected[i], test.cols); line != expected {
+				t.Errorf("%s: expected line %d to be %q, got %q", test.name, i, expected, line)
+			}
+		}
+	}
+}
+
+func TestFitLine(t *testing.T) {
+
+	if got := fitLine("Café", 6); got != "Caf?  " {
+		t.Errorf("expected characters the display can't show to be replaced, got %q", got)
+	}
+	if got := fitLine("espresso", 4); got != "espr" {
+		t.Errorf("expected the line to be cut, got %q", got)
+	}
+}
+
+// waitShown waits until the display has been written n times
+func waitShown(t *testing.T, d *FakeDisplay, n int) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for d.Shown() < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the display to be written %d times, got %d", n, d.Shown())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestStatusDisplayRefresh(t *testing.T) {
+
+	var mu sync.Mutex
+	status := DisplayStatus{Armed: true, Next: testStart, NextBrew: "espresso"}
+	d := NewFakeDisplay(16, 2)
+	s := NewStatusDisplay(d, func() DisplayStatus {
+		mu.Lock()
+		defer mu.Unlock()
+		return status
+	})
+
+	waitShown(t, d, 1)
+	if got := strings.TrimSpace(d.Lines()[0]); got != "Armed Mon 06:00" {
+		t.Errorf("expected the armed status, got %q", got)
+	}
+
+	// nothing has changed, so the display isn't written again
+	s.Refresh()
+	time.Sleep(20 * time.Millisecond)
+	if d.Shown() != 1 {
+		t.Errorf("expected the display not to be written again, got %d writes", d.Shown())
+	}
+
+	mu.Lock()
+	status.Machine = MachineBrewing
+	mu.Unlock()
+	s.Refresh()
+	waitShown(t, d, 2)
+	if got := strings.TrimSpace(d.Lines()[1]); got != "Machine brewing" {
+		t.Errorf("expected the machine state, got %q", got)
+	}
+
+	s.SetLastError("no cup")
+	waitShown(t, d, 3)
+	if got := strings.TrimSpace(d.Lines()[1]); got != "!no cup" {
+		t.Errorf("expected the last error, got %q", got)
+	}
+
+	// calling a display that isn't there does nothing
+	var none *StatusDisplay
+	none.Refresh()
+	none.SetLastError("no cup")
+}
+
+func TestRenderText(t *testing.T) {
+
+	if len(font5x7) != '~'-' '+1 {
+		t.Fatalf("expected a glyph for each printable character, got %d", len(font5x7))
+	}
+
+	frame := renderText([]string{" !", "A"}, ssd1306Width, ssd1306Height)
+	if len(frame) != 1024 {
+		t.Fatalf("expected a 1KB frame, got %d bytes", len(frame))
+	}
+	// '!' is the second character of the first page, 'A' the first of the second
+	if got := frame[fontWidth : 2*fontWidth]; fmt.Sprint(got) != fmt.Sprint([]byte{0, 0, 0x5f, 0, 0, 0}) {
+		t.Errorf("expected '!' in the first page, got %v", got)
+	}
+	if got := frame[ssd1306Width : ssd1306Width+fontWidth]; fmt.Sprint(got) != fmt.Sprint([]byte{0x7e, 0x11, 0x11, 0x11, 0x7e, 0}) {
+		t.Errorf("expected 'A' in the second page, got %v", got)
+	}
+}
+
+func TestHD44780Show(t *testing.T) {
+
+	bus := &i2ctest.Record{}
+	d := &hd44780{dev: &i2c.Dev{Bus: bus, Addr: hd44780DefaultAddr}, cols: 2, rows: 1}
+	if err := d.Show([]string{"Hi there"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// each nibble is written with enable high, then low, the backlight on
+	var got []string
+	for _, op := range bus.Ops {
+		got = append(got, fmt.Sprintf("%x", op.W))
+	}
+	expected := []string{
+		"8c88", "0c08", // set the DDRAM address of row 0
+		"4d49", "8d89", // 'H'
+		"6d69", "9d99", // 'i'
+	}
+	if strings.Join(got, " ") != strings.Join(expected, " ") {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestRaspiDisplay(t *testing.T) {
+
+	cfg := NoRaspiInUseConfig
+	cfg.DisplayDriver = DisplaySSD1306
+	r, err := NewRaspi(cfg, NewSimHardware())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Disconnect()
+	if r.Display() == nil {
+		t.Fatal("expected a display")
+	}
+	if cols, rows := r.Display().Size(); cols != 21 || rows != 8 {
+		t.Errorf("expected 21x8 characters, got %dx%d", cols, rows)
+	}
+
+	// a display that can't be set up leaves coffee pixie running without it
+	cfg.DisplayDriver = "vfd"
+	r, err = NewRaspi(cfg, NewSimHardware())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Disconnect()
+	if r.Display() != nil {
+		t.Error("expected no display")
+	}
+}
@@ -0,0 +1,24 @@
+package coffee
+
+const (
+	MachineModelFilter = "filter"
+	BrewFilterCoffee   = "filter coffee"
+)
+
+// filterRecipes keep the relay closed for as long as a full pot takes
+var filterRecipes = []RecipeConfig{
+	{Name: BrewFilterCoffee, Steps: []RecipeStep{{Press: "power", DurationMs: 10 * 60 * 1000}}, WaterMl: 1250},
+}
+
+func init() {
+	RegisterMachine(MachineModelFilter, NewFilterMachine)
+}
+
+// NewFilterMachine drives a plain filter coffee machine, which is left switched on and gets its power through a relay
+// on the power button pin. Closing the relay makes coffee, there is nothing to warm up.
+func NewFilterMachine(cfg MachineConfig, raspi *raspberrypi) CoffeeMachine {
+	buttons := map[string]func(press bool){
+		"power": raspi.ActivatePowerButton,
+	}
+	return newButtonMachine(cfg, buttons, filterRecipes)
+}
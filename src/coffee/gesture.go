@@ -0,0 +1,168 @@
+package coffee
+
+import (
+	"fmt"
+	"time"
+
+	"periph.io/x/conn/v3/gpio"
+)
+
+const (
+	GestureShortPress  = "short press"
+	GestureLongPress   = "long press"
+	GestureDoublePress = "double press"
+)
+
+// actions a button gesture can be mapped to
+const (
+	ActionNone             = "none"
+	ActionShowStatus       = "show_status"
+	ActionToggleArm        = "toggle_arm"
+	ActionBrewNow          = "brew_now"
+	ActionSnooze           = "snooze"
+	ActionResetMaintenance = "reset_maintenance"
+)
+
+var buttonActions = []string{ActionNone, ActionShowStatus, ActionToggleArm, ActionBrewNow, ActionSnooze, ActionResetMaintenance}
+
+// gesturePollInterval is how often the level of a pressed button is read
+const gesturePollInterval = 5 * time.Millisecond
+
+// ButtonGestures maps the gestures of a button to actions, "" or ActionNone to ignore a gesture
+type ButtonGestures struct {
+	ShortPress string `yaml:"short_press"`
+	// LongPress is recognised once the button has been held for the button's long press time
+	LongPress   string `yaml:"long_press"`
+	DoublePress string `yaml:"double_press"`
+}
+
+// action returns the action mapped to the gesture, or ActionNone
+func (g ButtonGestures) action(gesture string) string {
+	var action string
+	switch gesture {
+	case GestureShortPress:
+		action = g.ShortPress
+	case GestureLongPress:
+		action = g.LongPress
+	case GestureDoublePress:
+		action = g.DoublePress
+	}
+	if action == "" {
+		return ActionNone
+	}
+	return action
+}
+
+func (g ButtonGestures) validate() error {
+	for _, action := range []string{g.ShortPress, g.LongPress, g.DoublePress} {
+		if action != "" && !contains(buttonActions, action) {
+			return fmt.Errorf("unknown button action '%s', expected one of %v", action, buttonActions)
+		}
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
+
+// gestureDetector recognises the gestures of a button pulling its pin High while it is pressed.
+// An edge only counts as a press or release once the level has been stable for the debounce time,
+// so contact bounce and interference spikes are ignored.
+type gestureDetector struct {
+	pin         gpio.PinIO
+	debounce    time.Duration
+	longPress   time.Duration // 0 makes any press a short one
+	doublePress time.Duration // how long to wait for a second press, 0 doesn't wait
+}
+
+// run waits for gestures forever, and calls f with each one recognised
+func (d gestureDetector) run(f func(gesture string)) {
+	for {
+		if gesture := d.next(); gesture != "" {
+			f(gesture)
+		}
+	}
+}
+
+// next waits for a press and returns its gesture, or "" if the press turned out to be a spike
+func (d gestureDetector) next() string {
+
+	if !d.pressed(-1) {
+		return ""
+	}
+	if d.held(d.longPress) {
+		d.released()
+		return GestureLongPress
+	}
+	if d.doublePress > 0 && d.pressed(d.doublePress) {
+		d.released()
+		return GestureDoublePress
+	}
+	return GestureShortPress
+}
+
+// pressed waits for at most timeout, -1 for ever, for a rising edge followed by a stable High level
+func (d gestureDetector) pressed(timeout time.Duration) bool {
+
+	// edges seen while the last press was polled are stale, but the button may have been pressed again already
+	for d.pin.WaitForEdge(0) {
+	}
+	if d.pin.Read() == gpio.High && d.stable(gpio.High) {
+		return true
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		wait := time.Until(deadline)
+		if timeout < 0 {
+			wait = -1
+		} else if wait <= 0 {
+			return false
+		}
+		if !d.pin.WaitForEdge(wait) {
+			return false
+		}
+		if d.stable(gpio.High) {
+			return true
+		}
+	}
+}
+
+// held tells whether a pressed button is still held after d, and returns once it has been released otherwise
+func (d gestureDetector) held(dur time.Duration) bool {
+
+	start := time.Now()
+	for dur <= 0 || time.Since(start) < dur {
+		if d.pin.Read() == gpio.Low && d.stable(gpio.Low) {
+			return false
+		}
+		time.Sleep(gesturePollInterval)
+	}
+	return true
+}
+
+// released waits until a pressed button has been released
+func (d gestureDetector) released() {
+	d.held(0)
+}
+
+// stable tells whether the pin stays at the level for the debounce time
+func (d gestureDetector) stable(l gpio.Level) bool {
+
+	start := time.Now()
+	for {
+		if d.pin.Read() != l {
+			return false
+		}
+		if time.Since(start) >= d.debounce {
+			return true
+		}
+		time.Sleep(gesturePollInterval)
+	}
+}
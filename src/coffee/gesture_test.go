@@ -0,0 +1,79 @@
+package coffee
+
+import (
+	"testing"
+	"time"
+
+	"periph.io/x/conn/v3/gpio"
+)
+
+func TestGestures(t *testing.T) {
+
+	sim := NewSimHardware()
+	pin := sim.pin(24)
+	if err := pin.In(gpio.PullDown, gpio.RisingEdge); err != nil {
+		t.Fatal(err)
+	}
+	d := gestureDetector{pin: pin, debounce: 10 * time.Millisecond, longPress: 150 * time.Millisecond, doublePress: 100 * time.Millisecond}
+	gestures := make(chan string, 4)
+	go d.run(func(gesture string) { gestures <- gesture })
+
+	for _, c := range []struct {
+		name     string
+		press    func()
+		expected string
+	}{
+		{"short press", func() { sim.Press(24, 30*time.Millisecond) }, GestureShortPress},
+		{"long press", func() { sim.Press(24, 250*time.Millisecond) }, GestureLongPress},
+		{"double press", func() {
+			sim.Press(24, 30*time.Millisecond)
+			time.Sleep(30 * time.Millisecond)
+			sim.Press(24, 30*time.Millisecond)
+		}, GestureDoublePress},
+		{"bouncing press", func() {
+			for i := 0; i < 3; i++ {
+				sim.Press(24, time.Millisecond)
+				time.Sleep(time.Millisecond)
+			}
+			sim.Press(24, 30*time.Millisecond)
+		}, GestureShortPress},
+	} {
+		c.press()
+		select {
+		case got := <-gestures:
+			if got != c.expected {
+				t.Errorf("expected a %s to be recognised as %s, got %s", c.name, c.expected, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected a %s to be recognised as %s, got nothing", c.name, c.expected)
+		}
+		// let the double press window pass before the next gesture
+		time.Sleep(150 * time.Millisecond)
+	}
+
+	// a spike shorter than the debounce time is no press at all
+	sim.Press(24, time.Millisecond)
+	select {
+	case got := <-gestures:
+		t.Errorf("expected a spike to be ignored, got %s", got)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestButtonGestures(t *testing.T) {
+
+	g := ButtonGestures{ShortPress: ActionToggleArm, LongPress: ActionSnooze}
+	for gesture, expected := range map[string]string{GestureShortPress: ActionToggleArm, GestureLongPress: ActionSnooze, GestureDoublePress: ActionNone} {
+		if got := g.action(gesture); got != expected {
+			t.Errorf("expected %s to %s, got %s", gesture, expected, got)
+		}
+	}
+
+	if err := g.validate(); err != nil {
+		t.Error(err)
+	}
+	g.DoublePress = "espresso"
+	if err := g.validate(); err == nil {
+		t.Error("expected an error about the unknown action")
+	}
+}
@@ -0,0 +1,65 @@
+package coffee
+
+import (
+	"fmt"
+	"log"
+
+	"periph.io/x/conn/v3/gpio"
+	"periph.io/x/conn/v3/gpio/gpioreg"
+	"periph.io/x/conn/v3/i2c"
+	"periph.io/x/conn/v3/i2c/i2creg"
+	"periph.io/x/host/v3"
+)
+
+const (
+	HardwarePeriph    = "periph"    // the Raspberry Pi's GPIOs, driven through periph.io
+	HardwareSimulator = "simulator" // in-memory pins, to run coffee pixie on a laptop
+)
+
+// Hardware is the backend the Raspberry Pi's pins are driven through
+type Hardware interface {
+	// Pin returns the GPIO with the given BCM number
+	Pin(n int) (gpio.PinIO, error)
+	// I2C opens the named I2C bus, "" for the first one found
+	I2C(name string) (i2c.Bus, error)
+}
+
+// NewHardware initialises the given backend, the real GPIOs if it is empty
+func NewHardware(backend string) (Hardware, error) {
+	switch backend {
+	case HardwarePeriph, "":
+		return NewPeriphHardware()
+	case HardwareSimulator:
+		log.Println("Simulating the Raspberry Pi's GPIOs")
+		return NewSimHardware(), nil
+	default:
+		return nil, fmt.Errorf("unknown hardware backend '%s', expected %s or %s", backend, HardwarePeriph, HardwareSimulator)
+	}
+}
+
+// periphHardware drives the Raspberry Pi's GPIOs through periph.io
+type periphHardware struct{}
+
+// NewPeriphHardware loads the periph.io drivers for the host
+func NewPeriphHardware() (Hardware, error) {
+	if _, err := host.Init(); err != nil {
+		return nil, fmt.Errorf("initialising periph.io: %w", err)
+	}
+	return periphHardware{}, nil
+}
+
+func (periphHardware) Pin(n int) (gpio.PinIO, error) {
+	g := gpioreg.ByName(fmt.Sprint(n))
+	if g == nil {
+		return nil, fmt.Errorf("no GPIO%d on this host", n)
+	}
+	return g, nil
+}
+
+func (periphHardware) I2C(name string) (i2c.Bus, error) {
+	bus, err := i2creg.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("opening I2C bus '%s': %w", name, err)
+	}
+	return bus, nil
+}
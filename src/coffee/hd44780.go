@@ -0,0 +1,114 @@
+package coffee
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"periph.io/x/conn/v3/i2c"
+)
+
+// the PCF8574 backpack's outputs, wired to the HD44780 in 4 bit mode, with the data on the upper 4 bits
+const (
+	pcf8574RS        = 0x01 // register select: 0 for commands, 1 for characters
+	pcf8574Enable    = 0x04 // the HD44780 reads the data on the falling edge
+	pcf8574Backlight = 0x08
+)
+
+// HD44780 commands, see the data sheet
+const (
+	hd44780Clear         = 0x01
+	hd44780EntryLeft     = 0x06 // the cursor moves right, the display doesn't shift
+	hd44780DisplayOn     = 0x0c // display on, cursor and blinking off
+	hd44780FunctionSet   = 0x28 // 4 bit interface, 2 lines, 5x8 dots
+	hd44780SetDDRAMAddr  = 0x80
+	hd44780DefaultAddr   = 0x27
+	hd44780CommandDelay  = 50 * time.Microsecond
+	hd44780ClearDuration = 2 * time.Millisecond
+)
+
+// hd44780RowOffsets are the display RAM addresses of the rows
+var hd44780RowOffsets = []byte{0x00, 0x40, 0x14, 0x54}
+
+// hd44780 drives a character LCD through a PCF8574 I2C port expander
+type hd44780 struct {
+	dev        *i2c.Dev
+	cols, rows int
+}
+
+func newHD44780(bus i2c.Bus, addr uint16, cols, rows int) (*hd44780, error) {
+
+	if cols <= 0 || rows <= 0 || rows > len(hd44780RowOffsets) {
+		return nil, fmt.Errorf("invalid HD44780 size %dx%d", cols, rows)
+	}
+	if addr == 0 {
+		addr = hd44780DefaultAddr
+	}
+	d := &hd44780{dev: &i2c.Dev{Bus: bus, Addr: addr}, cols: cols, rows: rows}
+
+	// the reset sequence switching the controller to 4 bit mode, whatever mode it was in
+	time.Sleep(50 * time.Millisecond)
+	for _, step := range []struct {
+		nibble byte
+		wait   time.Duration
+	}{{0x03, 5 * time.Millisecond}, {0x03, 200 * time.Microsecond}, {0x03, 200 * time.Microsecond}, {0x02, 200 * time.Microsecond}} {
+		if err := d.writeNibble(step.nibble, 0); err != nil {
+			return nil, fmt.Errorf("initialising HD44780 at 0x%02x: %w", addr, err)
+		}
+		time.Sleep(step.wait)
+	}
+	for _, cmd := range []byte{hd44780FunctionSet, hd44780DisplayOn, hd44780EntryLeft, hd44780Clear} {
+		if err := d.command(cmd); err != nil {
+			return nil, fmt.Errorf("initialising HD44780 at 0x%02x: %w", addr, err)
+		}
+	}
+	time.Sleep(hd44780ClearDuration)
+
+	log.Printf("Showing the status on a %dx%d HD44780 at 0x%02x on %s\n", cols, rows, addr, bus)
+	return d, nil
+}
+
+func (d *hd44780) Size() (cols, rows int) {
+	return d.cols, d.rows
+}
+
+// Show writes each line over the whole width of its row, which saves clearing the display and the flicker that comes with it
+func (d *hd44780) Show(lines []string) error {
+	for row := 0; row < d.rows; row++ {
+		line := ""
+		if row < len(lines) {
+			line = lines[row]
+		}
+		if err := d.command(hd44780SetDDRAMAddr | hd44780RowOffsets[row]); err != nil {
+			return err
+		}
+		for _, c := range []byte(fitLine(line, d.cols)) {
+			if err := d.write(c, pcf8574RS); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (d *hd44780) command(cmd byte) error {
+	return d.write(cmd, 0)
+}
+
+// write sends a byte as two nibbles, the upper one first
+func (d *hd44780) write(b byte, mode byte) error {
+	if err := d.writeNibble(b>>4, mode); err != nil {
+		return err
+	}
+	if err := d.writeNibble(b&0x0f, mode); err != nil {
+		return err
+	}
+	time.Sleep(hd44780CommandDelay)
+	return nil
+}
+
+// writeNibble puts the nibble on the data lines and pulses enable
+func (d *hd44780) writeNibble(nibble byte, mode byte) error {
+	b := nibble<<4 | mode | pcf8574Backlight
+	return d.dev.Tx([]byte{b | pcf8574Enable, b}, nil)
+}
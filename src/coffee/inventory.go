@@ -0,0 +1,169 @@
+package coffee
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+)
+
+type InventoryConfig struct {
+	// LowStock warns when fewer capsules than this are left of a type
+	LowStock int `yaml:"low_stock"`
+	// RefuseArmingWhenEmpty keeps a timer from arming while there are no capsules left for its next coffee
+	RefuseArmingWhenEmpty bool `yaml:"refuse_arming_when_empty"`
+}
+
+var InventoryConfigDefaults = InventoryConfig{
+	LowStock:              5,
+	RefuseArmingWhenEmpty: false,
+}
+
+// InventoryState is the stock the StateStore saves, by capsule type
+type InventoryState struct {
+	Stock map[string]int `json:"stock"`
+}
+
+// CapsuleStock is how many capsules of a type are left
+type CapsuleStock struct {
+	Capsule string
+	Count   int
+	Low     bool // none left, or fewer than the low stock threshold
+}
+
+// Inventory counts the capsules left by type. Only the types whose stock has been entered are counted,
+// a type nobody has refilled yet is untracked and never runs out.
+type Inventory struct {
+	mu          sync.Mutex
+	cfg         InventoryConfig
+	stock       map[string]int
+	changedFunc func()
+}
+
+func NewInventory(cfg InventoryConfig) *Inventory {
+	return &Inventory{cfg: cfg, stock: map[string]int{}}
+}
+
+// Stock lists the tracked capsule types with their counts, sorted by type
+func (inv *Inventory) Stock() []CapsuleStock {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+
+	stock := make([]CapsuleStock, 0, len(inv.stock))
+	for capsule, count := range inv.stock {
+		stock = append(stock, CapsuleStock{Capsule: capsule, Count: count, Low: count == 0 || count < inv.cfg.LowStock})
+	}
+	sort.Slice(stock, func(i, j int) bool { return stock[i].Capsule < stock[j].Capsule })
+	return stock
+}
+
+// Refill adds count capsules of the given type, which starts tracking it
+func (inv *Inventory) Refill(capsule string, count int) error {
+	if capsule == "" || count <= 0 {
+		return fmt.Errorf("invalid refill of %d '%s' capsules", count, capsule)
+	}
+
+	inv.mu.Lock()
+	inv.stock[capsule] += count
+	log.Printf("Refilled %d %s capsules, %d left\n", count, capsule, inv.stock[capsule])
+	inv.mu.Unlock()
+
+	inv.changed()
+	return nil
+}
+
+// SetStock sets how many capsules of the given type are left, e.g. after counting them
+func (inv *Inventory) SetStock(capsule string, count int) error {
+	if capsule == "" || count < 0 {
+		return fmt.Errorf("invalid stock of %d '%s' capsules", count, capsule)
+	}
+
+	inv.mu.Lock()
+	inv.stock[capsule] = count
+	log.Printf("%d %s capsules left\n", count, capsule)
+	inv.mu.Unlock()
+
+	inv.changed()
+	return nil
+}
+
+// Use takes count capsules of the given type out of the stock and warns if it is running low
+func (inv *Inventory) Use(capsule string, count int) {
+
+	inv.mu.Lock()
+	left, ok := inv.stock[capsule]
+	if !ok {
+		inv.mu.Unlock()
+		return
+	}
+	left -= count
+	if left < 0 {
+		log.Printf("Used %d %s capsules, but only %d were left, the stock needs counting\n", count, capsule, inv.stock[capsule])
+		left = 0
+	}
+	inv.stock[capsule] = left
+	switch {
+	case left == 0:
+		log.Printf("WARNING: out of %s capsules\n", capsule)
+	case left < inv.cfg.LowStock:
+		log.Printf("WARNING: only %d %s capsules left\n", left, capsule)
+	}
+	inv.mu.Unlock()
+
+	inv.changed()
+}
+
+// Check returns an error if fewer than count capsules of the given type are left. Untracked types always pass.
+func (inv *Inventory) Check(capsule string, count int) error {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+
+	if left, ok := inv.stock[capsule]; ok && left < count {
+		return fmt.Errorf("%d %s capsules left, %d needed", left, capsule, count)
+	}
+	return nil
+}
+
+// RefusesArmingWhenEmpty tells whether timers should not arm while a capsule type their next coffee needs has run out
+func (inv *Inventory) RefusesArmingWhenEmpty() bool {
+	return inv.cfg.RefuseArmingWhenEmpty
+}
+
+// State returns a snapshot of the stock, for the StateStore to save
+func (inv *Inventory) State() InventoryState {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+
+	state := InventoryState{Stock: map[string]int{}}
+	for capsule, count := range inv.stock {
+		state.Stock[capsule] = count
+	}
+	return state
+}
+
+// Restore replaces the stock with a saved one
+func (inv *Inventory) Restore(state InventoryState) {
+	inv.mu.Lock()
+	inv.stock = map[string]int{}
+	for capsule, count := range state.Stock {
+		inv.stock[capsule] = count
+	}
+	inv.mu.Unlock()
+}
+
+// SetChangedFunc sets a func called whenever the stock has changed, e.g. to save it
+func (inv *Inventory) SetChangedFunc(f func()) {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	inv.changedFunc = f
+}
+
+func (inv *Inventory) changed() {
+	inv.mu.Lock()
+	f := inv.changedFunc
+	inv.mu.Unlock()
+
+	if f != nil {
+		f()
+	}
+}
@@ -0,0 +1,73 @@
+package coffee
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRecipesUseUpCapsules(t *testing.T) {
+
+	n, _ := newTestMachine([]RecipeConfig{
+		{Name: BrewEspresso, Steps: []RecipeStep{{Press: "espresso"}}, Capsule: BrewEspresso},
+		{Name: "double espresso", Steps: []RecipeStep{{Press: "espresso"}, {Press: "espresso"}}, Capsule: BrewEspresso, Capsules: 2},
+		{Name: BrewLungo, Steps: []RecipeStep{{Press: "lungo"}}, Capsule: BrewLungo},
+	})
+	inv := NewInventory(InventoryConfig{LowStock: 2})
+	n.SetInventory(inv)
+	inv.Refill(BrewEspresso, 4)
+
+	for _, brew := range []string{BrewEspresso, "double espresso", BrewLungo} {
+		if err := n.RunRecipe(context.Background(), brew); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// lungo capsules have never been counted, so they are not tracked
+	stock := inv.Stock()
+	if len(stock) != 1 || stock[0] != (CapsuleStock{Capsule: BrewEspresso, Count: 1, Low: true}) {
+		t.Fatalf("expected 1 espresso capsule left and running low, got %v", stock)
+	}
+	if err := n.CheckStock("double espresso"); err == nil {
+		t.Fatal("expected double espresso to lack capsules")
+	}
+	if err := n.CheckStock(BrewLungo); err != nil {
+		t.Fatalf("expected untracked lungo capsules to pass, got %v", err)
+	}
+
+}
+
+func TestTimerRefusesArmingWithoutCapsules(t *testing.T) {
+
+	n, _ := newTestMachine([]RecipeConfig{{Name: BrewLungo, Steps: []RecipeStep{{Press: "lungo"}}, Capsule: BrewLungo}})
+	inv := NewInventory(InventoryConfig{RefuseArmingWhenEmpty: true})
+	n.SetInventory(inv)
+	inv.SetStock(BrewLungo, 0)
+
+	s := NewScheduler(newTestRaspi(), newFakeClock(testStart))
+	s.SetArmCheckFunc(n.CheckStock)
+	s.AddAlarm(AlarmConfig{ID: "default", Enabled: true, CoffeeTimerConfig: CoffeeTimerConfig{TriggerTime: "6:45", Brew: BrewLungo}})
+	s.Arm()
+	defer s.Disarm()
+
+	if _, _, _, ok := s.Next(); ok {
+		t.Fatal("expected no coffee to be pending without capsules")
+	}
+	a, _ := s.Alarm("default")
+	if a.Timer().ArmError() == nil {
+		t.Fatal("expected the timer to tell why it is not armed")
+	}
+
+	inv.Refill(BrewLungo, 1)
+	s.RecheckArming()
+	if _, triggerTime, _, ok := s.Next(); !ok || !triggerTime.Equal(testStart.Add(45*time.Minute)) {
+		t.Fatalf("expected the timer to arm after refilling, got %s", triggerTime)
+	}
+
+	inv.Use(BrewLungo, 1)
+	s.RecheckArming()
+	if _, _, _, ok := s.Next(); ok {
+		t.Fatal("expected the timer to disarm once the last capsule has been used")
+	}
+
+}
@@ -0,0 +1,237 @@
+package coffee
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// priorities of the patterns an LED plays, the highest one playing is shown
+const (
+	LedPriorityStatus  = 0 // the armed status
+	LedPriorityWarning = 1 // e.g. a brew held back
+	LedPriorityError   = 2
+)
+
+// LedStep switches the LED on or off for a while, 0 holds it until the pattern is stopped or replaced
+type LedStep struct {
+	On       bool
+	Duration time.Duration
+}
+
+// LedPattern is a named sequence of steps, played once or over and over again
+type LedPattern struct {
+	Name   string
+	Steps  []LedStep
+	Repeat bool
+}
+
+var (
+	LedSolid     = LedPattern{Name: "solid", Steps: []LedStep{{On: true}}}
+	LedBlink     = LedPattern{Name: "blink", Steps: []LedStep{{true, 200 * time.Millisecond}, {false, 200 * time.Millisecond}}, Repeat: true}
+	LedHeartbeat = LedPattern{Name: "heartbeat", Steps: []LedStep{{true, 100 * time.Millisecond}, {false, 100 * time.Millisecond}, {true, 100 * time.Millisecond}, {false, 700 * time.Millisecond}}, Repeat: true}
+)
+
+// LedErrorCode blinks n times, followed by a pause, so the code can be counted
+func LedErrorCode(n int) LedPattern {
+	p := LedPattern{Name: fmt.Sprintf("error code %d", n), Repeat: true}
+	for i := 0; i < n; i++ {
+		p.Steps = append(p.Steps, LedStep{true, 300 * time.Millisecond}, LedStep{false, 300 * time.Millisecond})
+	}
+	p.Steps = append(p.Steps, LedStep{false, 1500 * time.Millisecond})
+	return p
+}
+
+// ledLayer is a pattern played at a priority, until a time or forever if it is zero
+type ledLayer struct {
+	pattern LedPattern
+	until   time.Time
+}
+
+// ledDriver plays patterns on an LED in its own goroutine, so callers never wait for them
+type ledDriver struct {
+	out  *output
+	wake chan struct{}
+	done chan struct{}
+
+	mu      sync.Mutex
+	layers  map[int]ledLayer
+	halted  bool
+	version int // counts the changes of the layers, so the goroutine knows when to start over
+}
+
+func newLedDriver(out *output) *ledDriver {
+	d := &ledDriver{out: out, wake: make(chan struct{}, 1), done: make(chan struct{}), layers: map[int]ledLayer{}}
+	go d.run()
+	return d
+}
+
+// Play plays the pattern at the given priority for d, or until it is stopped if d is 0.
+// It replaces the pattern playing at the same priority.
+func (d *ledDriver) Play(p LedPattern, priority int, dur time.Duration) {
+	if d == nil {
+		return
+	}
+
+	layer := ledLayer{pattern: p}
+	if dur > 0 {
+		layer.until = time.Now().Add(dur)
+	}
+	d.mu.Lock()
+	d.layers[priority] = layer
+	d.version++
+	d.mu.Unlock()
+	d.notify()
+}
+
+// Stop stops the pattern playing at the given priority
+func (d *ledDriver) Stop(priority int) {
+	if d == nil {
+		return
+	}
+
+	d.mu.Lock()
+	delete(d.layers, priority)
+	d.version++
+	d.mu.Unlock()
+	d.notify()
+}
+
+// Playing returns the name of the pattern shown, "" while the LED is off
+func (d *ledDriver) Playing() string {
+	if d == nil {
+		return ""
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if layer, _, ok := d.top(); ok {
+		return layer.pattern.Name
+	}
+	return ""
+}
+
+// halt stops the goroutine and switches the LED off
+func (d *ledDriver) halt() {
+	if d == nil {
+		return
+	}
+
+	d.mu.Lock()
+	d.halted = true
+	d.mu.Unlock()
+	d.notify()
+	<-d.done
+}
+
+func (d *ledDriver) notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// top returns the layer with the highest priority that hasn't expired, dropping the expired ones.
+// It must be called while holding the lock.
+func (d *ledDriver) top() (layer ledLayer, priority int, ok bool) {
+	now := time.Now()
+	for prio, l := range d.layers {
+		if !l.until.IsZero() && !now.Before(l.until) {
+			delete(d.layers, prio)
+			continue
+		}
+		if !ok || prio > priority {
+			layer, priority, ok = l, prio, true
+		}
+	}
+	return layer, priority, ok
+}
+
+func (d *ledDriver) run() {
+	defer close(d.done)
+
+	for {
+		d.mu.Lock()
+		if d.halted {
+			d.mu.Unlock()
+			d.set(false)
+			return
+		}
+		layer, priority, ok := d.top()
+		version := d.version
+		d.mu.Unlock()
+
+		if !ok {
+			d.set(false)
+			<-d.wake
+			continue
+		}
+
+		if d.play(layer, version) {
+			// played to its end, unless it repeats
+			d.mu.Lock()
+			if d.version == version {
+				delete(d.layers, priority)
+				d.version++
+			}
+			d.mu.Unlock()
+		}
+	}
+}
+
+// play runs through the steps of the layer's pattern, over and over if it repeats. It returns true once a pattern
+// played once has finished, and false if it has been interrupted by a change of the layers or by expiring.
+func (d *ledDriver) play(layer ledLayer, version int) bool {
+
+	for {
+		for _, step := range layer.pattern.Steps {
+			d.set(step.On)
+
+			wait := step.Duration
+			if !layer.until.IsZero() {
+				left := time.Until(layer.until)
+				if left <= 0 {
+					return false
+				}
+				if wait == 0 || left < wait {
+					wait = left
+				}
+			}
+			if d.changed(wait, version) {
+				return false
+			}
+		}
+		if !layer.pattern.Repeat {
+			return true
+		}
+	}
+}
+
+// changed waits for wait, forever if it is 0, and tells whether the layers have changed or the driver has been halted meanwhile
+func (d *ledDriver) changed(wait time.Duration, version int) bool {
+
+	var timeout <-chan time.Time
+	if wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	for {
+		select {
+		case <-d.wake:
+			d.mu.Lock()
+			changed := d.version != version || d.halted
+			d.mu.Unlock()
+			if changed {
+				return true
+			}
+		case <-timeout:
+			return false
+		}
+	}
+}
+
+func (d *ledDriver) set(on bool) {
+	d.out.set(on)
+}
@@ -0,0 +1,62 @@
+package coffee
+
+import (
+	"testing"
+	"time"
+
+	"periph.io/x/conn/v3/gpio"
+)
+
+func TestLedPriorities(t *testing.T) {
+
+	sim := NewSimHardware()
+	pin := sim.pin(17)
+	led := newLedDriver(newOutput("Armed LED", pin, LedOutputDefaults))
+	defer led.halt()
+
+	expect := func(pattern string, l gpio.Level) {
+		t.Helper()
+		time.Sleep(20 * time.Millisecond)
+		if got := led.Playing(); got != pattern {
+			t.Errorf("expected %s to be playing, got '%s'", pattern, got)
+		}
+		if got := pin.Read(); got != l {
+			t.Errorf("expected the LED to be %s, got %s", l, got)
+		}
+	}
+
+	led.Play(LedSolid, LedPriorityStatus, 0)
+	expect("solid", gpio.High)
+
+	// a warning overrides the status until it ends, and the status shows again afterwards
+	led.Play(LedPattern{Name: "flash", Steps: []LedStep{{false, 100 * time.Millisecond}}}, LedPriorityWarning, 0)
+	expect("flash", gpio.Low)
+	time.Sleep(100 * time.Millisecond)
+	expect("solid", gpio.High)
+
+	led.Play(LedErrorCode(2), LedPriorityError, 50*time.Millisecond)
+	expect("error code 2", gpio.High)
+	led.Play(LedBlink, LedPriorityStatus, 0)
+	expect("error code 2", gpio.High)
+	time.Sleep(50 * time.Millisecond)
+	if got := led.Playing(); got != "blink" {
+		t.Errorf("expected the status to blink once the error code has expired, got '%s'", got)
+	}
+
+	led.Stop(LedPriorityStatus)
+	expect("", gpio.Low)
+}
+
+func TestLedErrorCode(t *testing.T) {
+
+	p := LedErrorCode(3)
+	on := 0
+	for _, step := range p.Steps {
+		if step.On {
+			on++
+		}
+	}
+	if on != 3 || !p.Repeat || p.Steps[len(p.Steps)-1].On {
+		t.Errorf("expected 3 blinks followed by a pause, got %v", p.Steps)
+	}
+}
@@ -0,0 +1,172 @@
+package coffee
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// CoffeeMachine is the driver for one coffee machine model
+type CoffeeMachine interface {
+	// Recipes lists the names of the brews the machine can make
+	Recipes() []string
+	// RunRecipe makes the named brew. If ctx is cancelled, it stops with all buttons and relays released.
+	RunRecipe(ctx context.Context, name string) error
+	// PowerOn switches the machine on ahead of making the given brew, so it has heated up by then
+	PowerOn(ctx context.Context, brew string) error
+	// WarmUpLead is how long ahead of the trigger time PowerOn has to be called, 0 if the machine needs no warm-up
+	WarmUpLead() time.Duration
+	// SetSensor makes a sensor available to the recipes
+	SetSensor(name string, ready func() bool)
+	// State returns what the machine is doing and since when
+	State() (MachineState, time.Time)
+	// SetStateChangedFunc sets a func called with each new state. It must return at once and must not call back into the machine.
+	SetStateChangedFunc(f func(state MachineState))
+	// SetInventory makes the recipes take the capsules they use out of the inventory
+	SetInventory(inv *Inventory)
+	// CheckStock returns an error if the inventory lacks the capsules the named brew needs
+	CheckStock(brew string) error
+	// SetMaintenance makes the recipes count towards descaling and emptying the drip tray
+	SetMaintenance(m *Maintenance)
+	// SetHeldBackFunc sets a func called with the reason whenever a brew is held back, e.g. as there is no cup in place
+	SetHeldBackFunc(f func(reason string))
+	// SetCurrentSensor makes the brews confirm they have started and finished by the current the machine draws
+	SetCurrentSensor(sensor CurrentSensor, cfg CurrentSensorConfig)
+}
+
+type MachineConfig struct {
+	// Model selects the driver, see MachineModels
+	Model                 string `yaml:"model"`
+	ButtonPressDurationMs int    `yaml:"button_press_duration_ms"`
+	// HeatingDurationMs is how long the machine takes to heat up after switching it on, until it accepts the brew press
+	HeatingDurationMs int `yaml:"heating_duration_ms"`
+	// PowerOnEarly switches the machine on ahead of the trigger time by the heating duration, so the coffee is made on time
+	PowerOnEarly bool `yaml:"power_on_early"`
+	// AutoOffMinutes is how long the machine stays on without brewing before it switches itself off
+	AutoOffMinutes int `yaml:"auto_off_minutes"`
+	// Recipes are the brews on offer, the model's default recipes if empty
+	Recipes []RecipeConfig `yaml:"recipes,omitempty"`
+	// CupWaitMs is how long a brew waits for a cup to be put in place if the cup sensor reports none, 0 refuses to brew at once
+	CupWaitMs int `yaml:"cup_wait_ms"`
+}
+
+var MachineConfigDefaults = MachineConfig{
+	Model:                 MachineModelNespresso,
+	ButtonPressDurationMs: 300,
+	HeatingDurationMs:     25000,
+	PowerOnEarly:          true,
+	AutoOffMinutes:        9,
+	CupWaitMs:             60000,
+}
+
+// ReadMachineConfig reads the machine section of a config file, with the defaults for anything it leaves out.
+// A config file written before there were machine models has a nespresso_machine section instead,
+// which is read as the Nespresso model and reported as deprecated.
+func ReadMachineConfig(data []byte) (cfg MachineConfig, deprecated bool, err error) {
+
+	// only the sections that are there tell which one to read, as both are filled with the defaults
+	var sections struct {
+		Machine          yaml.MapSlice `yaml:"machine"`
+		NespressoMachine yaml.MapSlice `yaml:"nespresso_machine"`
+	}
+	if err := yaml.Unmarshal(data, &sections); err != nil {
+		return cfg, false, err
+	}
+
+	cfg = MachineConfigDefaults
+	if sections.Machine == nil && sections.NespressoMachine != nil {
+		file := struct {
+			NespressoMachine *MachineConfig `yaml:"nespresso_machine"`
+		}{&cfg}
+		if err := yaml.Unmarshal(data, &file); err != nil {
+			return cfg, true, err
+		}
+		cfg.Model = MachineModelNespresso
+		return cfg, true, nil
+	}
+
+	file := struct {
+		Machine *MachineConfig `yaml:"machine"`
+	}{&cfg}
+	err = yaml.Unmarshal(data, &file)
+	return cfg, false, err
+}
+
+// SensorCup is the sensor telling whether a cup is in place, brews are held back while it reports false
+const SensorCup = "cup"
+
+// MachineDriver builds the driver for a machine model
+type MachineDriver func(cfg MachineConfig, raspi *raspberrypi) CoffeeMachine
+
+var (
+	machineDriversMu sync.Mutex
+	machineDrivers   = map[string]MachineDriver{}
+)
+
+// RegisterMachine makes a driver available under the given model name
+func RegisterMachine(model string, driver MachineDriver) {
+	machineDriversMu.Lock()
+	defer machineDriversMu.Unlock()
+
+	model = strings.ToLower(model)
+	if _, ok := machineDrivers[model]; ok {
+		panic(fmt.Sprintf("machine model '%s' registered twice", model))
+	}
+	machineDrivers[model] = driver
+}
+
+// MachineModels lists the registered machine models
+func MachineModels() []string {
+	machineDriversMu.Lock()
+	defer machineDriversMu.Unlock()
+
+	models := make([]string, 0, len(machineDrivers))
+	for model := range machineDrivers {
+		models = append(models, model)
+	}
+	sort.Strings(models)
+	return models
+}
+
+// NewCoffeeMachine builds the driver for the configured model
+func NewCoffeeMachine(cfg MachineConfig, raspi *raspberrypi) (CoffeeMachine, error) {
+
+	model := strings.ToLower(cfg.Model)
+	if model == "" {
+		model = MachineConfigDefaults.Model
+	}
+
+	machineDriversMu.Lock()
+	driver, ok := machineDrivers[model]
+	machineDriversMu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown machine model '%s', expected one of %s", cfg.Model, strings.Join(MachineModels(), ", "))
+	}
+
+	log.Println("Driving a", model, "machine")
+	m := driver(cfg, raspi)
+	if len(m.Recipes()) == 0 {
+		return nil, fmt.Errorf("no valid recipe left for the %s machine, see the skipped recipes above", model)
+	}
+	return m, nil
+}
+
+type progressKey struct{}
+
+// ReportProgress lets a driver tell whoever runs a recipe, usually the BrewExecutor, which step it has got to
+func ReportProgress(ctx context.Context, step, steps int, description string) {
+	if report, ok := ctx.Value(progressKey{}).(func(step, steps int, description string)); ok {
+		report(step, steps, description)
+	}
+}
+
+// withProgress returns a context whose recipes report their progress to report
+func withProgress(ctx context.Context, report func(step, steps int, description string)) context.Context {
+	return context.WithValue(ctx, progressKey{}, report)
+}
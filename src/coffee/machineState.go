@@ -0,0 +1,136 @@
+package coffee
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// MachineState is what the machine is doing, as far as coffee pixie can tell from the buttons it has pressed and the time passed
+type MachineState int
+
+const (
+	MachineOff     MachineState = iota // switched off, or not switched on since coffee pixie started
+	MachineHeating                     // switched on, but not hot enough to make coffee yet
+	MachineReady                       // hot, pressing a brew button makes coffee
+	MachineBrewing                     // making coffee
+	MachineAsleep                      // switched itself off after standing idle for the auto-off time
+)
+
+func (s MachineState) String() string {
+	switch s {
+	case MachineOff:
+		return "off"
+	case MachineHeating:
+		return "heating"
+	case MachineReady:
+		return "ready"
+	case MachineBrewing:
+		return "brewing"
+	case MachineAsleep:
+		return "asleep"
+	default:
+		return fmt.Sprintf("MachineState(%d)", int(s))
+	}
+}
+
+// IsOn tells whether a button press would make coffee rather than switch the machine on
+func (s MachineState) IsOn() bool {
+	return s == MachineHeating || s == MachineReady || s == MachineBrewing
+}
+
+// machineStateTracker follows the state of a machine that cannot be read back. The button presses are reported to it,
+// and it moves on by itself once the machine has heated up, and once it has stood idle for the auto-off time.
+type machineStateTracker struct {
+	mu              sync.Mutex
+	clock           Clock
+	heatingDuration time.Duration
+	autoOff         time.Duration
+	state           MachineState
+	since           time.Time
+	activeAt        time.Time // when the machine has last been switched on or made coffee, it switches itself off autoOff later
+	timer           ClockTimer
+	transitions     int // tells a timer whether the state has moved on since it was set
+	changedFunc     func(state MachineState)
+}
+
+func newMachineStateTracker(clock Clock, heatingDuration time.Duration, autoOff time.Duration) *machineStateTracker {
+	return &machineStateTracker{clock: clock, heatingDuration: heatingDuration, autoOff: autoOff, state: MachineOff, since: clock.Now()}
+}
+
+// State returns the current state and when the machine has entered it
+func (t *machineStateTracker) State() (MachineState, time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.state, t.since
+}
+
+// setChangedFunc sets a func called with each new state. It is called while holding the lock, so it must not call back into the tracker.
+func (t *machineStateTracker) setChangedFunc(f func(state MachineState)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.changedFunc = f
+}
+
+// heatingLeft is how long the machine still needs to heat up, 0 unless it is heating
+func (t *machineStateTracker) heatingLeft() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.state != MachineHeating {
+		return 0
+	}
+	return t.heatingDuration - t.clock.Now().Sub(t.since)
+}
+
+// set moves the machine to the given state, logging why. Setting the state it is in already changes nothing.
+func (t *machineStateTracker) set(state MachineState, reason string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.transition(state, reason)
+}
+
+// transition moves the machine to the given state and starts the timer for the transition that follows by itself.
+// It must be called while holding the lock.
+func (t *machineStateTracker) transition(state MachineState, reason string) {
+
+	if state == t.state {
+		return
+	}
+	now := t.clock.Now()
+	log.Printf("Machine %s -> %s (%s), after %s\n", t.state, state, reason, now.Sub(t.since).Truncate(time.Second))
+
+	if t.timer != nil {
+		t.timer.Stop()
+		t.timer = nil
+	}
+	t.state, t.since = state, now
+	t.transitions++
+	if state == MachineHeating || state == MachineBrewing {
+		t.activeAt = now
+	}
+
+	if t.changedFunc != nil {
+		t.changedFunc(state)
+	}
+
+	switch state {
+	case MachineHeating:
+		t.after(t.heatingDuration, MachineReady, "heated up")
+	case MachineReady:
+		t.after(t.activeAt.Add(t.autoOff).Sub(now), MachineAsleep, fmt.Sprintf("idle for %s", t.autoOff))
+	}
+}
+
+// after moves the machine on to state after d, unless it has moved on otherwise by then. It must be called while holding the lock.
+func (t *machineStateTracker) after(d time.Duration, state MachineState, reason string) {
+	transitions := t.transitions
+	t.timer = t.clock.AfterFunc(d, func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if t.transitions == transitions {
+			t.transition(state, reason)
+		}
+	})
+}
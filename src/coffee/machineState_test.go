@@ -0,0 +1,76 @@
+package coffee
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMachineStateFollowsTimeLimits(t *testing.T) {
+
+	clock := newFakeClock(testStart)
+	st := newMachineStateTracker(clock, 25*time.Second, 9*time.Minute)
+
+	expect := func(want MachineState) {
+		t.Helper()
+		if got, _ := st.State(); got != want {
+			t.Fatalf("at %s: expected machine %s, got %s", clock.Now().Format("15:04:05"), want, got)
+		}
+	}
+
+	expect(MachineOff)
+	st.set(MachineHeating, "switched on")
+	clock.Advance(20 * time.Second)
+	expect(MachineHeating)
+	if left := st.heatingLeft(); left != 5*time.Second {
+		t.Fatalf("expected 5s of heating left, got %s", left)
+	}
+	clock.Advance(5 * time.Second)
+	expect(MachineReady)
+
+	// auto-off counts from switching on, not from having heated up
+	clock.Advance(8*time.Minute + 34*time.Second)
+	expect(MachineReady)
+	st.set(MachineBrewing, "espresso pressed")
+	st.set(MachineReady, "espresso made")
+
+	// making coffee restarts the auto-off time
+	clock.Advance(8 * time.Minute)
+	expect(MachineReady)
+	clock.Advance(time.Minute)
+	expect(MachineAsleep)
+
+}
+
+func TestRecipeSwitchesOnOnlyIfMachineIsOff(t *testing.T) {
+
+	n, events := newTestMachine(nil)
+	clock := newFakeClock(testStart)
+	n.state = newMachineStateTracker(clock, 0, 9*time.Minute)
+
+	run := func(brew string, want string) {
+		t.Helper()
+		if err := n.RunRecipe(context.Background(), brew); err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := n.State(); got != MachineReady {
+			t.Fatalf("expected machine ready after %s, got %s", brew, got)
+		}
+		if got := strings.Join(events(), ","); !strings.HasSuffix(got, want) {
+			t.Fatalf("expected presses to end in %s, got %s", want, got)
+		}
+	}
+
+	run(BrewLungo, "press lungo,release lungo,press lungo,release lungo")
+	clock.Advance(5 * time.Minute)
+	run(BrewEspresso, "release lungo,press espresso,release espresso")
+
+	// once asleep, the first press switches the machine on again
+	clock.Advance(10 * time.Minute)
+	if got, _ := n.State(); got != MachineAsleep {
+		t.Fatalf("expected machine asleep, got %s", got)
+	}
+	run(BrewEspresso, "release espresso,press espresso,release espresso,press espresso,release espresso")
+
+}
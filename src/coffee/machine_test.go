@@ -0,0 +1,113 @@
+package coffee
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestNewCoffeeMachineBuildsRegisteredModels(t *testing.T) {
+
+	for model, wantRecipes := range map[string]string{
+		"":                    "espresso,lungo",
+		MachineModelNespresso: "espresso,lungo",
+		"Vertuo":              "espresso,lungo",
+		MachineModelDeLonghi:  "espresso,lungo",
+		MachineModelFilter:    BrewFilterCoffee,
+	} {
+		m, err := NewCoffeeMachine(MachineConfig{Model: model, PowerOnEarly: true, HeatingDurationMs: 1000}, newTestRaspi())
+		if err != nil {
+			t.Errorf("model '%s': %v", model, err)
+			continue
+		}
+		if got := strings.Join(m.Recipes(), ","); got != wantRecipes {
+			t.Errorf("model '%s': expected default recipes %s, got %s", model, wantRecipes, got)
+		}
+		if wantLead := model != MachineModelFilter; (m.WarmUpLead() > 0) != wantLead {
+			t.Errorf("model '%s': unexpected warm-up lead %s", model, m.WarmUpLead())
+		}
+	}
+
+	if _, err := NewCoffeeMachine(MachineConfig{Model: "moka pot"}, newTestRaspi()); err == nil {
+		t.Fatal("expected error for unknown model")
+	}
+
+	// all recipes pressing buttons the machine doesn't have
+	cfg := MachineConfig{Model: MachineModelNespresso, Recipes: []RecipeConfig{{Name: "cappuccino", Steps: []RecipeStep{{Press: "frother", DurationMs: 100}}}}}
+	if _, err := NewCoffeeMachine(cfg, newTestRaspi()); err == nil || !strings.Contains(err.Error(), "no valid recipe") {
+		t.Fatalf("expected error without a valid recipe, got %v", err)
+	}
+
+}
+
+func TestReadMachineConfig(t *testing.T) {
+
+	// as written before there were machine models
+	baseline := `raspberry_pi:
+  espresso_button_pin: 27
+  lungo_button_pin: 22
+  armed_led_pin: 17
+  disarmed_led_pin: 4
+  arm_button_pin: -1
+  check_status_button_pin: -1
+  button_press_detecting_duration_ms: 300
+nespresso_machine:
+  button_press_duration_ms: 500
+timer:
+  trigger_time: "8:30"
+`
+	cfg, deprecated, err := ReadMachineConfig([]byte(baseline))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !deprecated {
+		t.Error("expected nespresso_machine to be reported as deprecated")
+	}
+	if cfg.Model != MachineModelNespresso || cfg.ButtonPressDurationMs != 500 {
+		t.Errorf("expected the nespresso_machine section to be read, got %+v", cfg)
+	}
+	if cfg.HeatingDurationMs != MachineConfigDefaults.HeatingDurationMs {
+		t.Errorf("expected the defaults for anything the section leaves out, got %+v", cfg)
+	}
+
+	// the machine section wins, whatever its model
+	cfg, deprecated, err = ReadMachineConfig([]byte(baseline + "machine:\n  model: filter\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deprecated || cfg.Model != MachineModelFilter || cfg.ButtonPressDurationMs != MachineConfigDefaults.ButtonPressDurationMs {
+		t.Errorf("expected the machine section to be read, got %+v, deprecated %t", cfg, deprecated)
+	}
+
+	// neither section leaves the defaults
+	cfg, deprecated, err = ReadMachineConfig([]byte("timer:\n  trigger_time: \"8:30\"\n"))
+	if err != nil || deprecated || cfg.Model != MachineConfigDefaults.Model || cfg.ButtonPressDurationMs != MachineConfigDefaults.ButtonPressDurationMs {
+		t.Errorf("expected the defaults, got %+v, deprecated %t, %v", cfg, deprecated, err)
+	}
+}
+
+func TestDeLonghiWarmsUpWithPowerButton(t *testing.T) {
+
+	n := NewDeLonghiMachine(MachineConfig{ButtonPressDurationMs: 1, HeatingDurationMs: 1}, newTestRaspi()).(*buttonMachine)
+	var presses []string
+	for name := range n.buttons {
+		name := name
+		n.buttons[name] = func(press bool) {
+			if press {
+				presses = append(presses, name)
+			}
+		}
+	}
+
+	for _, brew := range []string{BrewLungo, BrewEspresso} {
+		if err := n.RunRecipe(context.Background(), brew); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the machine is still on for the second coffee, so pressing power again would switch it off
+	if got := strings.Join(presses, ","); got != "power,lungo,espresso" {
+		t.Fatalf("expected power,lungo,espresso, got %s", got)
+	}
+
+}
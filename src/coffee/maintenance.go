@@ -0,0 +1,180 @@
+package coffee
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	MaintenanceDescale  = "descale"
+	MaintenanceDripTray = "drip tray"
+)
+
+// maintenanceTasks are the tasks counted, in the order they are shown
+var maintenanceTasks = []string{MaintenanceDescale, MaintenanceDripTray}
+
+// MaintenanceConfig sets when a task is due, by the number of brews or the water used since it has last been done. 0 disables a limit.
+type MaintenanceConfig struct {
+	DescaleAfterBrews    int `yaml:"descale_after_brews"`
+	DescaleAfterWaterMl  int `yaml:"descale_after_water_ml"`
+	DripTrayAfterBrews   int `yaml:"drip_tray_after_brews"`
+	DripTrayAfterWaterMl int `yaml:"drip_tray_after_water_ml"`
+}
+
+var MaintenanceConfigDefaults = MaintenanceConfig{
+	DescaleAfterBrews:    300,
+	DescaleAfterWaterMl:  30000,
+	DripTrayAfterBrews:   15,
+	DripTrayAfterWaterMl: 0,
+}
+
+// limits returns the brews and water after which the task is due
+func (cfg MaintenanceConfig) limits(task string) (brews, waterMl int) {
+	switch task {
+	case MaintenanceDescale:
+		return cfg.DescaleAfterBrews, cfg.DescaleAfterWaterMl
+	case MaintenanceDripTray:
+		return cfg.DripTrayAfterBrews, cfg.DripTrayAfterWaterMl
+	default:
+		return 0, 0
+	}
+}
+
+// MaintenanceCounter counts the brews and the water used since a task has last been done
+type MaintenanceCounter struct {
+	Task    string    `json:"-"`
+	Brews   int       `json:"brews"`
+	WaterMl int       `json:"water_ml"`
+	Since   time.Time `json:"since"`
+	Due     bool      `json:"-"`
+}
+
+// MaintenanceState is what the StateStore saves, by task
+type MaintenanceState struct {
+	Counters map[string]MaintenanceCounter `json:"counters"`
+}
+
+// Maintenance counts the brews and the estimated water used since descaling and since emptying the drip tray,
+// and reminds once a configured limit has been reached
+type Maintenance struct {
+	mu          sync.Mutex
+	cfg         MaintenanceConfig
+	counters    map[string]MaintenanceCounter
+	changedFunc func()
+}
+
+func NewMaintenance(cfg MaintenanceConfig) *Maintenance {
+	m := &Maintenance{cfg: cfg, counters: map[string]MaintenanceCounter{}}
+	for _, task := range maintenanceTasks {
+		m.counters[task] = MaintenanceCounter{Since: time.Now()}
+	}
+	return m
+}
+
+// Count adds a brew using the given water to all counters, and logs a reminder for each task that has just become due
+func (m *Maintenance) Count(waterMl int) {
+
+	m.mu.Lock()
+	for _, task := range maintenanceTasks {
+		c := m.counters[task]
+		wasDue := m.due(task, c)
+		c.Brews++
+		c.WaterMl += waterMl
+		m.counters[task] = c
+		if !wasDue && m.due(task, c) {
+			log.Printf("REMINDER: %s is due, %d brews and %dml of water since %s\n", task, c.Brews, c.WaterMl, c.Since.Format("2 Jan 2006"))
+		}
+	}
+	m.mu.Unlock()
+
+	m.changed()
+}
+
+// Counters lists the counters of all tasks, with whether they are due
+func (m *Maintenance) Counters() []MaintenanceCounter {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	counters := make([]MaintenanceCounter, 0, len(maintenanceTasks))
+	for _, task := range maintenanceTasks {
+		c := m.counters[task]
+		c.Task, c.Due = task, m.due(task, c)
+		counters = append(counters, c)
+	}
+	return counters
+}
+
+// Reset starts counting again for the given task, once it has been done
+func (m *Maintenance) Reset(task string) error {
+
+	m.mu.Lock()
+	c, ok := m.counters[task]
+	if !ok {
+		m.mu.Unlock()
+		return fmt.Errorf("unknown maintenance task '%s'", task)
+	}
+	log.Printf("Done %s after %d brews and %dml of water since %s\n", task, c.Brews, c.WaterMl, c.Since.Format("2 Jan 2006"))
+	m.counters[task] = MaintenanceCounter{Since: time.Now()}
+	m.mu.Unlock()
+
+	m.changed()
+	return nil
+}
+
+// ResetDue resets the counters of all tasks that are due, e.g. when the check status button is held
+func (m *Maintenance) ResetDue() {
+	for _, c := range m.Counters() {
+		if c.Due {
+			m.Reset(c.Task)
+		}
+	}
+}
+
+// State returns a snapshot of the counters, for the StateStore to save
+func (m *Maintenance) State() MaintenanceState {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	state := MaintenanceState{Counters: map[string]MaintenanceCounter{}}
+	for task, c := range m.counters {
+		state.Counters[task] = c
+	}
+	return state
+}
+
+// Restore replaces the counters with saved ones, tasks missing from the saved state keep counting from now
+func (m *Maintenance) Restore(state MaintenanceState) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, task := range maintenanceTasks {
+		if c, ok := state.Counters[task]; ok {
+			m.counters[task] = c
+		}
+	}
+}
+
+// SetChangedFunc sets a func called whenever a counter has changed, e.g. to save it
+func (m *Maintenance) SetChangedFunc(f func()) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.changedFunc = f
+}
+
+func (m *Maintenance) changed() {
+	m.mu.Lock()
+	f := m.changedFunc
+	m.mu.Unlock()
+
+	if f != nil {
+		f()
+	}
+}
+
+// due tells whether the counter has reached one of the task's limits. It must be called while holding the lock.
+func (m *Maintenance) due(task string, c MaintenanceCounter) bool {
+	brews, waterMl := m.cfg.limits(task)
+	return (brews > 0 && c.Brews >= brews) || (waterMl > 0 && c.WaterMl >= waterMl)
+}
@@ -0,0 +1,73 @@
+package coffee
+
+import (
+	"context"
+	"testing"
+)
+
+func TestMaintenanceRemindsAndResets(t *testing.T) {
+
+	n, _ := newTestMachine([]RecipeConfig{{Name: BrewLungo, Steps: []RecipeStep{{Press: "lungo"}}, WaterMl: 110}})
+	m := NewMaintenance(MaintenanceConfig{DescaleAfterWaterMl: 300, DripTrayAfterBrews: 2})
+	n.SetMaintenance(m)
+
+	due := func() map[string]bool {
+		due := map[string]bool{}
+		for _, c := range m.Counters() {
+			due[c.Task] = c.Due
+		}
+		return due
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := n.RunRecipe(context.Background(), BrewLungo); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d := due(); d[MaintenanceDescale] || !d[MaintenanceDripTray] {
+		t.Fatalf("expected only the drip tray to be due after 2 brews, got %v", d)
+	}
+
+	m.ResetDue()
+	n.RunRecipe(context.Background(), BrewLungo)
+	if d := due(); !d[MaintenanceDescale] || d[MaintenanceDripTray] {
+		t.Fatalf("expected only descaling to be due after 330ml, got %v", d)
+	}
+	if c := m.Counters()[1]; c.Task != MaintenanceDripTray || c.Brews != 1 || c.WaterMl != 110 {
+		t.Fatalf("expected the drip tray to count from the reset, got %+v", c)
+	}
+
+	if err := m.Reset("milk frother"); err == nil {
+		t.Fatal("expected error for unknown task")
+	}
+
+}
+
+func TestMaintenanceCountersSurviveRestart(t *testing.T) {
+
+	store, err := NewStateStore(StateStoreConfig{Dir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewMaintenance(MaintenanceConfigDefaults)
+	m.Count(40)
+	m.Count(110)
+	if err := store.Save("maintenance", m.State()); err != nil {
+		t.Fatal(err)
+	}
+
+	var state MaintenanceState
+	if ok, err := store.Load("maintenance", &state); !ok || err != nil {
+		t.Fatalf("expected saved counters, got %t, %v", ok, err)
+	}
+	restored := NewMaintenance(MaintenanceConfigDefaults)
+	restored.Restore(state)
+
+	for i, c := range restored.Counters() {
+		if c.Brews != 2 || c.WaterMl != 150 || !c.Since.Equal(m.Counters()[i].Since) {
+			t.Errorf("%s: expected 2 brews and 150ml since %s, got %+v", c.Task, m.Counters()[i].Since, c)
+		}
+	}
+
+}
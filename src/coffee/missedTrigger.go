@@ -0,0 +1,154 @@
+package coffee
+
+import (
+	"log"
+	"time"
+)
+
+const (
+	MissedTriggerSkip   = "skip"   // don't make a missed coffee
+	MissedTriggerBrew   = "brew"   // make a missed coffee if it is not too late
+	MissedTriggerNotify = "notify" // don't make a missed coffee, but show it on the web page until dismissed
+)
+
+// missedTriggerTolerance is how late a trigger may fire before it counts as missed
+const missedTriggerTolerance = time.Minute
+
+// clockCheckInterval is how often an armed timer compares the wall clock with the monotonic clock and its pending trigger
+const clockCheckInterval = time.Minute
+
+// MissedTriggerConfig says what to do about a coffee that has not been made at its trigger time,
+// because the Pi was down or the wall clock jumped, e.g. when NTP syncs late on a Pi without a real time clock
+type MissedTriggerConfig struct {
+	Policy string `yaml:"policy"`
+	// MaxLateMinutes limits how late a missed coffee is still made with the brew policy
+	MaxLateMinutes int `yaml:"max_late_minutes"`
+}
+
+var MissedTriggerConfigDefaults = MissedTriggerConfig{
+	Policy:         MissedTriggerBrew,
+	MaxLateMinutes: 15,
+}
+
+func (cfg MissedTriggerConfig) policy() string {
+	if cfg.Policy == "" {
+		return MissedTriggerConfigDefaults.Policy
+	}
+	return cfg.Policy
+}
+
+func (cfg MissedTriggerConfig) maxLate() time.Duration {
+	if cfg.MaxLateMinutes <= 0 {
+		return time.Duration(MissedTriggerConfigDefaults.MaxLateMinutes) * time.Minute
+	}
+	return time.Duration(cfg.MaxLateMinutes) * time.Minute
+}
+
+// MissedTrigger is a coffee that has not been made at its trigger time
+type MissedTrigger struct {
+	Time time.Time
+	Brew string
+	Late time.Duration
+}
+
+// CatchUp applies the missed trigger policy to a coffee that should have been made at triggerTime, e.g. while the Pi was down
+func (ct *CoffeeTimer) CatchUp(triggerTime time.Time, brew string) {
+	ct.catchUp(triggerTime, brew, ct.clock.Now().Sub(triggerTime))
+	ct.changed()
+}
+
+// catchUp decides what to do about a coffee that is late, and logs the decision.
+// It makes the coffee on the calling goroutine, so it must not be called while holding the lock.
+func (ct *CoffeeTimer) catchUp(triggerTime time.Time, brew string, late time.Duration) {
+
+	ct.mu.Lock()
+	cfg := ct.missedTriggers
+	brewFunc := ct.brewFunc
+	ct.mu.Unlock()
+
+	late = late.Truncate(time.Second)
+	switch cfg.policy() {
+	case MissedTriggerBrew:
+		if late > cfg.maxLate() {
+			log.Printf("Missed %s at %s, skipping it as it is %s late, more than %s\n", brew, triggerTime, late, cfg.maxLate())
+			return
+		}
+		log.Printf("Missed %s at %s, making it %s late\n", brew, triggerTime, late)
+		brewFunc(brew)
+	case MissedTriggerNotify:
+		log.Printf("Missed %s at %s, %s late, not making it but notifying\n", brew, triggerTime, late)
+		ct.mu.Lock()
+		ct.missed = append(ct.missed, MissedTrigger{Time: triggerTime, Brew: brew, Late: late})
+		ct.mu.Unlock()
+	default:
+		log.Printf("Missed %s at %s, skipping it as it is %s late\n", brew, triggerTime, late)
+	}
+}
+
+// MissedTriggers lists the missed coffees notified about since they were last dismissed
+func (ct *CoffeeTimer) MissedTriggers() []MissedTrigger {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	return append([]MissedTrigger(nil), ct.missed...)
+}
+
+// DismissMissedTriggers clears the missed coffees notified about
+func (ct *CoffeeTimer) DismissMissedTriggers() {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	ct.missed = nil
+}
+
+// SetMissedTriggerConfig sets what to do about coffees that have not been made at their trigger time
+func (ct *CoffeeTimer) SetMissedTriggerConfig(cfg MissedTriggerConfig) {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	ct.missedTriggers = cfg
+}
+
+func (ct *CoffeeTimer) startClockCheck() {
+	ct.lastClockCheck, ct.lastMonotonic = ct.clock.Now(), ct.clock.Monotonic()
+	ct.clockCheck = ct.clock.AfterFunc(clockCheckInterval, ct.checkClock)
+}
+
+func (ct *CoffeeTimer) stopClockCheck() {
+	if ct.clockCheck != nil {
+		ct.clockCheck.Stop()
+		ct.clockCheck = nil
+	}
+}
+
+// checkClock catches a pending trigger whose timer has not fired although its time has passed on the wall clock.
+// Timers run on the monotonic clock, so they fire late or early if the wall clock is set after arming.
+func (ct *CoffeeTimer) checkClock() {
+
+	ct.mu.Lock()
+	if !ct.isArmed || ct.cancellableTimer == nil {
+		ct.mu.Unlock()
+		return
+	}
+
+	now, monotonic := ct.clock.Now(), ct.clock.Monotonic()
+	// Round(0) strips the monotonic reading from the wall clock times, so the jump is how much further the wall clock has moved
+	// than the time that has passed
+	jump := now.Round(0).Sub(ct.lastClockCheck.Round(0)) - (monotonic - ct.lastMonotonic)
+	if jump > missedTriggerTolerance || jump < -missedTriggerTolerance {
+		log.Printf("Wall clock has been set by %s\n", jump.Truncate(time.Second))
+	}
+	ct.lastClockCheck, ct.lastMonotonic = now, monotonic
+
+	triggerTime, brew := ct.nextTrigger, ct.nextBrew
+	if now.Sub(triggerTime) > missedTriggerTolerance && ct.cancellableTimer.Stop() {
+		// the trigger is overdue, handle it as if the timer had fired
+		ct.mu.Unlock()
+		ct.trigger(triggerTime, brew)
+		return
+	}
+
+	if (jump > missedTriggerTolerance || jump < -missedTriggerTolerance) && ct.cancellableTimer.Stop() {
+		// work out the time left until the trigger on the new wall clock
+		ct.cancellableTimer = ct.clock.AfterFunc(triggerTime.Sub(now), func() { ct.trigger(triggerTime, brew) })
+	}
+	ct.clockCheck = ct.clock.AfterFunc(clockCheckInterval, ct.checkClock)
+	ct.mu.Unlock()
+}
@@ -0,0 +1,157 @@
+package coffee
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMissedTriggerPolicyAfterClockJump(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		cfg        MissedTriggerConfig
+		jump       time.Duration
+		wantBrewed bool
+		wantMissed int
+	}{
+		{"brew if only a little late", MissedTriggerConfig{Policy: MissedTriggerBrew, MaxLateMinutes: 15}, 10 * time.Minute, true, 0},
+		{"skip if too late", MissedTriggerConfig{Policy: MissedTriggerBrew, MaxLateMinutes: 15}, 2 * time.Hour, false, 0},
+		{"skip", MissedTriggerConfig{Policy: MissedTriggerSkip}, 10 * time.Minute, false, 0},
+		{"notify", MissedTriggerConfig{Policy: MissedTriggerNotify}, 10 * time.Minute, false, 1},
+	}
+
+	for _, tt := range tests {
+		clock := newFakeClock(testStart)
+		ct := NewCoffeeTimer(CoffeeTimerConfig{TriggerTime: "6:45", Brew: BrewLungo}, newTestRaspi(), clock)
+		ct.SetMissedTriggerConfig(tt.cfg)
+
+		brewed := false
+		ct.SetBrewFunc(func(brew string) { brewed = true })
+		ct.Arm()
+
+		// NTP sets the clock past the trigger time just before it is due
+		clock.Advance(40 * time.Minute)
+		clock.Jump(tt.jump)
+		clock.Advance(time.Minute)
+
+		if brewed != tt.wantBrewed {
+			t.Errorf("%s: expected brewed %t, got %t", tt.name, tt.wantBrewed, brewed)
+		}
+		if missed := ct.MissedTriggers(); len(missed) != tt.wantMissed {
+			t.Errorf("%s: expected %d missed coffees notified, got %d", tt.name, tt.wantMissed, len(missed))
+		}
+		triggerTime, _, ok := ct.NextTrigger()
+		if want := time.Date(2023, time.January, 3, 6, 45, 0, 0, time.Local); !ok || !triggerTime.Equal(want) {
+			t.Errorf("%s: expected the timer to be re-armed for %s, got %s", tt.name, want, triggerTime)
+		}
+		ct.Disarm()
+	}
+
+}
+
+func TestTriggerWaitsAfterClockHasBeenPutBack(t *testing.T) {
+
+	clock := newFakeClock(testStart)
+	ct := NewCoffeeTimer(CoffeeTimerConfig{TriggerTime: "6:45", Brew: BrewLungo}, newTestRaspi(), clock)
+
+	var brewedAt []time.Time
+	ct.SetBrewFunc(func(brew string) { brewedAt = append(brewedAt, clock.Now()) })
+	ct.Arm()
+	defer ct.Disarm()
+
+	// the timer now fires at 6:25 on the wall clock, which is too early
+	clock.Advance(30 * time.Minute)
+	clock.Jump(-20 * time.Minute)
+	clock.Advance(30 * time.Minute)
+	if len(brewedAt) != 0 {
+		t.Fatalf("coffee made too early at %s", brewedAt[0])
+	}
+
+	clock.Advance(10 * time.Minute)
+	if len(brewedAt) != 1 || brewedAt[0].Format("15:04:05") != "06:45:00" {
+		t.Fatalf("expected coffee at 06:45:00, got %v", brewedAt)
+	}
+
+}
+
+func TestTriggerMovesWithClockSetForward(t *testing.T) {
+
+	clock := newFakeClock(testStart)
+	ct := NewCoffeeTimer(CoffeeTimerConfig{TriggerTime: "6:45", Brew: BrewLungo}, newTestRaspi(), clock)
+	ct.SetMissedTriggerConfig(MissedTriggerConfig{Policy: MissedTriggerNotify})
+
+	var brewedAt []time.Time
+	ct.SetBrewFunc(func(brew string) { brewedAt = append(brewedAt, clock.Now()) })
+	ct.Arm()
+	defer ct.Disarm()
+
+	// the timer now fires at 7:15 on the wall clock, the next clock check has to bring it forward to 6:45
+	clock.Jump(30 * time.Minute)
+	clock.Advance(clockCheckInterval)
+	clock.Advance(15 * time.Minute)
+	if len(brewedAt) != 1 || brewedAt[0].Format("15:04:05") != "06:45:00" {
+		t.Fatalf("expected coffee at 06:45:00, got %v", brewedAt)
+	}
+	if missed := ct.MissedTriggers(); len(missed) != 0 {
+		t.Errorf("expected the coffee to be made on time, got %d missed", len(missed))
+	}
+
+	// set past tomorrow's trigger, the next clock check makes the coffee late rather than the timer an hour later
+	ct.SetMissedTriggerConfig(MissedTriggerConfig{Policy: MissedTriggerBrew, MaxLateMinutes: 15})
+	clock.Advance(24*time.Hour - 16*time.Minute)
+	clock.Jump(20 * time.Minute)
+	clock.Advance(clockCheckInterval)
+	if len(brewedAt) != 2 || brewedAt[1].Format("15:04:05") != "06:51:00" {
+		t.Fatalf("expected coffee at 06:51:00, got %v", brewedAt)
+	}
+}
+
+func TestSchedulerCatchesUpAfterDowntime(t *testing.T) {
+
+	for _, policy := range []string{MissedTriggerBrew, MissedTriggerSkip} {
+		s := NewScheduler(newTestRaspi(), newFakeClock(testStart))
+		s.SetMissedTriggerConfig(MissedTriggerConfig{Policy: policy, MaxLateMinutes: 15})
+
+		brewed := ""
+		s.SetBrewFunc(func(brew string) { brewed = brew })
+
+		// the Pi went down shortly before the coffee was due at 5:50 and is back at 6:00
+		state := SchedulerState{Armed: true, Alarms: []AlarmState{{
+			AlarmConfig:    AlarmConfig{ID: "early", Enabled: true, CoffeeTimerConfig: CoffeeTimerConfig{TriggerTime: "5:50", Brew: BrewLungo}},
+			PendingTrigger: testStart.Add(-10 * time.Minute),
+			PendingBrew:    BrewLungo,
+		}}}
+		s.Restore(state)
+		s.Disarm()
+
+		if want := map[string]string{MissedTriggerBrew: BrewLungo, MissedTriggerSkip: ""}[policy]; brewed != want {
+			t.Errorf("policy %s: expected '%s' to be made after restart, got '%s'", policy, want, brewed)
+		}
+	}
+
+	// a coffee missed while the timer was disarmed, or the alarm disabled, would not have been made anyway
+	for _, state := range []SchedulerState{
+		{Armed: false, Alarms: []AlarmState{{
+			AlarmConfig:    AlarmConfig{ID: "early", Enabled: true, CoffeeTimerConfig: CoffeeTimerConfig{TriggerTime: "5:50", Brew: BrewLungo}},
+			PendingTrigger: testStart.Add(-10 * time.Minute),
+			PendingBrew:    BrewLungo,
+		}}},
+		{Armed: true, Alarms: []AlarmState{{
+			AlarmConfig:    AlarmConfig{ID: "early", Enabled: false, CoffeeTimerConfig: CoffeeTimerConfig{TriggerTime: "5:50", Brew: BrewLungo}},
+			PendingTrigger: testStart.Add(-10 * time.Minute),
+			PendingBrew:    BrewLungo,
+		}}},
+	} {
+		s := NewScheduler(newTestRaspi(), newFakeClock(testStart))
+		s.SetMissedTriggerConfig(MissedTriggerConfig{Policy: MissedTriggerBrew, MaxLateMinutes: 15})
+		brewed := ""
+		s.SetBrewFunc(func(brew string) { brewed = brew })
+
+		s.Restore(state)
+		s.Disarm()
+		if brewed != "" {
+			t.Errorf("expected no coffee to be made after restoring armed %t, enabled %t, got '%s'", state.Armed, state.Alarms[0].Enabled, brewed)
+		}
+	}
+
+}
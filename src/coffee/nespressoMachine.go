@@ -1,52 +1,23 @@
 package coffee
 
-import (
-	"time"
-)
+const MachineModelNespresso = "nespresso"
 
-type NespressoMachineConfig struct {
-	ButtonPressDurationMs int `yaml:"button_press_duration_ms"`
+// nespressoRecipes switch the machine on with the brew's button and press it again once it has heated up
+var nespressoRecipes = []RecipeConfig{
+	{Name: BrewEspresso, Steps: []RecipeStep{{WarmUp: "espresso"}, {Press: "espresso"}}, Capsule: BrewEspresso, WaterMl: 40},
+	{Name: BrewLungo, Steps: []RecipeStep{{WarmUp: "lungo"}, {Press: "lungo"}}, Capsule: BrewLungo, WaterMl: 110},
 }
 
-var NespressoMachineConfigDefaults = NespressoMachineConfig{
-	ButtonPressDurationMs: 300,
+func init() {
+	RegisterMachine(MachineModelNespresso, NewNespressoMachine)
 }
 
-type NespressoMachine struct {
-	raspi               raspberrypi
-	buttonPressLengthMs int
-}
-
-func NewNespressoMachine(cfg NespressoMachineConfig, raspi raspberrypi) NespressoMachine {
-	return NespressoMachine{raspi: raspi, buttonPressLengthMs: cfg.ButtonPressDurationMs}
-}
-
-func (n NespressoMachine) pressEspressoButton() {
-	n.raspi.ActivateEspressoButton(true)
-	time.Sleep(time.Duration(n.buttonPressLengthMs) * time.Millisecond)
-	n.raspi.ActivateEspressoButton(false)
-}
-
-func (n NespressoMachine) pressLungoButton() {
-	n.raspi.ActivateLungoButton(true)
-	time.Sleep(time.Duration(n.buttonPressLengthMs) * time.Millisecond)
-	n.raspi.ActivateLungoButton(false)
-}
-
-func (n NespressoMachine) MakeEspresso() {
-	// switch on machine
-	n.pressEspressoButton()
-	time.Sleep(time.Duration(n.buttonPressLengthMs) * time.Millisecond)
-
-	// make espresso
-	n.pressEspressoButton()
-}
-
-func (n NespressoMachine) MakeLungo() {
-	// switch on machine
-	n.pressLungoButton()
-	time.Sleep(time.Duration(n.buttonPressLengthMs) * time.Millisecond)
-
-	// make lungo
-	n.pressLungoButton()
+// NewNespressoMachine drives a machine of the Nespresso Original line. Its espresso and lungo buttons
+// switch it on when pressed while it is off, and make coffee when pressed while it is on.
+func NewNespressoMachine(cfg MachineConfig, raspi *raspberrypi) CoffeeMachine {
+	buttons := map[string]func(press bool){
+		"espresso": raspi.ActivateEspressoButton,
+		"lungo":    raspi.ActivateLungoButton,
+	}
+	return newButtonMachine(cfg, buttons, nespressoRecipes)
 }
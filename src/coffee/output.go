@@ -0,0 +1,97 @@
+package coffee
+
+import (
+	"fmt"
+	"log"
+
+	"periph.io/x/conn/v3/gpio"
+)
+
+const (
+	OutputIdleOff   = "off"   // driven to the level which switches the output off
+	OutputIdleFloat = "float" // not driven, for boards that hold their inputs off with pull resistors of their own
+)
+
+// OutputConfig sets how an output pin switches what it is wired to
+type OutputConfig struct {
+	// ActiveLow is set for outputs switched on by a Low level, like most relay boards
+	ActiveLow bool `yaml:"active_low"`
+	// Idle is the safe state the output is in while it is switched off, at startup and on exit: OutputIdleOff or OutputIdleFloat
+	Idle string `yaml:"idle"`
+}
+
+var RelayOutputDefaults = OutputConfig{ActiveLow: true, Idle: OutputIdleOff}
+
+var LedOutputDefaults = OutputConfig{ActiveLow: false, Idle: OutputIdleOff}
+
+var BuzzerOutputDefaults = OutputConfig{ActiveLow: false, Idle: OutputIdleOff}
+
+func (cfg OutputConfig) validate() error {
+	switch cfg.Idle {
+	case OutputIdleOff, OutputIdleFloat, "":
+		return nil
+	default:
+		return fmt.Errorf("unknown idle state '%s', expected %s or %s", cfg.Idle, OutputIdleOff, OutputIdleFloat)
+	}
+}
+
+// level returns the level which switches the output on or off
+func (cfg OutputConfig) level(on bool) gpio.Level {
+	return gpio.Level(on != cfg.ActiveLow)
+}
+
+// output is a pin switching a relay or an LED, with its polarity and idle state
+type output struct {
+	descr string
+	pin   gpio.PinIO
+	cfg   OutputConfig
+}
+
+// newOutput returns nil if the pin is not in use
+func newOutput(descr string, pin gpio.PinIO, cfg OutputConfig) *output {
+	if pin == nil {
+		return nil
+	}
+	return &output{descr: descr, pin: pin, cfg: cfg}
+}
+
+// set switches the output on, or puts it into its idle state
+func (o *output) set(on bool) {
+	if !on {
+		o.idle()
+		return
+	}
+	if err := o.pin.Out(o.cfg.level(true)); err != nil {
+		log.Println("Error switching on", o.descr, "GPIO", o.pin, ":", err)
+	}
+}
+
+// idle puts the output into its idle state
+func (o *output) idle() {
+
+	var err error
+	if o.cfg.Idle == OutputIdleFloat {
+		err = o.pin.In(gpio.Float, gpio.NoEdge)
+	} else {
+		err = o.pin.Out(o.cfg.level(false))
+	}
+	if err != nil {
+		log.Println("Error switching off", o.descr, "GPIO", o.pin, ":", err)
+	}
+}
+
+func (o *output) String() string {
+	return fmt.Sprintf("%s GPIO %s (%s)", o.descr, o.pin, o.cfg)
+}
+
+func (cfg OutputConfig) String() string {
+	polarity := "active high"
+	if cfg.ActiveLow {
+		polarity = "active low"
+	}
+	idle := cfg.Idle
+	if idle == "" {
+		idle = OutputIdleOff
+	}
+	return polarity + ", idle " + idle
+}
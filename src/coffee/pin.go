@@ -0,0 +1,69 @@
+package coffee
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Pin is a GPIO given as its BCM number like 17, its name like "GPIO17", or its position on the Raspberry Pi's header like "P1_11".
+// PinUnused or "" leave it unused.
+type Pin string
+
+const PinUnused Pin = "-1"
+
+// maxBCM is the highest GPIO on the header
+const maxBCM = 27
+
+// headerPins are the BCM numbers of the GPIOs on the 40 pin header by their position, -1 for power and ground
+var headerPins = [...]int{-1, // there is no P1_0
+	-1, -1, 2, -1, 3, -1, 4, 14, -1, 15,
+	17, 18, 27, -1, 22, 23, -1, 24, 10, -1,
+	9, 25, 11, 8, -1, 7, 0, 1, 5, -1,
+	6, 12, 13, -1, 19, 16, 26, 20, -1, 21,
+}
+
+// Unused tells whether the pin is left unused
+func (p Pin) Unused() bool {
+	s := strings.TrimSpace(string(p))
+	return s == "" || s == string(PinUnused)
+}
+
+// BCM returns the BCM number of the GPIO
+func (p Pin) BCM() (int, error) {
+
+	s := strings.ToUpper(strings.TrimSpace(string(p)))
+	if strings.HasPrefix(s, "P1_") {
+		pos, err := strconv.Atoi(s[len("P1_"):])
+		if err != nil || pos < 1 || pos >= len(headerPins) {
+			return 0, fmt.Errorf("no position %s on the 40 pin header, expected P1_1 to P1_40", s)
+		}
+		if headerPins[pos] < 0 {
+			return 0, fmt.Errorf("%s is a power or ground pin, not a GPIO", s)
+		}
+		return headerPins[pos], nil
+	}
+
+	n, err := strconv.Atoi(strings.TrimPrefix(s, "GPIO"))
+	if err != nil {
+		return 0, fmt.Errorf("unexpected pin '%s', expected a BCM number like 17, a name like GPIO17 or a header position like P1_11", p)
+	}
+	if n < 0 || n > maxBCM {
+		return 0, fmt.Errorf("no GPIO%d on the header, expected 0 to %d", n, maxBCM)
+	}
+	return n, nil
+}
+
+// the buses of the Raspberry Pi that take some of the GPIOs while they are enabled
+const (
+	BusI2C  = "i2c"
+	BusSPI  = "spi"
+	BusUART = "uart"
+)
+
+// busPins are the GPIOs each bus takes, with their signals
+var busPins = map[string]map[int]string{
+	BusI2C:  {2: "SDA", 3: "SCL"},
+	BusSPI:  {7: "CE1", 8: "CE0", 9: "MISO", 10: "MOSI", 11: "SCLK"},
+	BusUART: {14: "TXD", 15: "RXD"},
+}
@@ -0,0 +1,85 @@
+package coffee
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPinBCM(t *testing.T) {
+
+	tests := []struct {
+		pin      Pin
+		expected int
+		valid    bool
+	}{
+		{"17", 17, true},
+		{"GPIO17", 17, true},
+		{"gpio4", 4, true},
+		{"P1_11", 17, true},
+		{"P1_40", 21, true},
+		{"p1_3", 2, true},
+		{"0", 0, true},
+		{"28", 0, false},
+		{"-2", 0, false},
+		{"GPIO", 0, false},
+		{"P1_1", 0, false}, // 3.3V
+		{"P1_41", 0, false},
+		{"espresso", 0, false},
+	}
+
+	for _, test := range tests {
+		n, err := test.pin.BCM()
+		if test.valid && (err != nil || n != test.expected) {
+			t.Errorf("expected %s to be GPIO%d, got %d, %v", test.pin, test.expected, n, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("expected %s to be invalid, got GPIO%d", test.pin, n)
+		}
+	}
+
+	for _, p := range []Pin{PinUnused, "", " -1 "} {
+		if !p.Unused() {
+			t.Errorf("expected '%s' to leave the pin unused", p)
+		}
+	}
+}
+
+func TestRaspiConfigValidate(t *testing.T) {
+
+	if err := RaspiConfigDefaults.Validate(); err != nil {
+		t.Errorf("expected the defaults to be valid, got %v", err)
+	}
+
+	cfg := RaspiConfigDefaults
+	cfg.LungoButtonPin = "GPIO27"  // the espresso button's
+	cfg.PowerButtonPin = "P1_6"    // ground
+	cfg.CupSensorPin = "P1_3"      // SDA
+	cfg.BuzzerPin = "10"           // MOSI
+	cfg.ArmedLedOutput.Idle = "on" // not an idle state
+	cfg.DisplayDriver = DisplayHD44780
+	cfg.EnabledBuses = []string{BusSPI, "can"}
+
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("expected the config to be invalid")
+	}
+	for _, expected := range []string{
+		"unknown bus 'can'",
+		"Lungo button pin GPIO27: GPIO27 is the Espresso button pin already",
+		"Power button pin P1_6: P1_6 is a power or ground pin",
+		"Cup sensor pin P1_3: GPIO2 is SDA of the I2C bus",
+		"Buzzer pin 10: GPIO10 is MOSI of the SPI bus",
+		"Armed LED: unknown idle state 'on'",
+	} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected the problems to include %q, got %v", expected, err)
+		}
+	}
+
+	// a bus that isn't enabled leaves its pins free
+	cfg = RaspiConfigDefaults
+	cfg.CupSensorPin = "2"
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("expected GPIO2 to be free without I2C, got %v", err)
+	}
+}
@@ -3,225 +3,458 @@ package coffee
 import (
 	"fmt"
 	"log"
+	"strings"
+	"sync"
 	"time"
 
 	"periph.io/x/conn/v3/gpio"
-	"periph.io/x/conn/v3/gpio/gpioreg"
-	"periph.io/x/host/v3"
 )
 
+// RaspiConfig sets up the Raspberry Pi's pins. The pins are given as BCM numbers like 17, names like "GPIO17" or header positions like "P1_11",
+// see Pin, and -1 leaves them unused.
 type RaspiConfig struct {
-	EspressoButtonPin              int `yaml:"espresso_button_pin"`
-	LungoButtonPin                 int `yaml:"lungo_button_pin"`
-	ArmedLedPin                    int `yaml:"armed_led_pin"`
-	DisarmedLedPin                 int `yaml:"disarmed_led_pin"`
-	ArmButtonPin                   int `yaml:"arm_button_pin"`
-	CheckStatusButtonPin           int `yaml:"check_status_button_pin"`
-	ButtonPressDetectingDurationMs int `yaml:"button_press_detecting_duration_ms"`
+	EspressoButtonPin    Pin `yaml:"espresso_button_pin"`
+	LungoButtonPin       Pin `yaml:"lungo_button_pin"`
+	PowerButtonPin       Pin `yaml:"power_button_pin"` // power button of machines that have one, or a filter machine's relay
+	ArmedLedPin          Pin `yaml:"armed_led_pin"`
+	DisarmedLedPin       Pin `yaml:"disarmed_led_pin"`
+	ArmButtonPin         Pin `yaml:"arm_button_pin"`
+	CheckStatusButtonPin Pin `yaml:"check_status_button_pin"`
+	// DebounceMs is how long the level of an input button has to be stable for a press or a release to count
+	DebounceMs int `yaml:"debounce_ms"`
+	// DoublePressMs is how long after a press a second one makes it a double press. Buttons without a double press action don't wait.
+	DoublePressMs int `yaml:"double_press_ms"`
+	// holding the arm button at least this long makes a long press, 0 makes every press a short one
+	ArmButtonLongPressMs int `yaml:"arm_button_long_press_ms"`
+	// holding the check status button at least this long makes a long press, 0 makes every press a short one
+	CheckStatusButtonLongPressMs int `yaml:"check_status_button_long_press_ms"`
+	// the actions of the input buttons' gestures
+	ArmButton         ButtonGestures `yaml:"arm_button"`
+	CheckStatusButton ButtonGestures `yaml:"check_status_button"`
+	// BrewNow is the recipe the brew now action makes, the next coffee's if empty
+	BrewNow string `yaml:"brew_now"`
+	// CupSensorPin reads a microswitch, IR break-beam or reed contact telling whether a cup is in place, -1 if there is none
+	CupSensorPin Pin `yaml:"cup_sensor_pin"`
+	// CupSensorActiveLow is set for a sensor that pulls the pin to ground while a cup is in place
+	CupSensorActiveLow bool `yaml:"cup_sensor_active_low"`
+	// how the relays and LEDs are switched, and their safe state while they are off
+	EspressoButtonOutput OutputConfig `yaml:"espresso_button_output"`
+	LungoButtonOutput    OutputConfig `yaml:"lungo_button_output"`
+	PowerButtonOutput    OutputConfig `yaml:"power_button_output"`
+	ArmedLedOutput       OutputConfig `yaml:"armed_led_output"`
+	DisarmedLedOutput    OutputConfig `yaml:"disarmed_led_output"`
+	// BuzzerPin drives a piezo buzzer playing tunes on arming, disarming, brewing and errors, -1 if there is none
+	BuzzerPin        Pin          `yaml:"buzzer_pin"`
+	BuzzerOutput     OutputConfig `yaml:"buzzer_output"`
+	BuzzerQuietHours QuietHours   `yaml:"buzzer_quiet_hours"`
+	// DisplayDriver is the display on the I2C bus showing the status, DisplayHD44780 or DisplaySSD1306, none if empty
+	DisplayDriver string `yaml:"display_driver"`
+	DisplayI2CBus string `yaml:"display_i2c_bus"`
+	// DisplayAddress is the display's I2C address, 0 for the usual one of the driver, 0x27 for the HD44780 and 0x3c for the SSD1306
+	DisplayAddress int `yaml:"display_address"`
+	// DisplayColumns and DisplayRows are the size of an HD44780, e.g. 16x2 or 20x4
+	DisplayColumns int `yaml:"display_columns"`
+	DisplayRows    int `yaml:"display_rows"`
+	// EnabledBuses are the buses enabled with raspi-config, BusI2C, BusSPI or BusUART, whose pins can't be used for anything else.
+	// The I2C bus counts as enabled while there is a display.
+	EnabledBuses []string `yaml:"enabled_buses"`
+	// Backend is the hardware the pins are driven through, HardwarePeriph on a Raspberry Pi, or HardwareSimulator to try coffee pixie without one
+	Backend string `yaml:"backend"`
 }
 
 var RaspiConfigDefaults = RaspiConfig{
-	EspressoButtonPin:              27,
-	LungoButtonPin:                 22,
-	ArmedLedPin:                    17,
-	DisarmedLedPin:                 4,
-	ArmButtonPin:                   24,
-	CheckStatusButtonPin:           23,
-	ButtonPressDetectingDurationMs: 300,
-}
-
-var RaspiConfigNoInputButtons = RaspiConfig{
-	EspressoButtonPin:              27,
-	LungoButtonPin:                 22,
-	ArmedLedPin:                    17,
-	DisarmedLedPin:                 4,
-	ArmButtonPin:                   -1,
-	CheckStatusButtonPin:           -1,
-	ButtonPressDetectingDurationMs: 300,
+	EspressoButtonPin:            "27",
+	LungoButtonPin:               "22",
+	PowerButtonPin:               PinUnused,
+	ArmedLedPin:                  "17",
+	DisarmedLedPin:               "4",
+	ArmButtonPin:                 "24",
+	CheckStatusButtonPin:         "23",
+	DebounceMs:                   30,
+	DoublePressMs:                400,
+	ArmButtonLongPressMs:         1500,
+	CheckStatusButtonLongPressMs: 3000,
+	ArmButton:                    ButtonGestures{ShortPress: ActionToggleArm, LongPress: ActionSnooze, DoublePress: ActionBrewNow},
+	CheckStatusButton:            ButtonGestures{ShortPress: ActionShowStatus, LongPress: ActionResetMaintenance},
+	CupSensorPin:                 PinUnused,
+	EspressoButtonOutput:         RelayOutputDefaults,
+	LungoButtonOutput:            RelayOutputDefaults,
+	PowerButtonOutput:            RelayOutputDefaults,
+	ArmedLedOutput:               LedOutputDefaults,
+	DisarmedLedOutput:            LedOutputDefaults,
+	BuzzerPin:                    PinUnused,
+	BuzzerOutput:                 BuzzerOutputDefaults,
+	BuzzerQuietHours:             QuietHours{},
+	DisplayDriver:                "",
+	DisplayI2CBus:                "",
+	DisplayAddress:               0,
+	DisplayColumns:               16,
+	DisplayRows:                  2,
+	Backend:                      HardwarePeriph,
 }
 
 var NoRaspiInUseConfig = RaspiConfig{
-	EspressoButtonPin:              -1,
-	LungoButtonPin:                 -1,
-	ArmedLedPin:                    -1,
-	DisarmedLedPin:                 -1,
-	ArmButtonPin:                   -1,
-	CheckStatusButtonPin:           -1,
-	ButtonPressDetectingDurationMs: 0,
+	EspressoButtonPin:            PinUnused,
+	LungoButtonPin:               PinUnused,
+	PowerButtonPin:               PinUnused,
+	ArmedLedPin:                  PinUnused,
+	DisarmedLedPin:               PinUnused,
+	ArmButtonPin:                 PinUnused,
+	CheckStatusButtonPin:         PinUnused,
+	DebounceMs:                   0,
+	DoublePressMs:                0,
+	ArmButtonLongPressMs:         0,
+	CheckStatusButtonLongPressMs: 0,
+	CupSensorPin:                 PinUnused,
+	EspressoButtonOutput:         RelayOutputDefaults,
+	LungoButtonOutput:            RelayOutputDefaults,
+	PowerButtonOutput:            RelayOutputDefaults,
+	ArmedLedOutput:               LedOutputDefaults,
+	DisarmedLedOutput:            LedOutputDefaults,
+	BuzzerPin:                    PinUnused,
+	BuzzerOutput:                 BuzzerOutputDefaults,
+	BuzzerQuietHours:             QuietHours{},
+	DisplayDriver:                "",
+	DisplayI2CBus:                "",
+	DisplayAddress:               0,
+	DisplayColumns:               16,
+	DisplayRows:                  2,
+	Backend:                      HardwareSimulator,
 }
 
 type raspberrypi struct {
-	espressoButtonGpio, lungoButtonGpio                                 gpio.PinIO
-	armedLedGpio, disarmedLedGpio, armButtonGpio, checkStatusButtonGpio gpio.PinIO
-	showArmedStatusFunc, toggleArmedStatusFunc                          func()
-}
-
-func NewRaspi(cfg RaspiConfig) raspberrypi {
-
-	// Load all the drivers:
-	if _, err := host.Init(); err != nil {
-		fmt.Print("error init")
-		log.Fatal(err)
-	}
-
-	espressoButtonGpio := getGPIOByNumber(cfg.EspressoButtonPin)
-	lungoButtonGpio := getGPIOByNumber(cfg.LungoButtonPin)
-	armedLedGpio := getGPIOByNumber(cfg.ArmedLedPin)
-	disarmedLedGpio := getGPIOByNumber(cfg.DisarmedLedPin)
-	armButtonGpio := getGPIOByNumber(cfg.ArmButtonPin)
-	checkStatusButtonGpio := getGPIOByNumber(cfg.CheckStatusButtonPin)
-
-	logGPIOFunction("Espresso button", espressoButtonGpio)
-	logGPIOFunction("Lungo button", lungoButtonGpio)
-	logGPIOFunction("Check Status button", checkStatusButtonGpio)
-	logGPIOFunction("Arm Timer buttin", armButtonGpio)
-	logGPIOFunction("Armed LED", armedLedGpio)
-	logGPIOFunction("Diarmed LED", disarmedLedGpio)
-
-	rp := raspberrypi{espressoButtonGpio: espressoButtonGpio, lungoButtonGpio: lungoButtonGpio, armedLedGpio: armedLedGpio, disarmedLedGpio: disarmedLedGpio, armButtonGpio: armButtonGpio, checkStatusButtonGpio: checkStatusButtonGpio}
-	rp.SetShowArmedStatusFunc(func() {})
-	rp.SetToggleArmedStatusFunc(func() {})
-
-	// If configured, set button as input, with an internal pull down resistor, and start monitoring
-	if checkStatusButtonGpio != nil {
-		if err := checkStatusButtonGpio.In(gpio.PullDown, gpio.RisingEdge); err != nil {
-			log.Fatal(err)
-		}
-
-		go func() {
-			// Wait for edges as detected by the hardware, and print the value read:
-			for {
-				commenceWaiting := time.Now()
-
-				// for some reason, the dge detection did not work unless we Read() the GPIO value
-				checkStatusButtonGpio.Read()
-				checkStatusButtonGpio.WaitForEdge(-1)
-				if time.Since(commenceWaiting) > time.Duration(cfg.ButtonPressDetectingDurationMs)*time.Millisecond {
-					// TODO: test that this actually calls the new function if the function has been set from outside this NewRaspi function
-					rp.showArmedStatusFunc()
-				}
-
-				// for good measure, Read() again afterwards to be on the safe side..
-				checkStatusButtonGpio.Read()
-			}
-		}()
+	espressoButton, lungoButton, powerButton *output
+	armButtonGpio, checkStatusButtonGpio     gpio.PinIO
+	cupSensorGpio                            gpio.PinIO
+	cupSensorActiveLow                       bool
+	actionFuncs                              *sync.Map // the func of each button action, by name
+	armedLed, disarmedLed                    *ledDriver
+	buzzer                                   *buzzer
+	display                                  Display
+}
+
+// configPin is a pin of the config, with how it is switched if it is an output
+type configPin struct {
+	descr  string
+	pin    Pin
+	output *OutputConfig
+}
+
+func (cfg *RaspiConfig) pins() []configPin {
+	return []configPin{
+		{"Espresso button", cfg.EspressoButtonPin, &cfg.EspressoButtonOutput},
+		{"Lungo button", cfg.LungoButtonPin, &cfg.LungoButtonOutput},
+		{"Power button", cfg.PowerButtonPin, &cfg.PowerButtonOutput},
+		{"Check Status button", cfg.CheckStatusButtonPin, nil},
+		{"Arm Timer button", cfg.ArmButtonPin, nil},
+		{"Armed LED", cfg.ArmedLedPin, &cfg.ArmedLedOutput},
+		{"Disarmed LED", cfg.DisarmedLedPin, &cfg.DisarmedLedOutput},
+		{"Cup sensor", cfg.CupSensorPin, nil},
+		{"Buzzer", cfg.BuzzerPin, &cfg.BuzzerOutput},
 	}
+}
 
-	// If configured, set button as input, with an internal pull down resistor, and start monitoring
-	if armButtonGpio != nil {
-		if err := armButtonGpio.In(gpio.PullDown, gpio.RisingEdge); err != nil {
-			log.Fatal(err)
-		}
+// Validate checks the pins for invalid ones, ones used twice and ones taken by an enabled bus, as well as the outputs and button gestures.
+// It reports all problems found together, rather than only the first.
+func (cfg RaspiConfig) Validate() error {
 
-		go func() {
-			// Wait for edges as detected by the hardware, and print the value read:
-			for {
-				commenceWaiting := time.Now()
+	var problems []string
+
+	buses := cfg.EnabledBuses
+	if cfg.DisplayDriver != "" {
+		buses = append([]string{BusI2C}, buses...)
+	}
+	reserved := map[int]string{}
+	for _, bus := range buses {
+		pins, ok := busPins[bus]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("unknown bus '%s', expected %s, %s or %s", bus, BusI2C, BusSPI, BusUART))
+			continue
+		}
+		for n, signal := range pins {
+			reserved[n] = fmt.Sprintf("%s of the %s bus", signal, strings.ToUpper(bus))
+		}
+	}
 
-				armButtonGpio.Read()
-				armButtonGpio.WaitForEdge(-1)
-				if time.Since(commenceWaiting) > time.Duration(cfg.ButtonPressDetectingDurationMs)*time.Millisecond {
-					rp.toggleArmedStatusFunc()
-				}
-				armButtonGpio.Read()
+	used := map[int]string{}
+	for _, p := range cfg.pins() {
+		if p.pin.Unused() {
+			continue
+		}
+		n, err := p.pin.BCM()
+		switch {
+		case err != nil:
+			problems = append(problems, fmt.Sprintf("%s pin %s: %v", p.descr, p.pin, err))
+		case used[n] != "":
+			problems = append(problems, fmt.Sprintf("%s pin %s: GPIO%d is the %s pin already", p.descr, p.pin, n, used[n]))
+		case reserved[n] != "":
+			problems = append(problems, fmt.Sprintf("%s pin %s: GPIO%d is %s, which is enabled", p.descr, p.pin, n, reserved[n]))
+		}
+		if err == nil && used[n] == "" {
+			used[n] = p.descr
+		}
+		if p.output != nil {
+			if err := p.output.validate(); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %v", p.descr, err))
 			}
-		}()
+		}
 	}
 
-	return rp
+	if err := cfg.CheckStatusButton.validate(); err != nil {
+		problems = append(problems, fmt.Sprintf("Check Status button: %v", err))
+	}
+	if err := cfg.ArmButton.validate(); err != nil {
+		problems = append(problems, fmt.Sprintf("Arm Timer button: %v", err))
+	}
+	if !cfg.BuzzerPin.Unused() {
+		if err := cfg.BuzzerQuietHours.validate(); err != nil {
+			problems = append(problems, fmt.Sprintf("Buzzer: %v", err))
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid Raspberry Pi config:\n\t%s", strings.Join(problems, "\n\t"))
+	}
+	return nil
 }
 
-func (r raspberrypi) ActivateEspressoButton(press bool) {
+// NewRaspi sets up the configured pins of the given hardware backend, unused pins are left alone.
+// It checks the config first, see RaspiConfig.Validate.
+func NewRaspi(cfg RaspiConfig, hw Hardware) (*raspberrypi, error) {
 
-	if r.espressoButtonGpio == nil {
-		log.Println("Espresso button not configured for use, skipping setting to activate == ", press)
+	if err := cfg.Validate(); err != nil {
+		return nil, err
 	}
 
-	if press {
-		log.Println("Pressing Espresso button")
+	var espressoButtonGpio, lungoButtonGpio, powerButtonGpio, armedLedGpio, disarmedLedGpio, armButtonGpio, checkStatusButtonGpio, cupSensorGpio, buzzerGpio gpio.PinIO
+	for _, p := range []struct {
+		descr string
+		pin   Pin
+		g     *gpio.PinIO
+	}{
+		{"Espresso button", cfg.EspressoButtonPin, &espressoButtonGpio},
+		{"Lungo button", cfg.LungoButtonPin, &lungoButtonGpio},
+		{"Power button", cfg.PowerButtonPin, &powerButtonGpio},
+		{"Check Status button", cfg.CheckStatusButtonPin, &checkStatusButtonGpio},
+		{"Arm Timer button", cfg.ArmButtonPin, &armButtonGpio},
+		{"Armed LED", cfg.ArmedLedPin, &armedLedGpio},
+		{"Disarmed LED", cfg.DisarmedLedPin, &disarmedLedGpio},
+		{"Cup sensor", cfg.CupSensorPin, &cupSensorGpio},
+		{"Buzzer", cfg.BuzzerPin, &buzzerGpio},
+	} {
+		if !p.pin.Unused() {
+			n, err := p.pin.BCM()
+			if err != nil {
+				return nil, fmt.Errorf("%s pin %s: %w", p.descr, p.pin, err)
+			}
+			g, err := hw.Pin(n)
+			if err != nil {
+				return nil, fmt.Errorf("%s pin %s: %w", p.descr, p.pin, err)
+			}
+			*p.g = g
+		}
+		logGPIOFunction(p.descr, *p.g)
+	}
 
-		// Set the pin as output Low, as the relay is holding the button open when the pin is High
-		if err := r.espressoButtonGpio.Out(gpio.Low); err != nil {
-			log.Println("Error setting GPIO", r.espressoButtonGpio, ":", err)
+	rp := &raspberrypi{armButtonGpio: armButtonGpio, checkStatusButtonGpio: checkStatusButtonGpio,
+		cupSensorGpio: cupSensorGpio, cupSensorActiveLow: cfg.CupSensorActiveLow, actionFuncs: &sync.Map{}}
+
+	// before anything else, the outputs go into their idle state, so no relay is switched on while starting up
+	rp.espressoButton = newOutput("Espresso button", espressoButtonGpio, cfg.EspressoButtonOutput)
+	rp.lungoButton = newOutput("Lungo button", lungoButtonGpio, cfg.LungoButtonOutput)
+	rp.powerButton = newOutput("Power button", powerButtonGpio, cfg.PowerButtonOutput)
+	armedLed := newOutput("Armed LED", armedLedGpio, cfg.ArmedLedOutput)
+	disarmedLed := newOutput("Disarmed LED", disarmedLedGpio, cfg.DisarmedLedOutput)
+	buzzer := newOutput("Buzzer", buzzerGpio, cfg.BuzzerOutput)
+	for _, o := range []*output{rp.espressoButton, rp.lungoButton, rp.powerButton, armedLed, disarmedLed, buzzer} {
+		if o != nil {
+			log.Println("Switching off", o)
+			o.idle()
 		}
-	} else {
-		log.Println("Releasing Espresso button")
+	}
+	if armedLed != nil {
+		rp.armedLed = newLedDriver(armedLed)
+	}
+	if disarmedLed != nil {
+		rp.disarmedLed = newLedDriver(disarmedLed)
+	}
+	if buzzer != nil {
+		rp.buzzer = newBuzzer(buzzer, cfg.BuzzerQuietHours)
+	}
 
-		// Set the pin as output High
-		if err := r.espressoButtonGpio.Out(gpio.High); err != nil {
-			log.Println("Error setting GPIO", r.espressoButtonGpio, ":", err)
+	// without the display, coffee is still made, only the status is not shown
+	if cfg.DisplayDriver != "" {
+		if bus, err := hw.I2C(cfg.DisplayI2CBus); err != nil {
+			log.Println("Not showing the status on a display:", err)
+		} else if rp.display, err = openDisplay(cfg, bus); err != nil {
+			log.Println("Not showing the status on a display:", err)
 		}
 	}
+
+	// If configured, set the buttons as inputs, with an internal pull down resistor, and start recognising their gestures
+	for _, b := range []struct {
+		descr       string
+		pin         Pin
+		g           gpio.PinIO
+		longPressMs int
+		gestures    ButtonGestures
+	}{
+		{"Check Status button", cfg.CheckStatusButtonPin, checkStatusButtonGpio, cfg.CheckStatusButtonLongPressMs, cfg.CheckStatusButton},
+		{"Arm Timer button", cfg.ArmButtonPin, armButtonGpio, cfg.ArmButtonLongPressMs, cfg.ArmButton},
+	} {
+		if b.g == nil {
+			continue
+		}
+		if err := b.g.In(gpio.PullDown, gpio.RisingEdge); err != nil {
+			return nil, fmt.Errorf("%s pin %s: %w", b.descr, b.pin, err)
+		}
+
+		// without an action for a gesture, it is not waited for, e.g. a press held for long still counts as a short one
+		d := gestureDetector{pin: b.g, debounce: time.Duration(cfg.DebounceMs) * time.Millisecond}
+		if b.gestures.action(GestureLongPress) != ActionNone {
+			d.longPress = time.Duration(b.longPressMs) * time.Millisecond
+		}
+		if b.gestures.action(GestureDoublePress) != ActionNone {
+			d.doublePress = time.Duration(cfg.DoublePressMs) * time.Millisecond
+		}
+		descr, gestures := b.descr, b.gestures
+		go d.run(func(gesture string) { rp.runAction(descr, gesture, gestures.action(gesture)) })
+	}
+
+	// If configured, set the cup sensor as input, pulled to the level it shows while there is no cup
+	if cupSensorGpio != nil {
+		pull := gpio.PullDown
+		if cfg.CupSensorActiveLow {
+			pull = gpio.PullUp
+		}
+		if err := cupSensorGpio.In(pull, gpio.NoEdge); err != nil {
+			return nil, fmt.Errorf("Cup sensor pin %s: %w", cfg.CupSensorPin, err)
+		}
+	}
+
+	return rp, nil
+}
+
+// Display returns the display configured to show the status, nil if there is none
+func (r raspberrypi) Display() Display {
+	return r.display
+}
+
+// HasCupSensor tells whether a cup sensor has been configured
+func (r raspberrypi) HasCupSensor() bool {
+	return r.cupSensorGpio != nil
+}
+
+// CupPresent reads the cup sensor, it reports a cup in place if there is no sensor
+func (r raspberrypi) CupPresent() bool {
+	if r.cupSensorGpio == nil {
+		return true
+	}
+	return (r.cupSensorGpio.Read() == gpio.Low) == r.cupSensorActiveLow
+}
+
+// SignalHeldBack blinks the disarmed LED for a few seconds, when a brew is held back, e.g. as there is no cup in place
+func (r raspberrypi) SignalHeldBack(reason string) {
+
+	if r.disarmedLed == nil {
+		log.Println("Disarmed LED not configured for use, not signalling brew held back:", reason)
+		return
+	}
+
+	// overrides the armed status shown on the LED meanwhile
+	r.disarmedLed.Play(LedBlink, LedPriorityWarning, 4*time.Second)
+}
+
+// PlayTune plays the tune on the buzzer in the background, if there is one
+func (r raspberrypi) PlayTune(t Tune) {
+	r.buzzer.Play(t)
+}
+
+func (r raspberrypi) ActivateEspressoButton(press bool) {
+	r.activate(r.espressoButton, "Espresso", press)
 }
 
 func (r raspberrypi) ActivateLungoButton(press bool) {
+	r.activate(r.lungoButton, "Lungo", press)
+}
 
-	if r.lungoButtonGpio == nil {
-		log.Println("Lungo button not configured for use, skipping setting to activate == ", press)
+func (r raspberrypi) ActivatePowerButton(press bool) {
+	r.activate(r.powerButton, "Power", press)
+}
+
+// activate switches the relay of a button on to press it, or back to its idle state to release it
+func (r raspberrypi) activate(relay *output, name string, press bool) {
+
+	if relay == nil {
+		log.Println(name, "button not configured for use, skipping setting to activate == ", press)
+		return
 	}
 
 	if press {
-		log.Println("Pressing Lungo button")
-
-		// Set the pin as output Low, as the relay is holding the button open when the pin is High
-		if err := r.lungoButtonGpio.Out(gpio.Low); err != nil {
-			log.Println("Error setting GPIO", r.espressoButtonGpio, " to Low:", err)
-		}
+		log.Printf("Pressing %s button\n", name)
 	} else {
-		log.Println("Releasing Lungo button")
-
-		// Set the pin as output High
-		if err := r.lungoButtonGpio.Out(gpio.High); err != nil {
-			log.Println("Error setting GPIO", r.espressoButtonGpio, " to High:", err)
-		}
+		log.Printf("Releasing %s button\n", name)
 	}
+	relay.set(press)
 }
 
+// ActivateArmedStatusLED lights the LED of the armed status for activateForMs. It returns at once, the LED is switched off in the background.
 func (r raspberrypi) ActivateArmedStatusLED(isArmed bool, activateForMs int, logTriggerTime string) {
 
-	var statusGpio gpio.PinIO
+	statusLed, otherLed := r.disarmedLed, r.armedLed
 	if isArmed {
-		statusGpio = r.armedLedGpio
+		statusLed, otherLed = r.armedLed, r.disarmedLed
 		log.Printf("CoffeeTimer Status: ARMED for %s\n", logTriggerTime)
 	} else {
-		statusGpio = r.disarmedLedGpio
 		log.Println("CoffeeTimer Status: disarmed")
 	}
 
-	if statusGpio == nil {
+	otherLed.Stop(LedPriorityStatus)
+	if statusLed == nil {
 		log.Println("LED for status isArmed ==", isArmed, "is not configured for use, skipping activation")
+		return
 	}
-
-	if err := statusGpio.Out(gpio.High); err != nil {
-		log.Println("Error setting GPIO", statusGpio, " to High:", err)
-	}
-
-	time.Sleep(time.Duration(activateForMs) * time.Millisecond)
-
-	if err := statusGpio.Out(gpio.Low); err != nil {
-		log.Println("Error setting GPIO", statusGpio, " to Low:", err)
-	}
+	statusLed.Play(LedSolid, LedPriorityStatus, time.Duration(activateForMs)*time.Millisecond)
 }
 
-func (r *raspberrypi) SetShowArmedStatusFunc(f func()) {
-	r.showArmedStatusFunc = f
+// SetActionFunc sets the func run by the button gestures mapped to the action, one of the Action constants
+func (r *raspberrypi) SetActionFunc(action string, f func()) {
+	r.actionFuncs.Store(action, f)
 }
 
-func (r *raspberrypi) SetToggleArmedStatusFunc(f func()) {
-	r.toggleArmedStatusFunc = f
+// runAction runs the func of the action a button gesture is mapped to
+func (r raspberrypi) runAction(button string, gesture string, action string) {
+
+	log.Printf("%s %s: %s\n", button, gesture, action)
+	if action == ActionNone {
+		return
+	}
+	f, ok := r.actionFuncs.Load(action)
+	if !ok {
+		log.Println("Nothing set up to", action)
+		return
+	}
+	f.(func())()
 }
 
+// Disconnect puts all outputs into their idle state, which turns the relays and LEDs off
 func (r raspberrypi) Disconnect() {
-	// sets both pins to High, as this is when the relay is turned off
-	log.Println("Setting GPIO", r.espressoButtonGpio, "to High (which turns the Relay into Open status)")
-	r.espressoButtonGpio.Out(gpio.High)
-	log.Println("Setting GPIO", r.lungoButtonGpio, "to High (which turns the Relay into Open status)")
-	r.lungoButtonGpio.Out(gpio.High)
-
-	log.Println("Setting GPIO", r.armedLedGpio, "to Low")
-	r.armedLedGpio.Out(gpio.Low)
-	log.Println("Setting GPIO", r.disarmedLedGpio, "to Low")
-	r.disarmedLedGpio.Out(gpio.Low)
+	for _, relay := range []*output{r.espressoButton, r.lungoButton, r.powerButton} {
+		if relay != nil {
+			log.Println("Switching off", relay)
+			relay.idle()
+		}
+	}
+	for _, led := range []*ledDriver{r.armedLed, r.disarmedLed} {
+		if led != nil {
+			log.Println("Switching off", led.out)
+			led.halt()
+		}
+	}
+	if r.buzzer != nil {
+		log.Println("Switching off", r.buzzer.out)
+		r.buzzer.halt()
+	}
 }
 
 func logGPIOFunction(descr string, g gpio.PinIO) {
@@ -231,17 +464,3 @@ func logGPIOFunction(descr string, g gpio.PinIO) {
 		log.Printf("%s GPIO not configured for use\n", descr)
 	}
 }
-
-func getGPIOByNumber(n int) gpio.PinIO {
-	if n < 0 {
-		return nil
-	}
-
-	g := gpioreg.ByName(fmt.Sprint(n))
-
-	if g == nil {
-		log.Fatal("Failed to find Raspberry Pi GPIO", n)
-	}
-
-	return g
-}
@@ -0,0 +1,192 @@
+package coffee
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"periph.io/x/conn/v3/gpio"
+)
+
+// newTestRaspi returns a Raspberry Pi without any pins in use, on the simulator
+func newTestRaspi() *raspberrypi {
+	r, err := NewRaspi(NoRaspiInUseConfig, NewSimHardware())
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+func TestRaspiUnusedPins(t *testing.T) {
+
+	sim := NewSimHardware()
+	r, err := NewRaspi(NoRaspiInUseConfig, sim)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// none of these may touch a pin, or dereference one that isn't there
+	r.ActivateEspressoButton(true)
+	r.ActivateLungoButton(true)
+	r.ActivatePowerButton(true)
+	r.ActivateArmedStatusLED(true, 0, "6:45")
+	r.ActivateArmedStatusLED(false, 0, "")
+	r.SignalHeldBack("no cup")
+	r.Disconnect()
+
+	if changes := sim.Changes(); len(changes) != 0 {
+		t.Errorf("expected no pin changes, got %v", changes)
+	}
+	if !r.CupPresent() {
+		t.Error("expected a cup to be assumed without a cup sensor")
+	}
+}
+
+func TestRaspiDrivesPins(t *testing.T) {
+
+	cfg := NoRaspiInUseConfig
+	cfg.EspressoButtonPin, cfg.ArmedLedPin, cfg.DisarmedLedPin = "27", "GPIO17", "P1_7"
+	sim := NewSimHardware()
+	r, err := NewRaspi(cfg, sim)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.ActivateEspressoButton(true)
+	if sim.Level(27) != gpio.Low {
+		t.Error("expected the espresso relay pin to be Low while the button is pressed")
+	}
+	r.ActivateEspressoButton(false)
+
+	// the status LED is switched off in the background
+	start := time.Now()
+	r.ActivateArmedStatusLED(true, 50, "6:45")
+	if time.Since(start) > 10*time.Millisecond {
+		t.Errorf("expected showing the status not to wait for the LED, took %s", time.Since(start))
+	}
+	time.Sleep(10 * time.Millisecond)
+	if sim.Level(17) != gpio.High {
+		t.Error("expected the armed LED to be on")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if sim.Level(17) != gpio.Low {
+		t.Error("expected the armed LED to be off again")
+	}
+	r.Disconnect()
+
+	var got []string
+	for _, c := range sim.Changes() {
+		if c.Pin == 27 {
+			got = append(got, strings.SplitN(c.String(), " ", 2)[1])
+		}
+	}
+	// switched off at startup, pressed, released, and switched off on exit
+	expected := "GPIO27 High,GPIO27 Low,GPIO27 High,GPIO27 High"
+	if strings.Join(got, ",") != expected {
+		t.Errorf("expected pin changes %s, got %s", expected, strings.Join(got, ","))
+	}
+
+	cfg.LungoButtonPin = "40"
+	if _, err := NewRaspi(cfg, sim); err == nil || !strings.Contains(err.Error(), "Lungo button pin 40") {
+		t.Errorf("expected an error about the lungo button pin, got %v", err)
+	}
+}
+
+func TestRaspiOutputPolarity(t *testing.T) {
+
+	cfg := NoRaspiInUseConfig
+	cfg.EspressoButtonPin, cfg.LungoButtonPin, cfg.ArmedLedPin = "27", "22", "17"
+	cfg.EspressoButtonOutput = OutputConfig{ActiveLow: false, Idle: OutputIdleOff}
+	cfg.LungoButtonOutput = OutputConfig{ActiveLow: true, Idle: OutputIdleFloat}
+	cfg.ArmedLedOutput = OutputConfig{ActiveLow: true}
+	sim := NewSimHardware()
+	sim.SetLevel(27, gpio.High) // an active high relay board, pulled on until the pin is driven
+	r, err := NewRaspi(cfg, sim)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the outputs are idle before anything else happens
+	if sim.Level(27) != gpio.Low {
+		t.Error("expected the active high relay to be switched off at startup")
+	}
+	if sim.pin(22).Func() != gpio.IN {
+		t.Error("expected the floating relay not to be driven at startup")
+	}
+	time.Sleep(10 * time.Millisecond)
+	if sim.Level(17) != gpio.High {
+		t.Error("expected the active low LED to be off at startup")
+	}
+
+	r.ActivateEspressoButton(true)
+	r.ActivateLungoButton(true)
+	if sim.Level(27) != gpio.High || sim.Level(22) != gpio.Low || sim.pin(22).Func() != gpio.OUT {
+		t.Error("expected both relays to be switched on by their active levels")
+	}
+	r.ActivateEspressoButton(false)
+	r.ActivateLungoButton(false)
+	if sim.Level(27) != gpio.Low || sim.pin(22).Func() != gpio.IN {
+		t.Error("expected both relays to be idle again once released")
+	}
+
+	r.ActivateArmedStatusLED(true, 1000, "6:45")
+	time.Sleep(10 * time.Millisecond)
+	if sim.Level(17) != gpio.Low {
+		t.Error("expected the active low LED to be on")
+	}
+
+	r.Disconnect()
+	if sim.Level(27) != gpio.Low || sim.pin(22).Func() != gpio.IN || sim.Level(17) != gpio.High {
+		t.Error("expected all outputs to be idle on exit")
+	}
+
+	cfg.PowerButtonPin, cfg.PowerButtonOutput.Idle = "5", "on"
+	if _, err := NewRaspi(cfg, NewSimHardware()); err == nil || !strings.Contains(err.Error(), "Power button") {
+		t.Errorf("expected an error about the power button's idle state, got %v", err)
+	}
+}
+
+func TestRaspiButtonPresses(t *testing.T) {
+
+	cfg := NoRaspiInUseConfig
+	cfg.ArmButtonPin, cfg.ArmButtonLongPressMs, cfg.DebounceMs = "24", 100, 10
+	cfg.ArmButton = RaspiConfigDefaults.ArmButton
+	cfg.ArmButton.DoublePress = ActionNone
+	cfg.CupSensorPin, cfg.CupSensorActiveLow = "5", true
+	sim := NewSimHardware()
+	r, err := NewRaspi(cfg, sim)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pressed := make(chan string, 2)
+	r.SetActionFunc(ActionToggleArm, func() { pressed <- "toggle" })
+	r.SetActionFunc(ActionSnooze, func() { pressed <- "snooze" })
+
+	for _, c := range []struct {
+		hold     time.Duration
+		expected string
+	}{{30 * time.Millisecond, "toggle"}, {200 * time.Millisecond, "snooze"}} {
+		sim.Press(24, c.hold)
+		select {
+		case got := <-pressed:
+			if got != c.expected {
+				t.Errorf("expected a press of %s to %s, got %s", c.hold, c.expected, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected a press of %s to %s, got nothing", c.hold, c.expected)
+		}
+	}
+
+	cfg.CheckStatusButton.LongPress = "make tea"
+	if _, err := NewRaspi(cfg, sim); err == nil || !strings.Contains(err.Error(), "make tea") {
+		t.Errorf("expected an error about the unknown action, got %v", err)
+	}
+
+	if r.CupPresent() {
+		t.Error("expected no cup while the active low sensor is pulled up")
+	}
+	sim.SetLevel(5, gpio.Low)
+	if !r.CupPresent() {
+		t.Error("expected a cup once the sensor pulls the pin Low")
+	}
+}
@@ -0,0 +1,142 @@
+package coffee
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// defaultSensorTimeout limits how long a wait_for step waits for its sensor, unless the step sets its own timeout
+const defaultSensorTimeout = time.Minute
+
+// sensorPollInterval is how often a wait_for step reads its sensor
+const sensorPollInterval = 50 * time.Millisecond
+
+// RecipeConfig is a named brew, made by running its steps in order
+type RecipeConfig struct {
+	Name  string       `yaml:"name"`
+	Steps []RecipeStep `yaml:"steps"`
+	// Capsule is the type of capsule the recipe uses up, Capsules of them, or 1 if not set
+	Capsule  string `yaml:"capsule,omitempty"`
+	Capsules int    `yaml:"capsules,omitempty"`
+	// WaterMl is an estimate of the water a run uses, for the maintenance counters
+	WaterMl int `yaml:"water_ml,omitempty"`
+}
+
+// RecipeStep does exactly one of: press a button, warm up the machine, wait for a while, or wait for a sensor
+type RecipeStep struct {
+	// Press holds the named button for DurationMs, or for the configured button press duration
+	Press      string `yaml:"press,omitempty"`
+	DurationMs int    `yaml:"duration_ms,omitempty"`
+	// WarmUp switches the machine on with the named button, unless it is on already, and waits until it has heated up
+	WarmUp string `yaml:"warm_up,omitempty"`
+	WaitMs int    `yaml:"wait_ms,omitempty"`
+	// WaitFor waits until the named sensor reports true, for at most TimeoutMs
+	WaitFor   string `yaml:"wait_for,omitempty"`
+	TimeoutMs int    `yaml:"timeout_ms,omitempty"`
+}
+
+func (s RecipeStep) String() string {
+	switch {
+	case s.Press != "" && s.DurationMs > 0:
+		return fmt.Sprintf("press %s for %dms", s.Press, s.DurationMs)
+	case s.Press != "":
+		return "press " + s.Press
+	case s.WarmUp != "":
+		return "warm up with " + s.WarmUp
+	case s.WaitFor != "":
+		return "wait for " + s.WaitFor
+	default:
+		return fmt.Sprintf("wait %dms", s.WaitMs)
+	}
+}
+
+// validate checks that the step does exactly one thing, with a button that exists
+func (s RecipeStep) validate(buttons map[string]func(press bool)) error {
+
+	actions := 0
+	for _, set := range []bool{s.Press != "", s.WarmUp != "", s.WaitMs > 0, s.WaitFor != ""} {
+		if set {
+			actions++
+		}
+	}
+	if actions != 1 {
+		return fmt.Errorf("step '%s' must do exactly one of press, warm_up, wait_ms or wait_for", s)
+	}
+
+	for _, button := range []string{s.Press, s.WarmUp} {
+		if _, ok := buttons[button]; button != "" && !ok {
+			return fmt.Errorf("unknown button '%s'", button)
+		}
+	}
+	return nil
+}
+
+func (r RecipeConfig) validate(buttons map[string]func(press bool)) error {
+
+	if r.Name == "" || strings.EqualFold(r.Name, BrewNone) {
+		return fmt.Errorf("invalid recipe name '%s'", r.Name)
+	}
+	if len(r.Steps) == 0 {
+		return fmt.Errorf("recipe '%s' has no steps", r.Name)
+	}
+	for i, step := range r.Steps {
+		if err := step.validate(buttons); err != nil {
+			return fmt.Errorf("recipe '%s' step %d: %w", r.Name, i+1, err)
+		}
+	}
+	return nil
+}
+
+// capsules returns the type and number of capsules a run of the recipe uses up, count is 0 if it uses none
+func (r RecipeConfig) capsules() (capsule string, count int) {
+	if r.Capsule == "" {
+		return "", 0
+	}
+	if r.Capsules <= 0 {
+		return r.Capsule, 1
+	}
+	return r.Capsule, r.Capsules
+}
+
+// warmUpButton returns the button the recipe switches the machine on with, ok is false if it has no warm-up step
+func (r RecipeConfig) warmUpButton() (string, bool) {
+	for _, step := range r.Steps {
+		if step.WarmUp != "" {
+			return step.WarmUp, true
+		}
+	}
+	return "", false
+}
+
+// sleep waits for d, returning early with an error if ctx is cancelled
+func sleep(ctx context.Context, d time.Duration) error {
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// waitFor polls the sensor until it reports true, ctx is cancelled or timeout has passed
+func waitFor(ctx context.Context, name string, sensor func() bool, timeout time.Duration) error {
+
+	deadline := time.Now().Add(timeout)
+	for !sensor() {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("sensor '%s' still not ready after %s", name, timeout)
+		}
+		if err := sleep(ctx, sensorPollInterval); err != nil {
+			return err
+		}
+	}
+	log.Printf("Sensor '%s' is ready\n", name)
+	return nil
+}
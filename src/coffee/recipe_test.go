@@ -0,0 +1,144 @@
+package coffee
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestMachine returns a machine whose buttons record when they are pressed and released instead of driving GPIOs
+func newTestMachine(recipes []RecipeConfig) (*buttonMachine, func() []string) {
+
+	n := NewNespressoMachine(MachineConfig{ButtonPressDurationMs: 10, HeatingDurationMs: 20, Recipes: recipes}, newTestRaspi()).(*buttonMachine)
+
+	var mu sync.Mutex
+	var events []string
+	for name := range n.buttons {
+		name := name
+		n.buttons[name] = func(press bool) {
+			mu.Lock()
+			defer mu.Unlock()
+			if press {
+				events = append(events, "press "+name)
+			} else {
+				events = append(events, "release "+name)
+			}
+		}
+	}
+
+	return n, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), events...)
+	}
+}
+
+func TestRunRecipe(t *testing.T) {
+
+	n, events := newTestMachine([]RecipeConfig{{Name: "americano", Steps: []RecipeStep{
+		{WarmUp: "espresso"},
+		{Press: "lungo", DurationMs: 5},
+		{WaitMs: 5},
+		{WaitFor: "water"},
+		{Press: "espresso"},
+	}}})
+
+	if got := strings.Join(n.Recipes(), ","); got != "americano" {
+		t.Fatalf("expected only the configured recipe, got %s", got)
+	}
+
+	if err := n.RunRecipe(context.Background(), "americano"); err == nil || !strings.Contains(err.Error(), "unknown sensor") {
+		t.Fatalf("expected error about the missing sensor, got %v", err)
+	}
+
+	n, events = newTestMachine(n.recipes)
+	readings := 0
+	n.SetSensor("water", func() bool { readings++; return readings > 2 })
+	if err := n.RunRecipe(context.Background(), "americano"); err != nil {
+		t.Fatal(err)
+	}
+	want := "press espresso,release espresso,press lungo,release lungo,press espresso,release espresso"
+	if got := strings.Join(events(), ","); got != want {
+		t.Fatalf("expected %s, got %s", want, got)
+	}
+
+	if err := n.RunRecipe(context.Background(), BrewNone); err == nil {
+		t.Fatal("expected error for unknown recipe")
+	}
+
+}
+
+func TestCancelledRecipeReleasesButton(t *testing.T) {
+
+	n, events := newTestMachine([]RecipeConfig{{Name: "long press", Steps: []RecipeStep{{Press: "lungo", DurationMs: 10000}, {Press: "espresso"}}}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	if err := n.RunRecipe(ctx, "long press"); err == nil {
+		t.Fatal("expected the cancelled recipe to fail")
+	}
+	if got := strings.Join(events(), ","); got != "press lungo,release lungo" {
+		t.Fatalf("expected the button to be released and no further steps, got %s", got)
+	}
+
+}
+
+func TestInvalidRecipesAreSkipped(t *testing.T) {
+
+	n, _ := newTestMachine([]RecipeConfig{
+		{Name: "ok", Steps: []RecipeStep{{Press: "espresso"}}},
+		{Name: "no steps"},
+		{Name: "unknown button", Steps: []RecipeStep{{Press: "steam"}}},
+		{Name: "two things at once", Steps: []RecipeStep{{Press: "espresso", WaitMs: 100}}},
+		{Name: BrewNone, Steps: []RecipeStep{{Press: "espresso"}}},
+		{Name: "ok", Steps: []RecipeStep{{Press: "lungo"}}},
+	})
+
+	if got := strings.Join(n.Recipes(), ","); got != "ok" {
+		t.Fatalf("expected only the valid recipe, got %s", got)
+	}
+
+}
+
+func TestBrewHeldBackWithoutCup(t *testing.T) {
+
+	for _, tt := range []struct {
+		name      string
+		cupWait   time.Duration
+		cupAfter  int // sensor readings until a cup is in place, -1 for never
+		wantErr   bool
+		wantPress string
+	}{
+		{"refuse at once", 0, 1, true, ""},
+		{"wait for the cup", time.Second, 3, false, "press espresso,release espresso"},
+		{"give up waiting", 100 * time.Millisecond, -1, true, ""},
+	} {
+		n, events := newTestMachine([]RecipeConfig{{Name: BrewEspresso, Steps: []RecipeStep{{Press: "espresso"}}}})
+		n.cupWait = tt.cupWait
+
+		var mu sync.Mutex
+		readings := 0
+		n.SetSensor(SensorCup, func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			readings++
+			return tt.cupAfter >= 0 && readings > tt.cupAfter
+		})
+		var reasons []string
+		n.SetHeldBackFunc(func(reason string) { reasons = append(reasons, reason) })
+
+		err := n.RunRecipe(context.Background(), BrewEspresso)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+		}
+		if len(reasons) != 1 || !strings.HasPrefix(reasons[0], "no cup in place") {
+			t.Errorf("%s: expected the brew to be held back once, got %v", tt.name, reasons)
+		}
+		if got := strings.Join(events(), ","); got != tt.wantPress {
+			t.Errorf("%s: expected presses '%s', got '%s'", tt.name, tt.wantPress, got)
+		}
+	}
+
+}
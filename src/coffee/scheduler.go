@@ -0,0 +1,679 @@
+package coffee
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AlarmConfig describes one named alarm, with its own schedule and brew type
+type AlarmConfig struct {
+	ID                string `yaml:"id" json:"id"`
+	Label             string `yaml:"label" json:"label"`
+	Enabled           bool   `yaml:"enabled" json:"enabled"`
+	CoffeeTimerConfig `yaml:",inline"`
+}
+
+// AlarmState is an alarm as saved by the StateStore, including the trigger that was pending when it was saved
+type AlarmState struct {
+	AlarmConfig
+	PendingTrigger time.Time `json:"pending_trigger,omitempty"`
+	PendingBrew    string    `json:"pending_brew,omitempty"`
+}
+
+// SchedulerState is everything the Scheduler needs to carry on where it left off after a restart
+type SchedulerState struct {
+	Armed  bool         `json:"armed"`
+	Alarms []AlarmState `json:"alarms"`
+}
+
+type SnoozeConfig struct {
+	Minutes int `yaml:"minutes"`
+}
+
+var SnoozeConfigDefaults = SnoozeConfig{
+	Minutes: 10,
+}
+
+// Duration is how long snoozing postpones the next coffee
+func (cfg SnoozeConfig) Duration() time.Duration {
+	if cfg.Minutes <= 0 {
+		return time.Duration(SnoozeConfigDefaults.Minutes) * time.Minute
+	}
+	return time.Duration(cfg.Minutes) * time.Minute
+}
+
+// Alarm is a named CoffeeTimer owned by the Scheduler.
+// The timer of an alarm is only armed while the alarm is enabled and the Scheduler is armed.
+// Alarms are handed out by the Scheduler as copies, changes go through the Scheduler.
+type Alarm struct {
+	id, label string
+	enabled   bool
+	timer     *CoffeeTimer
+}
+
+func (a Alarm) ID() string {
+	return a.id
+}
+
+func (a Alarm) Label() string {
+	return a.label
+}
+
+func (a Alarm) IsEnabled() bool {
+	return a.enabled
+}
+
+// Timer gives access to the alarm's schedule, changes to the schedule take effect immediately
+func (a Alarm) Timer() *CoffeeTimer {
+	return a.timer
+}
+
+// Scheduler owns any number of alarms and acts as the master switch for all of them
+type Scheduler struct {
+	mu                 sync.Mutex
+	raspi              *raspberrypi
+	clock              Clock
+	showStatusLengthMs int
+	isArmed            bool
+	alarms             []*Alarm
+	lastID             int
+	brewFunc           func(brew string)
+	powerOnFunc        func(brew string)
+	warmUpLead         time.Duration
+	armCheckFunc       func(brew string) error
+	recipes            []string
+	skipCalendar       *SkipCalendar
+	geo                *GeoLocation
+	missedTriggers     MissedTriggerConfig
+	changedMu          sync.Mutex // separate from mu, as timers report changes while mu is held
+	changedFunc        func()
+}
+
+func NewScheduler(raspi *raspberrypi, clock Clock) *Scheduler {
+	return &Scheduler{raspi: raspi, clock: clock, showStatusLengthMs: 2000, brewFunc: func(string) {}, changedFunc: func() {}}
+}
+
+// AddAlarm creates a new alarm. If cfg.ID is empty, a unique ID is generated.
+func (s *Scheduler) AddAlarm(cfg AlarmConfig) (Alarm, error) {
+
+	defer s.changed()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := cfg.ID
+	if id == "" {
+		for id == "" || s.find(id) != nil {
+			s.lastID++
+			id = fmt.Sprintf("alarm%d", s.lastID)
+		}
+	} else if s.find(id) != nil {
+		return Alarm{}, fmt.Errorf("alarm with ID '%s' already exists", id)
+	}
+
+	label := cfg.Label
+	if label == "" {
+		label = id
+	}
+	// a brew the machine can't make would only fail when the alarm triggers
+	if err := cfg.checkBrews(s.recipes); err != nil {
+		return Alarm{}, fmt.Errorf("alarm '%s': %w", label, err)
+	}
+	if isSunTrigger(cfg.TriggerTime) && s.geo == nil {
+		return Alarm{}, fmt.Errorf("alarm '%s': %w", label, ErrNoGeoLocation)
+	}
+
+	a := &Alarm{id: id, label: label, enabled: cfg.Enabled, timer: NewCoffeeTimer(cfg.CoffeeTimerConfig, s.raspi, s.clock)}
+	a.timer.SetBrewFunc(s.brewFunc)
+	a.timer.SetPowerOnFunc(s.powerOnFunc, s.warmUpLead)
+	a.timer.SetArmCheckFunc(s.armCheckFunc)
+	a.timer.SetRecipes(s.recipes)
+	a.timer.SetSkipCalendar(s.skipCalendar)
+	if s.geo != nil {
+		a.timer.SetGeoLocation(*s.geo)
+	}
+	a.timer.SetMissedTriggerConfig(s.missedTriggers)
+	a.timer.SetChangedFunc(s.changed)
+	s.alarms = append(s.alarms, a)
+	log.Printf("Added alarm '%s' (%s), enabled: %t\n", a.label, a.id, a.enabled)
+
+	s.updateTimer(a)
+
+	return *a, nil
+}
+
+// Alarms lists all alarms in the order they have been added
+func (s *Scheduler) Alarms() []Alarm {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	alarms := make([]Alarm, len(s.alarms))
+	for i, a := range s.alarms {
+		alarms[i] = *a
+	}
+	return alarms
+}
+
+// Alarm looks up an alarm by its ID
+func (s *Scheduler) Alarm(id string) (Alarm, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a := s.find(id)
+	if a == nil {
+		return Alarm{}, false
+	}
+	return *a, true
+}
+
+// UpdateAlarm changes the label and enabled flag of an alarm, and arms or disarms its timer accordingly
+func (s *Scheduler) UpdateAlarm(id string, label string, enabled bool) error {
+
+	defer s.changed()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a := s.find(id)
+	if a == nil {
+		return fmt.Errorf("no alarm with ID '%s'", id)
+	}
+
+	if label != "" {
+		a.label = label
+	}
+	a.enabled = enabled
+	log.Printf("Updated alarm '%s' (%s), enabled: %t\n", a.label, a.id, a.enabled)
+
+	s.updateTimer(a)
+
+	return nil
+}
+
+// DeleteAlarm disarms and removes an alarm
+func (s *Scheduler) DeleteAlarm(id string) error {
+
+	defer s.changed()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, a := range s.alarms {
+		if a.id == id {
+			a.timer.Disarm()
+			s.alarms = append(s.alarms[:i], s.alarms[i+1:]...)
+			log.Printf("Deleted alarm '%s' (%s)\n", a.label, a.id)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("no alarm with ID '%s'", id)
+}
+
+// Next returns the alarm that fires next, with its trigger time and brew type. ok is false if no alarm is armed.
+func (s *Scheduler) Next() (next Alarm, triggerTime time.Time, brew string, ok bool) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, a := range s.alarms {
+		t, b, armed := a.timer.NextTrigger()
+		if armed && (!ok || t.Before(triggerTime)) {
+			next, triggerTime, brew, ok = *a, t, b, true
+		}
+	}
+
+	return next, triggerTime, brew, ok
+}
+
+// Arm arms the timers of all enabled alarms
+func (s *Scheduler) Arm() {
+	defer s.changed()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.isArmed = true
+	for _, a := range s.alarms {
+		s.updateTimer(a)
+	}
+}
+
+// Disarm disarms all alarm timers, leaving their enabled flags unchanged
+func (s *Scheduler) Disarm() {
+	defer s.changed()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.isArmed = false
+	for _, a := range s.alarms {
+		s.updateTimer(a)
+	}
+}
+
+func (s *Scheduler) IsArmed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.isArmed
+}
+
+func (s *Scheduler) ToggleArmedStatus() {
+
+	if s.IsArmed() {
+		s.Disarm()
+		s.raspi.PlayTune(TuneDisarm)
+	} else {
+		s.Arm()
+		s.raspi.PlayTune(TuneArm)
+	}
+
+	s.ShowArmedStatus()
+
+}
+
+// Snooze postpones the next coffee, whichever alarm it belongs to, by d and shows the new time, see CoffeeTimer.Snooze
+func (s *Scheduler) Snooze(d time.Duration) {
+	if _, _, err := s.Postpone(d); err != nil {
+		log.Println(err)
+		return
+	}
+	s.ShowArmedStatus()
+}
+
+// Postpone is Snooze without showing the status. It returns the alarm whose coffee has been postponed and its new time.
+func (s *Scheduler) Postpone(d time.Duration) (Alarm, time.Time, error) {
+
+	a, _, _, ok := s.Next()
+	if !ok {
+		return Alarm{}, time.Time{}, fmt.Errorf("no coffee pending to postpone")
+	}
+
+	triggerTime, ok := a.timer.Postpone(d)
+	if !ok {
+		return Alarm{}, time.Time{}, fmt.Errorf("alarm '%s' has no coffee pending to postpone", a.Label())
+	}
+	return a, triggerTime, nil
+}
+
+func (s *Scheduler) ShowArmedStatus() {
+
+	a, triggerTime, brew, ok := s.Next()
+
+	triggerStr := ""
+	if ok {
+		triggerStr = fmt.Sprintf("%s at %s (alarm '%s')", brew, triggerTime.Format("Mon 15:04:05"), a.Label())
+		if scheduled, snoozed := a.timer.SnoozedFrom(); snoozed {
+			triggerStr += fmt.Sprintf(", snoozed from %s", scheduled.Format("15:04:05"))
+		}
+	}
+
+	s.raspi.ActivateArmedStatusLED(ok, s.showStatusLengthMs, triggerStr)
+}
+
+// SetBrewFunc sets the function that makes the scheduled brew type when any of the alarms triggers
+func (s *Scheduler) SetBrewFunc(f func(brew string)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.brewFunc = f
+	for _, a := range s.alarms {
+		a.timer.SetBrewFunc(f)
+	}
+}
+
+// SetPowerOnFunc sets the function switching the machine on lead ahead of each trigger, see CoffeeTimer.SetPowerOnFunc
+func (s *Scheduler) SetPowerOnFunc(f func(brew string), lead time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if lead > 0 {
+		log.Println("Switching the machine on", lead, "ahead of each coffee")
+	}
+	s.powerOnFunc, s.warmUpLead = f, lead
+	for _, a := range s.alarms {
+		a.timer.SetPowerOnFunc(f, lead)
+	}
+}
+
+// SetArmCheckFunc sets the function all alarm timers ask before arming whether their next coffee can be made, see CoffeeTimer.SetArmCheckFunc
+func (s *Scheduler) SetArmCheckFunc(f func(brew string) error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.armCheckFunc = f
+	for _, a := range s.alarms {
+		a.timer.SetArmCheckFunc(f)
+	}
+}
+
+// SetRecipes sets the brews the machine can make, alarms making any other brew are refused, see CoffeeTimer.SetRecipes
+func (s *Scheduler) SetRecipes(recipes []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.recipes = recipes
+	for _, a := range s.alarms {
+		a.timer.SetRecipes(recipes)
+	}
+}
+
+// RecheckArming asks the arm check again, e.g. after the capsules have been refilled or used up.
+// Timers that have refused to arm try again, and armed timers whose next coffee can no longer be made disarm.
+func (s *Scheduler) RecheckArming() {
+	defer s.changed()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, a := range s.alarms {
+		if a.timer.IsArmed() {
+			a.timer.RecheckArming()
+		} else {
+			s.updateTimer(a)
+		}
+	}
+}
+
+// State returns a snapshot of the armed status and all alarms, for the StateStore to save
+func (s *Scheduler) State() SchedulerState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state := SchedulerState{Armed: s.isArmed}
+	for _, a := range s.alarms {
+		as := AlarmState{AlarmConfig: AlarmConfig{ID: a.id, Label: a.label, Enabled: a.enabled, CoffeeTimerConfig: a.timer.Config()}}
+		if triggerTime, brew, ok := a.timer.NextTrigger(); ok {
+			as.PendingTrigger, as.PendingBrew = triggerTime, brew
+		}
+		state.Alarms = append(state.Alarms, as)
+	}
+	return state
+}
+
+// Restore replaces all alarms with the ones from a saved state, and re-arms if the saved state was armed.
+// Alarms added before, usually from the config, are logged where the saved state differs from them.
+func (s *Scheduler) Restore(state SchedulerState) {
+
+	s.mu.Lock()
+	s.isArmed = false
+	var configured []AlarmConfig
+	for _, a := range s.alarms {
+		configured = append(configured, AlarmConfig{ID: a.id, Label: a.label, Enabled: a.enabled, CoffeeTimerConfig: a.timer.Config()})
+		a.timer.Disarm()
+	}
+	s.alarms = nil
+	s.mu.Unlock()
+
+	for _, override := range savedOverrides(configured, state.Alarms) {
+		log.Println(override)
+	}
+
+	var missed []AlarmState
+	for _, as := range state.Alarms {
+		a, err := s.AddAlarm(as.AlarmConfig)
+		if err != nil {
+			log.Println("Could not restore alarm:", err)
+			continue
+		}
+		log.Printf("Restored alarm '%s' (%s), enabled: %t\n", a.Label(), a.ID(), a.IsEnabled())
+		if !as.PendingTrigger.IsZero() {
+			log.Printf("Alarm '%s' was pending to make %s at %s\n", a.Label(), as.PendingBrew, as.PendingTrigger)
+			if as.PendingTrigger.Before(s.clock.Now()) {
+				missed = append(missed, as)
+			}
+		}
+	}
+
+	if state.Armed {
+		log.Println("Restored armed status, re-arming")
+		s.Arm()
+	} else {
+		log.Println("Restored disarmed status")
+		s.Disarm()
+	}
+
+	// coffees that were due while the Pi was down, unless the alarm was off anyway
+	if !state.Armed {
+		return
+	}
+	for _, as := range missed {
+		if a, ok := s.Alarm(as.ID); ok && a.IsEnabled() {
+			a.timer.CatchUp(as.PendingTrigger, as.PendingBrew)
+		}
+	}
+}
+
+// savedOverrides describes where the saved alarms differ from the configured ones they replace
+func savedOverrides(configured []AlarmConfig, saved []AlarmState) []string {
+
+	var overrides []string
+	savedIDs := map[string]bool{}
+	for _, as := range saved {
+		savedIDs[as.ID] = true
+	}
+
+	for _, cfg := range configured {
+		if !savedIDs[cfg.ID] {
+			overrides = append(overrides, fmt.Sprintf("Alarm '%s' (%s) from the config is not in the saved state, it has been deleted in the web UI", cfg.Label, cfg.ID))
+			continue
+		}
+		for _, as := range saved {
+			if as.ID == cfg.ID && !reflect.DeepEqual(as.AlarmConfig, cfg) {
+				overrides = append(overrides, fmt.Sprintf("Alarm '%s' (%s) from the saved state overrides the config, which differs from it. Delete the saved state to start from the config.", as.Label, as.ID))
+			}
+		}
+	}
+
+	for _, as := range saved {
+		found := false
+		for _, cfg := range configured {
+			found = found || cfg.ID == as.ID
+		}
+		if !found {
+			overrides = append(overrides, fmt.Sprintf("Alarm '%s' (%s) is only in the saved state, it has been added in the web UI", as.Label, as.ID))
+		}
+	}
+
+	return overrides
+}
+
+// SetStateChangedFunc sets a function that is called after anything has changed that State() would return.
+// It may be called while the scheduler's lock is held, so it must not call back into the scheduler synchronously.
+func (s *Scheduler) SetStateChangedFunc(f func()) {
+	s.changedMu.Lock()
+	defer s.changedMu.Unlock()
+
+	s.changedFunc = f
+}
+
+func (s *Scheduler) changed() {
+	s.changedMu.Lock()
+	changedFunc := s.changedFunc
+	s.changedMu.Unlock()
+
+	changedFunc()
+}
+
+// SetSkipCalendar sets the holidays and vacations on which none of the alarms make coffee
+func (s *Scheduler) SetSkipCalendar(c *SkipCalendar) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.skipCalendar = c
+	for _, a := range s.alarms {
+		a.timer.SetSkipCalendar(c)
+	}
+}
+
+// SetGeoLocation sets where the coffee machine is, for alarms triggering relative to sunrise or sunset
+func (s *Scheduler) SetGeoLocation(geo GeoLocation) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	log.Printf("Working out sunrise and sunset for latitude %.4f, longitude %.4f\n", geo.Latitude, geo.Longitude)
+	s.geo = &geo
+	for _, a := range s.alarms {
+		a.timer.SetGeoLocation(geo)
+	}
+}
+
+// ValidateTriggerRule returns why a cron expression or sunrise/sunset rule cannot be used by the alarms, or nil.
+// Unlike the ValidateTriggerRule func, it refuses a rule relative to sunrise or sunset while no location has been set.
+func (s *Scheduler) ValidateTriggerRule(rule string) error {
+
+	if err := ValidateTriggerRule(rule); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if isSunTrigger(rule) && s.geo == nil {
+		return ErrNoGeoLocation
+	}
+	return nil
+}
+
+// Preview lists the next n times a cron expression or sunrise/sunset rule would trigger, without adding an alarm
+func (s *Scheduler) Preview(rule string, n int) ([]UpcomingTrigger, error) {
+
+	if strings.TrimSpace(rule) == "" {
+		return nil, fmt.Errorf("nothing to preview")
+	}
+	if err := s.ValidateTriggerRule(rule); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	ct := NewCoffeeTimer(CoffeeTimerConfig{TriggerTime: rule}, s.raspi, s.clock)
+	if s.geo != nil {
+		ct.SetGeoLocation(*s.geo)
+	}
+	s.mu.Unlock()
+
+	return ct.Upcoming(n), nil
+}
+
+// SetMissedTriggerConfig sets what all alarms do about coffees that have not been made at their trigger time
+func (s *Scheduler) SetMissedTriggerConfig(cfg MissedTriggerConfig) {
+
+	switch cfg.policy() {
+	case MissedTriggerSkip, MissedTriggerBrew, MissedTriggerNotify:
+	default:
+		log.Printf("Unknown missed trigger policy '%s', using '%s' instead\n", cfg.Policy, MissedTriggerConfigDefaults.Policy)
+		cfg.Policy = MissedTriggerConfigDefaults.Policy
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	log.Printf("Missed trigger policy: %s\n", cfg.policy())
+	s.missedTriggers = cfg
+	for _, a := range s.alarms {
+		a.timer.SetMissedTriggerConfig(cfg)
+	}
+}
+
+// AlarmMiss is a coffee missed by one of the alarms
+type AlarmMiss struct {
+	Alarm Alarm
+	MissedTrigger
+}
+
+// MissedTriggers lists the missed coffees of all alarms notified about, in the order they were due
+func (s *Scheduler) MissedTriggers() []AlarmMiss {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var misses []AlarmMiss
+	for _, a := range s.alarms {
+		for _, missed := range a.timer.MissedTriggers() {
+			misses = append(misses, AlarmMiss{Alarm: *a, MissedTrigger: missed})
+		}
+	}
+	sort.SliceStable(misses, func(i, j int) bool { return misses[i].Time.Before(misses[j].Time) })
+	return misses
+}
+
+// DismissMissedTriggers clears the missed coffees notified about for all alarms
+func (s *Scheduler) DismissMissedTriggers() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, a := range s.alarms {
+		a.timer.DismissMissedTriggers()
+	}
+}
+
+// ReloadSkipCalendar imports the skip calendar's .ics file again and re-arms the alarms accordingly
+func (s *Scheduler) ReloadSkipCalendar() error {
+
+	s.mu.Lock()
+	c := s.skipCalendar
+	s.mu.Unlock()
+
+	if c == nil {
+		return fmt.Errorf("no skip calendar configured")
+	}
+	if err := c.Reload(); err != nil {
+		return err
+	}
+
+	s.SetSkipCalendar(c)
+	return nil
+}
+
+// AlarmSkip is a coffee of one of the alarms that is skipped because of the skip calendar
+type AlarmSkip struct {
+	Alarm Alarm
+	SkippedTrigger
+}
+
+// SkippedTriggers lists the skipped coffees of all enabled alarms from now until the given time, in chronological order
+func (s *Scheduler) SkippedTriggers(until time.Time) []AlarmSkip {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var skips []AlarmSkip
+	for _, a := range s.alarms {
+		if !a.enabled {
+			continue
+		}
+		for _, skipped := range a.timer.SkippedTriggers(until) {
+			skips = append(skips, AlarmSkip{Alarm: *a, SkippedTrigger: skipped})
+		}
+	}
+
+	sort.Slice(skips, func(i, j int) bool { return skips[i].Time.Before(skips[j].Time) })
+	return skips
+}
+
+func (s *Scheduler) find(id string) *Alarm {
+	for _, a := range s.alarms {
+		if a.id == id {
+			return a
+		}
+	}
+	return nil
+}
+
+// updateTimer arms the alarm's timer if both the alarm and the scheduler are armed, and disarms it otherwise
+func (s *Scheduler) updateTimer(a *Alarm) {
+
+	shouldBeArmed := s.isArmed && a.enabled
+	if shouldBeArmed == a.timer.IsArmed() {
+		return
+	}
+
+	if shouldBeArmed {
+		a.timer.Arm()
+	} else {
+		a.timer.Disarm()
+	}
+}
@@ -0,0 +1,212 @@
+package coffee
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSchedulerPicksEarliestAlarm(t *testing.T) {
+
+	s := NewScheduler(newTestRaspi(), newFakeClock(testStart))
+
+	late := testStart.Add(2 * time.Hour).Format("15:04:05")
+	early := testStart.Add(1 * time.Hour).Format("15:04:05")
+
+	if _, err := s.AddAlarm(AlarmConfig{Label: "late", Enabled: true, CoffeeTimerConfig: CoffeeTimerConfig{TriggerTime: late, Brew: BrewLungo}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.AddAlarm(AlarmConfig{Label: "early", Enabled: true, CoffeeTimerConfig: CoffeeTimerConfig{TriggerTime: early, Brew: BrewEspresso}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.AddAlarm(AlarmConfig{Label: "disabled", Enabled: false, CoffeeTimerConfig: CoffeeTimerConfig{TriggerTime: testStart.Add(30 * time.Minute).Format("15:04:05")}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, _, ok := s.Next(); ok {
+		t.Fatal("scheduler reports a next alarm before being armed")
+	}
+
+	s.Arm()
+	defer s.Disarm()
+
+	alarm, _, brew, ok := s.Next()
+	if !ok || alarm.Label() != "early" || brew != BrewEspresso {
+		t.Fatalf("expected the early espresso alarm to fire next, got '%s' making %s", alarm.Label(), brew)
+	}
+
+}
+
+func TestSchedulerAlarmLifecycle(t *testing.T) {
+
+	s := NewScheduler(newTestRaspi(), newFakeClock(testStart))
+	s.Arm()
+	defer s.Disarm()
+
+	alarm, err := s.AddAlarm(AlarmConfig{Label: "brunch", CoffeeTimerConfig: CoffeeTimerConfig{TriggerTime: "10:00", Brew: BrewLungo}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if alarm.ID() == "" {
+		t.Fatal("no ID generated for new alarm")
+	}
+	if _, err := s.AddAlarm(AlarmConfig{ID: alarm.ID()}); err == nil {
+		t.Fatal("adding an alarm with a duplicate ID should fail")
+	}
+
+	if err := s.UpdateAlarm(alarm.ID(), "weekend brunch", true); err != nil {
+		t.Fatal(err)
+	}
+	alarm, ok := s.Alarm(alarm.ID())
+	if !ok || alarm.Label() != "weekend brunch" || !alarm.Timer().IsArmed() {
+		t.Fatal("enabled alarm has not been updated and armed")
+	}
+
+	if err := s.DeleteAlarm(alarm.ID()); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Alarms()) != 0 || alarm.Timer().IsArmed() {
+		t.Fatal("deleted alarm is still listed or armed")
+	}
+
+}
+
+func TestSchedulerRefusesUnknownRecipes(t *testing.T) {
+
+	s := NewScheduler(newTestRaspi(), newFakeClock(testStart))
+	s.SetRecipes([]string{BrewFilterCoffee})
+
+	// the timer's default brew is espresso, which the filter machine can't make
+	if _, err := s.AddAlarm(AlarmConfig{Label: "default", CoffeeTimerConfig: CoffeeTimerConfigDefaults}); err == nil {
+		t.Error("expected an alarm making espresso to be refused")
+	}
+	schedule := map[string]ScheduleEntry{"sunday": {Brew: BrewLungo}}
+	if _, err := s.AddAlarm(AlarmConfig{Label: "sunday", CoffeeTimerConfig: CoffeeTimerConfig{TriggerTime: "8:30", Brew: BrewFilterCoffee, Schedule: schedule}}); err == nil {
+		t.Error("expected an alarm making lungo on Sundays to be refused")
+	}
+
+	alarm, err := s.AddAlarm(AlarmConfig{Label: "filter", CoffeeTimerConfig: CoffeeTimerConfig{TriggerTime: "8:30", Brew: BrewFilterCoffee}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := alarm.Timer().SetDaySchedule(time.Sunday, "9:00", BrewEspresso); err == nil {
+		t.Error("expected an espresso on Sundays to be refused")
+	}
+	if triggerTime, brew := alarm.Timer().DaySchedule(time.Sunday); triggerTime != "08:30" || brew != BrewFilterCoffee {
+		t.Errorf("expected Sunday to be left unchanged, got %s at %s", brew, triggerTime)
+	}
+	if err := alarm.Timer().SetDaySchedule(time.Sunday, "9:00", BrewNone); err != nil {
+		t.Errorf("expected no coffee on Sundays to be accepted, got %v", err)
+	}
+	if err := alarm.Timer().SetTriggerRule("30 6 * * 1-5", BrewEspresso); err == nil {
+		t.Error("expected a cron rule making espresso to be refused")
+	}
+	if rule, _ := alarm.Timer().TriggerRule(); rule != "" {
+		t.Errorf("expected the weekly schedule to be kept, got rule '%s'", rule)
+	}
+}
+
+func TestSchedulerStateSurvivesRestart(t *testing.T) {
+
+	store, err := NewStateStore(StateStoreConfig{Dir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewScheduler(newTestRaspi(), newFakeClock(testStart))
+	alarm, err := s.AddAlarm(AlarmConfig{Label: "early shift", Enabled: true, CoffeeTimerConfig: CoffeeTimerConfig{TriggerTime: "5:30", Brew: BrewEspresso}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	alarm.Timer().SetDaySchedule(time.Sunday, "", BrewNone)
+	alarm.Timer().SetDaySchedule(time.Saturday, "9:15", BrewLungo)
+	s.Arm()
+	defer s.Disarm()
+
+	if err := store.Save("scheduler", s.State()); err != nil {
+		t.Fatal(err)
+	}
+
+	var state SchedulerState
+	if ok, err := store.Load("scheduler", &state); !ok || err != nil {
+		t.Fatal("saved state could not be loaded:", err)
+	}
+
+	restored := NewScheduler(newTestRaspi(), newFakeClock(testStart))
+	restored.Restore(state)
+	defer restored.Disarm()
+
+	if !restored.IsArmed() || len(restored.Alarms()) != 1 {
+		t.Fatal("armed status or alarms have not been restored")
+	}
+	restoredAlarm := restored.Alarms()[0]
+	if restoredAlarm.Label() != "early shift" || !restoredAlarm.Timer().IsArmed() {
+		t.Fatal("restored alarm has lost its label or is not re-armed")
+	}
+	for _, day := range Weekdays {
+		wantTime, wantBrew := alarm.Timer().DaySchedule(day)
+		gotTime, gotBrew := restoredAlarm.Timer().DaySchedule(day)
+		if gotTime != wantTime || gotBrew != wantBrew {
+			t.Fatalf("%s restored as %s at %s, expected %s at %s", day, gotBrew, gotTime, wantBrew, wantTime)
+		}
+	}
+
+}
+
+func TestSchedulerReportsSavedOverrides(t *testing.T) {
+
+	configure := func(cfgs ...AlarmConfig) SchedulerState {
+		s := NewScheduler(newTestRaspi(), newFakeClock(testStart))
+		for _, cfg := range cfgs {
+			if _, err := s.AddAlarm(cfg); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return s.State()
+	}
+	configs := func(state SchedulerState) []AlarmConfig {
+		var cfgs []AlarmConfig
+		for _, as := range state.Alarms {
+			cfgs = append(cfgs, as.AlarmConfig)
+		}
+		return cfgs
+	}
+	defaultAlarm := AlarmConfig{ID: "default", Label: "Default", Enabled: true, CoffeeTimerConfig: CoffeeTimerConfig{TriggerTime: "6:30", Brew: BrewEspresso}}
+	weekend := AlarmConfig{ID: "weekend", Label: "Weekend", CoffeeTimerConfig: CoffeeTimerConfig{TriggerTime: "9:00", Brew: BrewLungo}}
+
+	store, err := NewStateStore(StateStoreConfig{Dir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Save("scheduler", configure(defaultAlarm, weekend)); err != nil {
+		t.Fatal(err)
+	}
+	var saved SchedulerState
+	if ok, err := store.Load("scheduler", &saved); !ok || err != nil {
+		t.Fatal("saved state could not be loaded:", err)
+	}
+
+	// the config hasn't changed since the state was saved
+	if overrides := savedOverrides(configs(configure(defaultAlarm, weekend)), saved.Alarms); len(overrides) != 0 {
+		t.Errorf("expected no overrides, got %q", overrides)
+	}
+
+	// the default alarm has been edited in the config, another one added, and the weekend alarm removed
+	edited := defaultAlarm
+	edited.TriggerTime = "7:00"
+	office := AlarmConfig{ID: "office", Label: "Office", CoffeeTimerConfig: CoffeeTimerConfig{TriggerTime: "5:45", Brew: BrewLungo}}
+	overrides := savedOverrides(configs(configure(edited, office)), saved.Alarms)
+	expected := []string{
+		"Alarm 'Default' (default) from the saved state overrides the config",
+		"Alarm 'Office' (office) from the config is not in the saved state",
+		"Alarm 'Weekend' (weekend) is only in the saved state",
+	}
+	if len(overrides) != len(expected) {
+		t.Fatalf("expected %d overrides, got %q", len(expected), overrides)
+	}
+	for i, override := range overrides {
+		if !strings.HasPrefix(override, expected[i]) {
+			t.Errorf("expected %q, got %q", expected[i], override)
+		}
+	}
+}
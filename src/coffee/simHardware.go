@@ -0,0 +1,264 @@
+package coffee
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"periph.io/x/conn/v3/gpio"
+	"periph.io/x/conn/v3/i2c"
+	"periph.io/x/conn/v3/physic"
+	"periph.io/x/conn/v3/pin"
+)
+
+// simChangesKept limits how many pin changes the simulator remembers, so it can run for days
+const simChangesKept = 1000
+
+// PinChange is a level a simulated pin has been set to, by coffee pixie or by an injected button press
+type PinChange struct {
+	Time  time.Time
+	Pin   int
+	Level gpio.Level
+}
+
+func (c PinChange) String() string {
+	return fmt.Sprintf("%s GPIO%d %s", c.Time.Format("15:04:05.000"), c.Pin, c.Level)
+}
+
+// SimHardware is an in-memory backend which records every pin change, and on which tests can press buttons
+type SimHardware struct {
+	mu         sync.Mutex
+	pins       map[int]*simPin
+	changes    []PinChange
+	i2cDevices map[uint16]SimI2CDevice
+}
+
+// SimI2CDevice answers the transfers to its address on the simulated I2C bus, like i2c.Bus.Tx
+type SimI2CDevice func(w, r []byte) error
+
+func NewSimHardware() *SimHardware {
+	return &SimHardware{pins: map[int]*simPin{}, i2cDevices: map[uint16]SimI2CDevice{}}
+}
+
+func (s *SimHardware) Pin(n int) (gpio.PinIO, error) {
+	if n < 0 || n > 27 {
+		return nil, fmt.Errorf("no GPIO%d on a Raspberry Pi", n)
+	}
+	return s.pin(n), nil
+}
+
+func (s *SimHardware) pin(n int) *simPin {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.pins[n]
+	if !ok {
+		p = &simPin{hw: s, n: n, edges: make(chan gpio.Level, 16)}
+		s.pins[n] = p
+	}
+	return p
+}
+
+// I2C returns a bus on which the devices set with SetI2CDevice answer. Any other address accepts all writes and fails all reads.
+func (s *SimHardware) I2C(name string) (i2c.Bus, error) {
+	return simI2CBus{hw: s}, nil
+}
+
+// SetI2CDevice puts a simulated device at the given address of the I2C bus
+func (s *SimHardware) SetI2CDevice(addr uint16, dev SimI2CDevice) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.i2cDevices[addr] = dev
+}
+
+// Changes returns the pin changes recorded, oldest first
+func (s *SimHardware) Changes() []PinChange {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]PinChange(nil), s.changes...)
+}
+
+// Level returns the level a pin is at
+func (s *SimHardware) Level(n int) gpio.Level {
+	return s.pin(n).Read()
+}
+
+// SetLevel drives an input pin to the given level from outside, e.g. a cup sensor
+func (s *SimHardware) SetLevel(n int, l gpio.Level) {
+	s.pin(n).set(l, false)
+}
+
+// Press injects a press of a button pulling the pin High, which is held for the given duration before it is released
+func (s *SimHardware) Press(n int, hold time.Duration) {
+	s.SetLevel(n, gpio.High)
+	time.Sleep(hold)
+	s.SetLevel(n, gpio.Low)
+}
+
+func (s *SimHardware) record(n int, l gpio.Level) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.changes = append(s.changes, PinChange{Time: time.Now(), Pin: n, Level: l})
+	if len(s.changes) > simChangesKept {
+		s.changes = s.changes[len(s.changes)-simChangesKept:]
+	}
+}
+
+// simPin is a GPIO of the simulator, it delivers the edges it has been set up for to WaitForEdge
+type simPin struct {
+	hw    *SimHardware
+	n     int
+	edges chan gpio.Level
+
+	mu    sync.Mutex
+	level gpio.Level
+	pull  gpio.Pull
+	edge  gpio.Edge
+	out   bool
+}
+
+func (p *simPin) String() string   { return fmt.Sprintf("GPIO%d", p.n) }
+func (p *simPin) Halt() error      { return nil }
+func (p *simPin) Name() string     { return p.String() }
+func (p *simPin) Number() int      { return p.n }
+func (p *simPin) Function() string { return string(p.Func()) }
+
+func (p *simPin) Func() pin.Func {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.out {
+		return gpio.OUT
+	}
+	return gpio.IN
+}
+
+func (p *simPin) SupportedFuncs() []pin.Func {
+	return []pin.Func{gpio.IN, gpio.OUT}
+}
+
+func (p *simPin) SetFunc(f pin.Func) error {
+	switch f {
+	case gpio.IN:
+		return p.In(gpio.PullNoChange, gpio.NoEdge)
+	case gpio.OUT:
+		return p.Out(p.Read())
+	default:
+		return fmt.Errorf("%s does not support %s", p, f)
+	}
+}
+
+func (p *simPin) In(pull gpio.Pull, edge gpio.Edge) error {
+	p.mu.Lock()
+	p.out, p.edge = false, edge
+	if pull != gpio.PullNoChange {
+		p.pull = pull
+	}
+	level := p.level
+	switch p.pull {
+	case gpio.PullDown:
+		level = gpio.Low
+	case gpio.PullUp:
+		level = gpio.High
+	}
+	p.mu.Unlock()
+
+	// drop the edges seen before
+	for {
+		select {
+		case <-p.edges:
+		default:
+			p.set(level, false)
+			return nil
+		}
+	}
+}
+
+func (p *simPin) Read() gpio.Level {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.level
+}
+
+func (p *simPin) WaitForEdge(timeout time.Duration) bool {
+	if timeout < 0 {
+		<-p.edges
+		return true
+	}
+	select {
+	case <-p.edges:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+func (p *simPin) Pull() gpio.Pull {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.pull
+}
+
+func (p *simPin) DefaultPull() gpio.Pull {
+	return gpio.PullDown
+}
+
+func (p *simPin) Out(l gpio.Level) error {
+	p.mu.Lock()
+	p.out = true
+	p.mu.Unlock()
+
+	p.set(l, true)
+	return nil
+}
+
+func (p *simPin) PWM(duty gpio.Duty, f physic.Frequency) error {
+	return errors.New("PWM is not simulated")
+}
+
+// set changes the level, records the change, and delivers an edge if the pin waits for one.
+// Outputs are recorded each time they are driven, even to the level they are at.
+func (p *simPin) set(l gpio.Level, driven bool) {
+
+	p.mu.Lock()
+	if p.level == l && !driven {
+		p.mu.Unlock()
+		return
+	}
+	p.level = l
+	deliver := !p.out && (p.edge == gpio.BothEdges || (p.edge == gpio.RisingEdge && l == gpio.High) || (p.edge == gpio.FallingEdge && l == gpio.Low))
+	p.mu.Unlock()
+
+	p.hw.record(p.n, l)
+	if deliver {
+		select {
+		case p.edges <- l:
+		default:
+			log.Printf("Simulated GPIO%d dropped an edge, nobody is waiting for it\n", p.n)
+		}
+	}
+}
+
+// simI2CBus is the simulator's I2C bus, with the simulated devices and write-only ones like displays on it
+type simI2CBus struct {
+	hw *SimHardware
+}
+
+func (simI2CBus) String() string { return "simulated I2C" }
+
+func (b simI2CBus) Tx(addr uint16, w, r []byte) error {
+	b.hw.mu.Lock()
+	dev, ok := b.hw.i2cDevices[addr]
+	b.hw.mu.Unlock()
+	if ok {
+		return dev(w, r)
+	}
+	if len(r) > 0 {
+		return fmt.Errorf("reading from I2C device 0x%02x is not simulated", addr)
+	}
+	return nil
+}
+
+func (simI2CBus) SetSpeed(f physic.Frequency) error { return nil }
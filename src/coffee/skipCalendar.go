@@ -0,0 +1,404 @@
+package coffee
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type SkipCalendarConfig struct {
+	ICSFile string `yaml:"ics_file"`
+	// Keywords limit skipping to events whose summary or categories contain one of them (case insensitive).
+	// If empty, every event in the file is a skip day.
+	Keywords []string `yaml:"keywords,omitempty"`
+}
+
+// SkipEvent is a holiday or vacation during which no coffee is made, End is exclusive.
+// A recurring event is its first occurrence, with the rule the further ones follow.
+type SkipEvent struct {
+	Summary    string
+	Start, End time.Time
+	AllDay     bool
+	recurrence *icsRecurrence
+}
+
+func (e SkipEvent) contains(t time.Time) bool {
+	return !t.Before(e.Start) && t.Before(e.End)
+}
+
+// occurrenceAt returns the occurrence of the event that t falls into, ok is false if there is none
+func (e SkipEvent) occurrenceAt(t time.Time) (occurrence SkipEvent, ok bool) {
+
+	r := e.recurrence
+	if r == nil {
+		return e, e.contains(t)
+	}
+
+	n := 0
+	for i := 0; ; i++ {
+		o, valid := e.occurrence(i)
+		if o.Start.After(t) || (!r.until.IsZero() && o.Start.After(r.until)) {
+			return SkipEvent{}, false
+		}
+		if !valid {
+			continue
+		}
+		n++
+		if r.count > 0 && n > r.count {
+			return SkipEvent{}, false
+		}
+		if o.contains(t) {
+			return o, true
+		}
+	}
+}
+
+// occurrence returns the i-th occurrence of a recurring event, counting the first one as 0. valid is false
+// for a date that doesn't exist, like the 29th of February in a yearly rule, which is left out.
+func (e SkipEvent) occurrence(i int) (occurrence SkipEvent, valid bool) {
+
+	years, months, days := 0, 0, 0
+	step := i * e.recurrence.interval
+	switch e.recurrence.freq {
+	case "DAILY":
+		days = step
+	case "WEEKLY":
+		days = 7 * step
+	case "MONTHLY":
+		months = step
+	case "YEARLY":
+		years = step
+	}
+
+	// the calendar date moves, so the time of day stays the same across daylight saving changes
+	o := SkipEvent{Summary: e.Summary, AllDay: e.AllDay,
+		Start: e.Start.AddDate(years, months, days), End: e.End.AddDate(years, months, days)}
+	return o, days != 0 || o.Start.Day() == e.Start.Day()
+}
+
+// SkipCalendar holds the skip days imported from an .ics file
+type SkipCalendar struct {
+	mu     sync.Mutex
+	cfg    SkipCalendarConfig
+	events []SkipEvent
+}
+
+// LoadSkipCalendar imports the skip days from the configured .ics file
+func LoadSkipCalendar(cfg SkipCalendarConfig) (*SkipCalendar, error) {
+	c := &SkipCalendar{cfg: cfg}
+	return c, c.Reload()
+}
+
+// Reload imports the .ics file again, e.g. after it has been replaced with next year's holidays.
+// If the file cannot be read, the previously imported skip days are kept.
+func (c *SkipCalendar) Reload() error {
+
+	f, err := os.Open(c.cfg.ICSFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	events, err := ParseICS(f, c.cfg.Keywords)
+	if err != nil {
+		return fmt.Errorf("importing %s: %w", c.cfg.ICSFile, err)
+	}
+
+	c.mu.Lock()
+	c.events = events
+	c.mu.Unlock()
+
+	log.Printf("Imported %d skip events from %s\n", len(events), c.cfg.ICSFile)
+	return nil
+}
+
+// Skips returns the event that t falls into, ok is false if coffee can be made at t
+func (c *SkipCalendar) Skips(t time.Time) (event SkipEvent, ok bool) {
+
+	if c == nil {
+		return SkipEvent{}, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, e := range c.events {
+		if o, ok := e.occurrenceAt(t); ok {
+			return o, true
+		}
+	}
+	return SkipEvent{}, false
+}
+
+// ParseICS reads the VEVENTs of an iCalendar file. Only events matching one of the keywords are returned,
+// or all events if no keywords are given. Recurring events are expanded by their RRULE, see parseICSRecurrence,
+// events whose RRULE can't be expanded are logged and left out.
+func ParseICS(r io.Reader, keywords []string) ([]SkipEvent, error) {
+
+	lines, err := unfoldICSLines(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []SkipEvent
+	var props map[string]icsProperty
+	nested := 0 // depth of components inside the current event, e.g. VALARM, whose properties are ignored
+	for i, line := range lines {
+		name, prop, err := parseICSLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+
+		switch {
+		case props != nil && name == "BEGIN":
+			nested++
+		case nested > 0:
+			if name == "END" {
+				nested--
+			}
+		case name == "BEGIN" && prop.value == "VEVENT":
+			props = map[string]icsProperty{}
+		case name == "END" && prop.value == "VEVENT":
+			if props == nil {
+				return nil, fmt.Errorf("line %d: END:VEVENT without BEGIN:VEVENT", i+1)
+			}
+			event, err := icsEvent(props)
+			if err != nil {
+				return nil, fmt.Errorf("event ending in line %d: %w", i+1, err)
+			}
+			if matchesKeywords(props, keywords) {
+				if rrule, ok := props["RRULE"]; ok {
+					event.recurrence, err = parseICSRecurrence(rrule.value)
+				}
+				if err != nil {
+					// an event that can't be expanded must not keep the rest of the calendar from being skipped
+					log.Printf("Not skipping recurring event '%s' ending in line %d: %v\n", event.Summary, i+1, err)
+				} else {
+					events = append(events, event)
+				}
+			}
+			props = nil
+		case props != nil:
+			// repeated properties, like a second CATEGORIES line, are appended to the first one
+			if existing, ok := props[name]; ok {
+				existing.value += "," + prop.value
+				props[name] = existing
+			} else {
+				props[name] = prop
+			}
+		}
+	}
+
+	return events, nil
+}
+
+type icsProperty struct {
+	params map[string]string
+	value  string
+}
+
+// unfoldICSLines joins continuation lines, which start with a space or tab, to the line they continue
+func unfoldICSLines(r io.Reader) ([]string, error) {
+
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	return lines, scanner.Err()
+}
+
+// parseICSLine splits a content line like "DTSTART;TZID=Europe/Berlin:20231224T080000" into name, parameters and value
+func parseICSLine(line string) (string, icsProperty, error) {
+
+	colon := strings.Index(line, ":")
+	if colon < 0 {
+		return "", icsProperty{}, fmt.Errorf("missing ':' in '%s'", line)
+	}
+
+	fields := strings.Split(line[:colon], ";")
+	prop := icsProperty{params: map[string]string{}, value: line[colon+1:]}
+	for _, param := range fields[1:] {
+		if kv := strings.SplitN(param, "=", 2); len(kv) == 2 {
+			prop.params[strings.ToUpper(kv[0])] = strings.Trim(kv[1], `"`)
+		}
+	}
+
+	return strings.ToUpper(fields[0]), prop, nil
+}
+
+func icsEvent(props map[string]icsProperty) (SkipEvent, error) {
+
+	dtstart, ok := props["DTSTART"]
+	if !ok {
+		return SkipEvent{}, fmt.Errorf("missing DTSTART")
+	}
+	start, allDay, err := parseICSTime(dtstart)
+	if err != nil {
+		return SkipEvent{}, err
+	}
+
+	event := SkipEvent{Summary: unescapeICSText(props["SUMMARY"].value), Start: start, AllDay: allDay}
+
+	if dtend, ok := props["DTEND"]; ok {
+		event.End, _, err = parseICSTime(dtend)
+		if err != nil {
+			return SkipEvent{}, err
+		}
+	} else if duration, ok := props["DURATION"]; ok {
+		event.End, err = addICSDuration(start, duration.value)
+		if err != nil {
+			return SkipEvent{}, err
+		}
+	} else if allDay {
+		// an all-day event without an end lasts that one day
+		event.End = start.AddDate(0, 0, 1)
+	} else {
+		event.End = start
+	}
+
+	return event, nil
+}
+
+// icsRecurrence is the RRULE of a recurring event
+type icsRecurrence struct {
+	freq     string // DAILY, WEEKLY, MONTHLY or YEARLY
+	interval int
+	count    int       // how many times the event occurs, 0 for no limit
+	until    time.Time // the last occurrence starts no later than this, zero for no limit
+}
+
+// parseICSRecurrence understands rules like "FREQ=YEARLY", "FREQ=WEEKLY;INTERVAL=2;COUNT=10" or "FREQ=DAILY;UNTIL=20241231".
+// Rules picking days by BYDAY, BYMONTH and the like are refused rather than skipping the wrong days.
+func parseICSRecurrence(rrule string) (*icsRecurrence, error) {
+
+	r := &icsRecurrence{interval: 1}
+	for _, part := range strings.Split(rrule, ";") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("unexpected RRULE part '%s'", part)
+		}
+		name, value := strings.ToUpper(kv[0]), strings.ToUpper(kv[1])
+
+		var err error
+		switch name {
+		case "FREQ":
+			switch value {
+			case "DAILY", "WEEKLY", "MONTHLY", "YEARLY":
+				r.freq = value
+			default:
+				return nil, fmt.Errorf("unsupported RRULE frequency '%s', expected DAILY, WEEKLY, MONTHLY or YEARLY", value)
+			}
+		case "INTERVAL":
+			r.interval, err = strconv.Atoi(value)
+			if err != nil || r.interval < 1 {
+				return nil, fmt.Errorf("unexpected RRULE interval '%s'", value)
+			}
+		case "COUNT":
+			r.count, err = strconv.Atoi(value)
+			if err != nil || r.count < 1 {
+				return nil, fmt.Errorf("unexpected RRULE count '%s'", value)
+			}
+		case "UNTIL":
+			r.until, _, err = parseICSTime(icsProperty{value: value})
+			if err != nil {
+				return nil, fmt.Errorf("unexpected RRULE until '%s'", value)
+			}
+		case "WKST":
+			// only matters for rules picking weekdays
+		default:
+			return nil, fmt.Errorf("unsupported RRULE part %s", name)
+		}
+	}
+
+	if r.freq == "" {
+		return nil, fmt.Errorf("RRULE '%s' without FREQ", rrule)
+	}
+	return r, nil
+}
+
+// parseICSTime understands dates (all-day), UTC times, times with a TZID and floating times, which are taken as local time
+func parseICSTime(prop icsProperty) (time.Time, bool, error) {
+
+	if prop.params["VALUE"] == "DATE" || len(prop.value) == len("20060102") {
+		t, err := time.ParseInLocation("20060102", prop.value, time.Local)
+		return t, true, err
+	}
+
+	if strings.HasSuffix(prop.value, "Z") {
+		t, err := time.Parse("20060102T150405Z", prop.value)
+		return t, false, err
+	}
+
+	loc := time.Local
+	if tzid, ok := prop.params["TZID"]; ok {
+		var err error
+		loc, err = time.LoadLocation(tzid)
+		if err != nil {
+			log.Printf("Unknown time zone '%s' in calendar, using local time instead\n", tzid)
+			loc = time.Local
+		}
+	}
+
+	t, err := time.ParseInLocation("20060102T150405", prop.value, loc)
+	return t, false, err
+}
+
+var icsDurationRegexp = regexp.MustCompile(`^([+-])?P(?:(\d+)W)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?)?$`)
+
+// addICSDuration adds an iCalendar duration like "P1D" or "PT2H30M" to t, days are calendar days
+func addICSDuration(t time.Time, duration string) (time.Time, error) {
+
+	m := icsDurationRegexp.FindStringSubmatch(duration)
+	if m == nil {
+		return time.Time{}, fmt.Errorf("unexpected duration '%s'", duration)
+	}
+
+	n := make([]int, 5)
+	for i := 2; i < len(m); i++ {
+		if m[i] != "" {
+			n[i-2], _ = strconv.Atoi(m[i])
+		}
+	}
+	sign := 1
+	if m[1] == "-" {
+		sign = -1
+	}
+
+	t = t.AddDate(0, 0, sign*(7*n[0]+n[1]))
+	return t.Add(time.Duration(sign) * (time.Duration(n[2])*time.Hour + time.Duration(n[3])*time.Minute + time.Duration(n[4])*time.Second)), nil
+}
+
+func matchesKeywords(props map[string]icsProperty, keywords []string) bool {
+
+	if len(keywords) == 0 {
+		return true
+	}
+
+	text := strings.ToLower(unescapeICSText(props["SUMMARY"].value) + " " + unescapeICSText(props["CATEGORIES"].value))
+	for _, k := range keywords {
+		if strings.Contains(text, strings.ToLower(k)) {
+			return true
+		}
+	}
+	return false
+}
+
+func unescapeICSText(s string) string {
+	return strings.NewReplacer(`\n`, " ", `\N`, " ", `\,`, ",", `\;`, ";", `\\`, `\`).Replace(s)
+}
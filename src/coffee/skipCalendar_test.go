@@ -0,0 +1,166 @@
+package coffee
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const testICS = `BEGIN:VCALENDAR
+VERSION:2.0
+BEGIN:VEVENT
+SUMMARY:Public holiday: New Year
+DTSTART;VALUE=DATE:20230102
+DTEND;VALUE=DATE:20230103
+END:VEVENT
+BEGIN:VEVENT
+SUMMARY:Vacation in
+  the mountains
+DTSTART;TZID=Europe/Berlin:20230104T000000
+DURATION:P2D
+BEGIN:VALARM
+ACTION:DISPLAY
+SUMMARY:Pack your bags
+TRIGGER:-P1D
+END:VALARM
+END:VEVENT
+BEGIN:VEVENT
+SUMMARY:Dentist
+DTSTART:20230109T050000Z
+DTEND:20230109T060000Z
+END:VEVENT
+END:VCALENDAR
+`
+
+func TestParseICS(t *testing.T) {
+
+	events, err := ParseICS(strings.NewReader(testICS), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 3 {
+		t.Fatalf("expected 3 events, got %d", len(events))
+	}
+
+	if !events[0].AllDay || !events[0].End.Equal(events[0].Start.AddDate(0, 0, 1)) {
+		t.Fatal("all-day event not parsed as a single day:", events[0])
+	}
+
+	berlin, _ := time.LoadLocation("Europe/Berlin")
+	if events[1].Summary != "Vacation in the mountains" || !events[1].End.Equal(time.Date(2023, 1, 6, 0, 0, 0, 0, berlin)) {
+		t.Fatal("folded summary, duration or nested alarm not handled:", events[1])
+	}
+
+	events, err = ParseICS(strings.NewReader(testICS), []string{"holiday", "VACATION"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events matching the keywords, got %d", len(events))
+	}
+
+}
+
+func TestTimerSkipsCalendarEvents(t *testing.T) {
+
+	events, err := ParseICS(strings.NewReader(testICS), []string{"holiday", "vacation"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Sunday evening before the Monday holiday
+	clock := newFakeClock(time.Date(2023, 1, 1, 20, 0, 0, 0, time.Local))
+	ct := NewCoffeeTimer(CoffeeTimerConfig{TriggerTime: "6:45", Brew: BrewLungo}, newTestRaspi(), clock)
+	ct.SetSkipCalendar(&SkipCalendar{events: events})
+
+	triggerTime, _, ok := ct.next(clock.Now())
+	if !ok || !triggerTime.Equal(time.Date(2023, 1, 3, 6, 45, 0, 0, time.Local)) {
+		t.Fatal("expected the holiday to be skipped, next trigger is", triggerTime)
+	}
+
+	// the holiday on Monday and the vacation on Wednesday and Thursday, but not the dentist on the following Monday
+	skipped := ct.SkippedTriggers(clock.Now().AddDate(0, 0, 10))
+	if len(skipped) != 3 || skipped[0].Event.Summary != "Public holiday: New Year" || skipped[2].Time.Day() != 5 {
+		t.Fatal("expected the holiday and the vacation days to be listed as skipped, got", skipped)
+	}
+
+}
+
+const testRecurringICS = `BEGIN:VCALENDAR
+BEGIN:VEVENT
+SUMMARY:Christmas Day
+DTSTART;VALUE=DATE:20221225
+RRULE:FREQ=YEARLY
+END:VEVENT
+BEGIN:VEVENT
+SUMMARY:Leap day
+DTSTART;VALUE=DATE:20240229
+RRULE:FREQ=YEARLY;COUNT=2
+END:VEVENT
+BEGIN:VEVENT
+SUMMARY:Team breakfast
+DTSTART:20230103T050000Z
+DTEND:20230103T070000Z
+RRULE:FREQ=WEEKLY;INTERVAL=2;UNTIL=20230131T050000Z
+END:VEVENT
+BEGIN:VEVENT
+SUMMARY:Fasting
+DTSTART;VALUE=DATE:20230301
+RRULE:FREQ=DAILY;COUNT=3
+END:VEVENT
+END:VCALENDAR
+`
+
+func TestSkipCalendarRecurringEvents(t *testing.T) {
+
+	events, err := ParseICS(strings.NewReader(testRecurringICS), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &SkipCalendar{events: events}
+
+	tests := []struct {
+		t        time.Time
+		expected string
+	}{
+		{time.Date(2022, 12, 25, 6, 45, 0, 0, time.Local), "Christmas Day"},
+		{time.Date(2023, 12, 25, 6, 45, 0, 0, time.Local), "Christmas Day"}, // the second occurrence
+		{time.Date(2031, 12, 25, 23, 59, 0, 0, time.Local), "Christmas Day"},
+		{time.Date(2023, 12, 26, 6, 45, 0, 0, time.Local), ""},
+		{time.Date(2024, 2, 29, 6, 45, 0, 0, time.Local), "Leap day"},
+		{time.Date(2025, 3, 1, 6, 45, 0, 0, time.Local), ""}, // no leap day in 2025
+		{time.Date(2028, 2, 29, 6, 45, 0, 0, time.Local), "Leap day"},
+		{time.Date(2032, 2, 29, 6, 45, 0, 0, time.Local), ""}, // after COUNT
+		{time.Date(2023, 1, 17, 5, 30, 0, 0, time.UTC), "Team breakfast"},
+		{time.Date(2023, 1, 10, 5, 30, 0, 0, time.UTC), ""}, // the week in between
+		{time.Date(2023, 1, 31, 5, 30, 0, 0, time.UTC), "Team breakfast"},
+		{time.Date(2023, 2, 14, 5, 30, 0, 0, time.UTC), ""}, // after UNTIL
+		{time.Date(2023, 3, 3, 6, 45, 0, 0, time.Local), "Fasting"},
+		{time.Date(2023, 3, 4, 6, 45, 0, 0, time.Local), ""},
+	}
+	for _, test := range tests {
+		event, ok := c.Skips(test.t)
+		if ok != (test.expected != "") || event.Summary != test.expected {
+			t.Errorf("expected %s to be skipped for '%s', got '%s' (%t)", test.t, test.expected, event.Summary, ok)
+		}
+	}
+
+	// the occurrence is reported, not the first one
+	if event, _ := c.Skips(time.Date(2023, 12, 25, 6, 45, 0, 0, time.Local)); event.Start.Year() != 2023 {
+		t.Errorf("expected the 2023 occurrence, got %v", event.Start)
+	}
+
+	// an event that can't be expanded is left out, the others are still imported
+	for _, rrule := range []string{"FREQ=YEARLY;BYMONTH=5;BYDAY=2SU", "FREQ=HOURLY", "INTERVAL=2", "FREQ=DAILY;COUNT=0"} {
+		ics := "BEGIN:VEVENT\nSUMMARY:Odd\nDTSTART;VALUE=DATE:20230501\nRRULE:" + rrule + "\nEND:VEVENT\n" +
+			"BEGIN:VEVENT\nSUMMARY:Even\nDTSTART;VALUE=DATE:20230502\nEND:VEVENT\n"
+		events, err := ParseICS(strings.NewReader(ics), nil)
+		if err != nil {
+			t.Errorf("RRULE %s: expected the other events to be imported, got %v", rrule, err)
+			continue
+		}
+		if len(events) != 1 || events[0].Summary != "Even" {
+			t.Errorf("RRULE %s: expected only the event without it, got %v", rrule, events)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package coffee
+
+import (
+	"fmt"
+	"log"
+
+	"periph.io/x/conn/v3/i2c"
+)
+
+const (
+	ssd1306Width       = 128
+	ssd1306Height      = 64
+	ssd1306DefaultAddr = 0x3c
+	ssd1306Command     = 0x00 // control byte followed by commands
+	ssd1306Data        = 0x40 // control byte followed by display RAM data
+	ssd1306Chunk       = 32   // bytes of display RAM written per I2C transfer
+	fontWidth          = 6    // 5 columns of the glyph and 1 of space
+)
+
+// ssd1306Init sets up a 128x64 panel with its charge pump, addressed page by page from the top left
+var ssd1306Init = []byte{
+	0xae,       // display off
+	0xd5, 0x80, // clock divider
+	0xa8, 0x3f, // multiplex ratio: 64 lines
+	0xd3, 0x00, // no display offset
+	0x40,       // start line 0
+	0x8d, 0x14, // charge pump on
+	0x20, 0x00, // horizontal addressing
+	0xa1,       // segment remap, column 127 is SEG0
+	0xc8,       // scan from COM63 to COM0
+	0xda, 0x12, // COM pins for 128x64
+	0x81, 0xcf, // contrast
+	0xd9, 0xf1, // pre-charge period
+	0xdb, 0x40, // VCOMH deselect level
+	0xa4, // show the RAM content
+	0xa6, // not inverted
+	0xaf, // display on
+}
+
+// ssd1306 drives a 128x64 OLED, showing text in rows of 8 pixels
+type ssd1306 struct {
+	dev *i2c.Dev
+}
+
+func newSSD1306(bus i2c.Bus, addr uint16) (*ssd1306, error) {
+
+	if addr == 0 {
+		addr = ssd1306DefaultAddr
+	}
+	d := &ssd1306{dev: &i2c.Dev{Bus: bus, Addr: addr}}
+	if err := d.dev.Tx(append([]byte{ssd1306Command}, ssd1306Init...), nil); err != nil {
+		return nil, fmt.Errorf("initialising SSD1306 at 0x%02x: %w", addr, err)
+	}
+
+	log.Printf("Showing the status on an SSD1306 at 0x%02x on %s\n", addr, bus)
+	return d, nil
+}
+
+func (d *ssd1306) Size() (cols, rows int) {
+	return ssd1306Width / fontWidth, ssd1306Height / 8
+}
+
+func (d *ssd1306) Show(lines []string) error {
+
+	frame := renderText(lines, ssd1306Width, ssd1306Height)
+
+	// the whole display RAM, column 0 to 127 of page 0 to 7
+	if err := d.dev.Tx([]byte{ssd1306Command, 0x21, 0, ssd1306Width - 1, 0x22, 0, ssd1306Height/8 - 1}, nil); err != nil {
+		return err
+	}
+	for i := 0; i < len(frame); i += ssd1306Chunk {
+		if err := d.dev.Tx(append([]byte{ssd1306Data}, frame[i:i+ssd1306Chunk]...), nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// renderText draws the lines into a frame buffer laid out like the SSD1306 RAM: a byte per column of 8 pixels,
+// the least significant bit at the top, one page of 8 pixel rows after the other. Each line takes one page.
+func renderText(lines []string, width, height int) []byte {
+
+	frame := make([]byte, width*height/8)
+	cols := width / fontWidth
+	for page := 0; page < height/8 && page < len(lines); page++ {
+		for col, c := range []byte(fitLine(lines[page], cols)) {
+			glyph := font5x7[c-' ']
+			copy(frame[page*width+col*fontWidth:], glyph[:])
+		}
+	}
+	return frame
+}
+
+// font5x7 are the printable ASCII characters from ' ' to '~', each a column of 7 pixels for 5 columns
+var font5x7 = [...][5]byte{
+	{0x00, 0x00, 0x00, 0x00, 0x00}, // ' '
+	{0x00, 0x00, 0x5f, 0x00, 0x00}, // !
+	{0x00, 0x07, 0x00, 0x07, 0x00}, // "
+	{0x14, 0x7f, 0x14, 0x7f, 0x14}, // #
+	{0x24, 0x2a, 0x7f, 0x2a, 0x12}, // $
+	{0x23, 0x13, 0x08, 0x64, 0x62}, // %
+	{0x36, 0x49, 0x55, 0x22, 0x50}, // &
+	{0x00, 0x05, 0x03, 0x00, 0x00}, // '
+	{0x00, 0x1c, 0x22, 0x41, 0x00}, // (
+	{0x00, 0x41, 0x22, 0x1c, 0x00}, // )
+	{0x14, 0x08, 0x3e, 0x08, 0x14}, // *
+	{0x08, 0x08, 0x3e, 0x08, 0x08}, // +
+	{0x00, 0x50, 0x30, 0x00, 0x00}, // ,
+	{0x08, 0x08, 0x08, 0x08, 0x08}, // -
+	{0x00, 0x60, 0x60, 0x00, 0x00}, // .
+	{0x20, 0x10, 0x08, 0x04, 0x02}, // /
+	{0x3e, 0x51, 0x49, 0x45, 0x3e}, // 0
+	{0x00, 0x42, 0x7f, 0x40, 0x00}, // 1
+	{0x42, 0x61, 0x51, 0x49, 0x46}, // 2
+	{0x21, 0x41, 0x45, 0x4b, 0x31}, // 3
+	{0x18, 0x14, 0x12, 0x7f, 0x10}, // 4
+	{0x27, 0x45, 0x45, 0x45, 0x39}, // 5
+	{0x3c, 0x4a, 0x49, 0x49, 0x30}, // 6
+	{0x01, 0x71, 0x09, 0x05, 0x03}, // 7
+	{0x36, 0x49, 0x49, 0x49, 0x36}, // 8
+	{0x06, 0x49, 0x49, 0x29, 0x1e}, // 9
+	{0x00, 0x36, 0x36, 0x00, 0x00}, // :
+	{0x00, 0x56, 0x36, 0x00, 0x00}, // ;
+	{0x08, 0x14, 0x22, 0x41, 0x00}, // <
+	{0x14, 0x14, 0x14, 0x14, 0x14}, // =
+	{0x00, 0x41, 0x22, 0x14, 0x08}, // >
+	{0x02, 0x01, 0x51, 0x09, 0x06}, // ?
+	{0x32, 0x49, 0x79, 0x41, 0x3e}, // @
+	{0x7e, 0x11, 0x11, 0x11, 0x7e}, // A
+	{0x7f, 0x49, 0x49, 0x49, 0x36}, // B
+	{0x3e, 0x41, 0x41, 0x41, 0x22}, // C
+	{0x7f, 0x41, 0x41, 0x22, 0x1c}, // D
+	{0x7f, 0x49, 0x49, 0x49, 0x41}, // E
+	{0x7f, 0x09, 0x09, 0x09, 0x01}, // F
+	{0x3e, 0x41, 0x49, 0x49, 0x7a}, // G
+	{0x7f, 0x08, 0x08, 0x08, 0x7f}, // H
+	{0x00, 0x41, 0x7f, 0x41, 0x00}, // I
+	{0x20, 0x40, 0x41, 0x3f, 0x01}, // J
+	{0x7f, 0x08, 0x14, 0x22, 0x41}, // K
+	{0x7f, 0x40, 0x40, 0x40, 0x40}, // L
+	{0x7f, 0x02, 0x0c, 0x02, 0x7f}, // M
+	{0x7f, 0x04, 0x08, 0x10, 0x7f}, // N
+	{0x3e, 0x41, 0x41, 0x41, 0x3e}, // O
+	{0x7f, 0x09, 0x09, 0x09, 0x06}, // P
+	{0x3e, 0x41, 0x51, 0x21, 0x5e}, // Q
+	{0x7f, 0x09, 0x19, 0x29, 0x46}, // R
+	{0x46, 0x49, 0x49, 0x49, 0x31}, // S
+	{0x01, 0x01, 0x7f, 0x01, 0x01}, // T
+	{0x3f, 0x40, 0x40, 0x40, 0x3f}, // U
+	{0x1f, 0x20, 0x40, 0x20, 0x1f}, // V
+	{0x3f, 0x40, 0x38, 0x40, 0x3f}, // W
+	{0x63, 0x14, 0x08, 0x14, 0x63}, // X
+	{0x07, 0x08, 0x70, 0x08, 0x07}, // Y
+	{0x61, 0x51, 0x49, 0x45, 0x43}, // Z
+	{0x00, 0x7f, 0x41, 0x41, 0x00}, // [
+	{0x02, 0x04, 0x08, 0x10, 0x20}, // \
+	{0x00, 0x41, 0x41, 0x7f, 0x00}, // ]
+	{0x04, 0x02, 0x01, 0x02, 0x04}, // ^
+	{0x40, 0x40, 0x40, 0x40, 0x40}, // _
+	{0x00, 0x01, 0x02, 0x04, 0x00}, // `
+	{0x20, 0x54, 0x54, 0x54, 0x78}, // a
+	{0x7f, 0x48, 0x44, 0x44, 0x38}, // b
+	{0x38, 0x44, 0x44, 0x44, 0x20}, // c
+	{0x38, 0x44, 0x44, 0x48, 0x7f}, // d
+	{0x38, 0x54, 0x54, 0x54, 0x18}, // e
+	{0x08, 0x7e, 0x09, 0x01, 0x02}, // f
+	{0x0c, 0x52, 0x52, 0x52, 0x3e}, // g
+	{0x7f, 0x08, 0x04, 0x04, 0x78}, // h
+	{0x00, 0x44, 0x7d, 0x40, 0x00}, // i
+	{0x20, 0x40, 0x44, 0x3d, 0x00}, // j
+	{0x7f, 0x10, 0x28, 0x44, 0x00}, // k
+	{0x00, 0x41, 0x7f, 0x40, 0x00}, // l
+	{0x7c, 0x04, 0x18, 0x04, 0x78}, // m
+	{0x7c, 0x08, 0x04, 0x04, 0x78}, // n
+	{0x38, 0x44, 0x44, 0x44, 0x38}, // o
+	{0x7c, 0x14, 0x14, 0x14, 0x08}, // p
+	{0x08, 0x14, 0x14, 0x18, 0x7c}, // q
+	{0x7c, 0x08, 0x04, 0x04, 0x08}, // r
+	{0x48, 0x54, 0x54, 0x54, 0x20}, // s
+	{0x04, 0x3f, 0x44, 0x40, 0x20}, // t
+	{0x3c, 0x40, 0x40, 0x20, 0x7c}, // u
+	{0x1c, 0x20, 0x40, 0x20, 0x1c}, // v
+	{0x3c, 0x40, 0x30, 0x40, 0x3c}, // w
+	{0x44, 0x28, 0x10, 0x28, 0x44}, // x
+	{0x0c, 0x50, 0x50, 0x50, 0x3c}, // y
+	{0x44, 0x64, 0x54, 0x4c, 0x44}, // z
+	{0x00, 0x08, 0x36, 0x41, 0x00}, // {
+	{0x00, 0x00, 0x7f, 0x00, 0x00}, // |
+	{0x00, 0x41, 0x36, 0x08, 0x00}, // }
+	{0x08, 0x04, 0x08, 0x10, 0x08}, // ~
+}
@@ -0,0 +1,113 @@
+package coffee
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+type StateStoreConfig struct {
+	Dir string `yaml:"dir"`
+}
+
+var StateStoreConfigDefaults = StateStoreConfig{
+	Dir: "state",
+}
+
+// StateStore keeps state that has to survive a restart as JSON files in the configured state directory, one file per name
+type StateStore struct {
+	dir string
+}
+
+func NewStateStore(cfg StateStoreConfig) (*StateStore, error) {
+
+	dir := cfg.Dir
+	if dir == "" {
+		dir = StateStoreConfigDefaults.Dir
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("creating state directory: %w", err)
+	}
+
+	log.Println("Keeping state in", dir)
+	return &StateStore{dir: dir}, nil
+}
+
+// Load reads the state saved under name into v. ok is false if nothing has been saved yet.
+func (st *StateStore) Load(name string, v interface{}) (ok bool, err error) {
+
+	data, err := os.ReadFile(st.path(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		return false, fmt.Errorf("reading state '%s': %w", name, err)
+	}
+
+	return true, nil
+}
+
+// Save writes v under name. The file is replaced atomically, so a power cut never leaves half a state file behind.
+func (st *StateStore) Save(name string, v interface{}) error {
+
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(st.dir, name+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), st.path(name))
+}
+
+// Autosave returns a func that requests saving the state returned by getState under name.
+// Saving happens on a background goroutine, so the func may be called while holding locks.
+// Requests made while a save is still pending are merged into one.
+func (st *StateStore) Autosave(name string, getState func() interface{}) func() {
+
+	requests := make(chan struct{}, 1)
+
+	go func() {
+		for range requests {
+			if err := st.Save(name, getState()); err != nil {
+				log.Printf("Error saving state '%s': %v\n", name, err)
+			}
+		}
+	}()
+
+	return func() {
+		select {
+		case requests <- struct{}{}:
+		default:
+			// a save is already pending and will pick up this change too
+		}
+	}
+}
+
+func (st *StateStore) path(name string) string {
+	return filepath.Join(st.dir, name+".json")
+}
@@ -0,0 +1,123 @@
+package coffee
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testState struct {
+	Count int       `json:"count"`
+	Since time.Time `json:"since"`
+}
+
+func TestStateStoreRoundTrip(t *testing.T) {
+
+	dir := filepath.Join(t.TempDir(), "state")
+	store, err := NewStateStore(StateStoreConfig{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got testState
+	if ok, err := store.Load("test", &got); ok || err != nil {
+		t.Fatalf("expected nothing to be saved yet, got %t, %v", ok, err)
+	}
+
+	for _, saved := range []testState{{Count: 1, Since: testStart}, {Count: 2, Since: testStart.Add(time.Hour)}} {
+		if err := store.Save("test", saved); err != nil {
+			t.Fatal(err)
+		}
+		if ok, err := store.Load("test", &got); !ok || err != nil {
+			t.Fatalf("saved state could not be loaded: %t, %v", ok, err)
+		}
+		if got.Count != saved.Count || !got.Since.Equal(saved.Since) {
+			t.Errorf("expected %+v, got %+v", saved, got)
+		}
+	}
+
+	// the file is replaced, no temporary files are left behind
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "test.json" {
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		t.Errorf("expected only test.json, got %v", names)
+	}
+}
+
+func TestStateStoreCorruptFile(t *testing.T) {
+
+	store, err := NewStateStore(StateStoreConfig{Dir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// e.g. cut short by a power cut before saving was atomic
+	if err := os.WriteFile(store.path("test"), []byte(`{"count": 3, "si`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var got testState
+	if ok, err := store.Load("test", &got); ok || err == nil {
+		t.Errorf("expected a corrupt file to be reported, got %t, %v", ok, err)
+	}
+
+	// saving again replaces the corrupt file
+	if err := store.Save("test", testState{Count: 4}); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := store.Load("test", &got); !ok || err != nil || got.Count != 4 {
+		t.Errorf("expected the new state, got %+v, %t, %v", got, ok, err)
+	}
+}
+
+func TestStateStoreAutosaveMergesRequests(t *testing.T) {
+
+	store, err := NewStateStore(StateStoreConfig{Dir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	calls := make(chan int)
+	release := make(chan struct{})
+	count := 0
+	save := store.Autosave("test", func() interface{} {
+		count++
+		calls <- count
+		<-release
+		return testState{Count: count}
+	})
+
+	// the first save is held up, the requests made meanwhile are merged into one
+	save()
+	<-calls
+	for i := 0; i < 10; i++ {
+		save()
+	}
+	release <- struct{}{}
+	if n := <-calls; n != 2 {
+		t.Fatalf("expected the second save, got save %d", n)
+	}
+	release <- struct{}{}
+
+	select {
+	case n := <-calls:
+		t.Fatalf("expected the requests to be merged, got save %d", n)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// the last save has been written
+	var got testState
+	for deadline := time.Now().Add(time.Second); got.Count != 2; {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the second save to be written, got %+v", got)
+		}
+		time.Sleep(time.Millisecond)
+		store.Load("test", &got)
+	}
+}
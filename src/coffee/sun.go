@@ -0,0 +1,131 @@
+package coffee
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// GeoLocation is where the coffee machine is, for working out sunrise and sunset. Longitude is positive east of Greenwich.
+type GeoLocation struct {
+	Latitude  float64 `yaml:"latitude" json:"latitude"`
+	Longitude float64 `yaml:"longitude" json:"longitude"`
+}
+
+const (
+	SunEventSunrise = "sunrise"
+	SunEventSunset  = "sunset"
+)
+
+// sunTrigger fires at an offset from sunrise or sunset, worked out for each day anew
+type sunTrigger struct {
+	event  string
+	offset time.Duration
+}
+
+var sunTriggerRegexp = regexp.MustCompile(`^(sunrise|sunset)\s*(?:([+-])\s*(\S+))?$`)
+
+// isSunTrigger tells whether a trigger time like "sunrise-20m" is relative to sunrise or sunset
+func isSunTrigger(expr string) bool {
+	expr = strings.ToLower(strings.TrimSpace(expr))
+	return strings.HasPrefix(expr, SunEventSunrise) || strings.HasPrefix(expr, SunEventSunset)
+}
+
+// parseSunTrigger parses "sunrise", "sunrise-20m" or "sunset+1h15m"
+func parseSunTrigger(expr string) (*sunTrigger, error) {
+
+	m := sunTriggerRegexp.FindStringSubmatch(strings.ToLower(strings.TrimSpace(expr)))
+	if m == nil {
+		return nil, fmt.Errorf("unexpected sun trigger '%s', expected e.g. 'sunrise-20m' or 'sunset+1h'", expr)
+	}
+
+	st := sunTrigger{event: m[1]}
+	if m[3] != "" {
+		offset, err := time.ParseDuration(m[3])
+		if err != nil {
+			return nil, fmt.Errorf("unexpected offset in sun trigger '%s': %w", expr, err)
+		}
+		if m[2] == "-" {
+			offset = -offset
+		}
+		st.offset = offset
+	}
+
+	return &st, nil
+}
+
+func (st *sunTrigger) String() string {
+	switch {
+	case st.offset > 0:
+		return fmt.Sprintf("%s+%s", st.event, formatOffset(st.offset))
+	case st.offset < 0:
+		return fmt.Sprintf("%s-%s", st.event, formatOffset(-st.offset))
+	default:
+		return st.event
+	}
+}
+
+// formatOffset drops the zero units time.Duration adds, i.e. "20m" instead of "20m0s"
+func formatOffset(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
+
+// at returns the trigger time on the given day in loc, ok is false if the sun does not rise or set that day
+func (st *sunTrigger) at(year int, month time.Month, day int, geo GeoLocation, loc *time.Location) (time.Time, bool) {
+
+	sunrise, sunset, ok := sunriseSunset(year, month, day, geo)
+	if !ok {
+		return time.Time{}, false
+	}
+
+	t := sunrise
+	if st.event == SunEventSunset {
+		t = sunset
+	}
+	return t.Add(st.offset).In(loc).Truncate(time.Second), true
+}
+
+// sunriseSunset works out sunrise and sunset on the given day with the NOAA solar equations, accurate to a minute or two.
+// ok is false during polar day or polar night.
+func sunriseSunset(year int, month time.Month, day int, geo GeoLocation) (sunrise, sunset time.Time, ok bool) {
+
+	midnight := time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	daysInYear := 365.0
+	if time.Date(year, time.December, 31, 0, 0, 0, 0, time.UTC).YearDay() == 366 {
+		daysInYear = 366
+	}
+
+	// fractional year at noon, in radians
+	gamma := 2 * math.Pi / daysInYear * (float64(midnight.YearDay()-1) + 0.5)
+
+	// equation of time in minutes, and solar declination in radians
+	eqTime := 229.18 * (0.000075 + 0.001868*math.Cos(gamma) - 0.032077*math.Sin(gamma) -
+		0.014615*math.Cos(2*gamma) - 0.040849*math.Sin(2*gamma))
+	decl := 0.006918 - 0.399912*math.Cos(gamma) + 0.070257*math.Sin(gamma) -
+		0.006758*math.Cos(2*gamma) + 0.000907*math.Sin(2*gamma) -
+		0.002697*math.Cos(3*gamma) + 0.00148*math.Sin(3*gamma)
+
+	// hour angle of the sun at sunrise, with the zenith at 90.833° allowing for refraction and the size of the sun's disc
+	lat := geo.Latitude * math.Pi / 180
+	cosHourAngle := math.Cos(90.833*math.Pi/180)/(math.Cos(lat)*math.Cos(decl)) - math.Tan(lat)*math.Tan(decl)
+	if cosHourAngle < -1 || cosHourAngle > 1 {
+		return time.Time{}, time.Time{}, false
+	}
+	hourAngle := math.Acos(cosHourAngle) * 180 / math.Pi
+
+	sunriseMinutes := 720 - 4*(geo.Longitude+hourAngle) - eqTime
+	sunsetMinutes := 720 - 4*(geo.Longitude-hourAngle) - eqTime
+
+	sunrise = midnight.Add(time.Duration(sunriseMinutes * float64(time.Minute)))
+	sunset = midnight.Add(time.Duration(sunsetMinutes * float64(time.Minute)))
+	return sunrise, sunset, true
+}
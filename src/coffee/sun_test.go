@@ -0,0 +1,170 @@
+package coffee
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+var berlinGeo = GeoLocation{Latitude: 52.52, Longitude: 13.405}
+
+func TestSunriseSunset(t *testing.T) {
+
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name            string
+		geo             GeoLocation
+		year            int
+		month           time.Month
+		day             int
+		sunrise, sunset time.Time
+	}{
+		{"Berlin midsummer", berlinGeo, 2023, time.June, 21,
+			time.Date(2023, time.June, 21, 4, 43, 0, 0, berlin), time.Date(2023, time.June, 21, 21, 33, 0, 0, berlin)},
+		{"Berlin midwinter", berlinGeo, 2023, time.December, 21,
+			time.Date(2023, time.December, 21, 8, 15, 0, 0, berlin), time.Date(2023, time.December, 21, 15, 54, 0, 0, berlin)},
+	}
+
+	for _, tt := range tests {
+		sunrise, sunset, ok := sunriseSunset(tt.year, tt.month, tt.day, tt.geo)
+		if !ok {
+			t.Errorf("%s: expected sunrise and sunset", tt.name)
+			continue
+		}
+		if d := sunrise.Sub(tt.sunrise); d < -3*time.Minute || d > 3*time.Minute {
+			t.Errorf("%s: expected sunrise around %s, got %s", tt.name, tt.sunrise, sunrise.In(tt.sunrise.Location()))
+		}
+		if d := sunset.Sub(tt.sunset); d < -3*time.Minute || d > 3*time.Minute {
+			t.Errorf("%s: expected sunset around %s, got %s", tt.name, tt.sunset, sunset.In(tt.sunset.Location()))
+		}
+	}
+
+	// polar night in Tromsø
+	if _, _, ok := sunriseSunset(2023, time.December, 21, GeoLocation{Latitude: 69.65, Longitude: 18.96}); ok {
+		t.Error("the sun should not rise in Tromsø at midwinter")
+	}
+
+}
+
+func TestParseSunTrigger(t *testing.T) {
+
+	for expr, want := range map[string]string{
+		"sunrise":        "sunrise",
+		"Sunrise - 20m":  "sunrise-20m",
+		"sunset+1h15m":   "sunset+1h15m",
+		" sunset -0s  ":  "sunset",
+		"sunrise+90m":    "sunrise+1h30m",
+		"sunset+2h":      "sunset+2h",
+		"sunrise-45s":    "sunrise-45s",
+		"sunrise-1h30m0": "",
+		"sunrise 20m":    "",
+		"sunsets":        "",
+	} {
+		st, err := parseSunTrigger(expr)
+		if want == "" {
+			if err == nil {
+				t.Errorf("expected '%s' to be rejected, got %s", expr, st)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unexpected error for '%s': %v", expr, err)
+			continue
+		}
+		if st.String() != want {
+			t.Errorf("expected '%s' to parse as %s, got %s", expr, want, st)
+		}
+	}
+
+}
+
+func TestTimerTriggersRelativeToSunrise(t *testing.T) {
+
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Monday morning in spring, when sunrise moves by about two minutes a day
+	clock := newFakeClock(time.Date(2023, time.April, 3, 0, 0, 0, 0, berlin))
+	cfg := CoffeeTimerConfig{
+		TriggerTime: "sunrise-20m",
+		Brew:        BrewLungo,
+		Schedule:    map[string]ScheduleEntry{"sunday": {Brew: BrewNone}},
+	}
+	ct := NewCoffeeTimer(cfg, newTestRaspi(), clock)
+	ct.SetGeoLocation(berlinGeo)
+
+	if rule, _ := ct.TriggerRule(); rule != "sunrise-20m" {
+		t.Fatalf("expected sunrise rule, got '%s'", rule)
+	}
+
+	upcoming := ct.Upcoming(7)
+	if len(upcoming) != 7 {
+		t.Fatalf("expected 7 upcoming coffees, got %d", len(upcoming))
+	}
+
+	for i, u := range upcoming {
+		sunrise, _, _ := sunriseSunset(u.Time.Year(), u.Time.Month(), u.Time.Day(), berlinGeo)
+		if want := sunrise.Add(-20 * time.Minute).Truncate(time.Second); !u.Time.Equal(want) {
+			t.Errorf("expected coffee 20 minutes before sunrise at %s, got %s", want, u.Time)
+		}
+		if u.Time.Weekday() == time.Sunday {
+			t.Errorf("no coffee expected on Sunday, got %s", u.Time)
+		}
+		if u.Brew != BrewLungo {
+			t.Errorf("expected lungo, got %s", u.Brew)
+		}
+		if i > 0 && u.Time.Sub(upcoming[i-1].Time) >= 24*time.Hour && u.Time.Weekday() != time.Monday {
+			t.Errorf("sunrise should come earlier each day in spring, got %s after %s", u.Time, upcoming[i-1].Time)
+		}
+	}
+
+	// switching back to a time of day drops the rule
+	ct.SetTriggerTime("6:30")
+	if rule, _ := ct.TriggerRule(); rule != "" {
+		t.Fatalf("expected weekly schedule, got rule '%s'", rule)
+	}
+
+}
+
+func TestSunTriggerRefusedWithoutLocation(t *testing.T) {
+
+	s := NewScheduler(newTestRaspi(), newFakeClock(testStart))
+	sunrise := AlarmConfig{Label: "dawn", Enabled: true, CoffeeTimerConfig: CoffeeTimerConfig{TriggerTime: "sunrise-20m", Brew: BrewLungo}}
+	if _, err := s.AddAlarm(sunrise); !errors.Is(err, ErrNoGeoLocation) {
+		t.Errorf("expected a sunrise alarm to be refused without a location, got %v", err)
+	}
+	if _, err := s.Preview("sunset", 3); !errors.Is(err, ErrNoGeoLocation) {
+		t.Errorf("expected a sunset preview to be refused without a location, got %v", err)
+	}
+	if _, err := s.Preview("30 6 * * 1-5", 3); err != nil {
+		t.Errorf("expected a cron preview without a location, got %v", err)
+	}
+
+	alarm, err := s.AddAlarm(AlarmConfig{Label: "office", CoffeeTimerConfig: CoffeeTimerConfig{TriggerTime: "6:30", Brew: BrewLungo}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := alarm.Timer().SetTriggerRule("sunrise", BrewLungo); !errors.Is(err, ErrNoGeoLocation) {
+		t.Errorf("expected a sunrise rule to be refused without a location, got %v", err)
+	}
+
+	// a timer built with a sunrise rule never triggers until it knows where it is
+	ct := NewCoffeeTimer(sunrise.CoffeeTimerConfig, newTestRaspi(), newFakeClock(testStart))
+	if upcoming := ct.Upcoming(1); len(upcoming) != 0 {
+		t.Errorf("expected no coffee without a location, got %v", upcoming)
+	}
+
+	s.SetGeoLocation(berlinGeo)
+	if _, err := s.AddAlarm(sunrise); err != nil {
+		t.Errorf("expected a sunrise alarm with a location, got %v", err)
+	}
+	if err := alarm.Timer().SetTriggerRule("sunrise", BrewLungo); err != nil {
+		t.Errorf("expected a sunrise rule with a location, got %v", err)
+	}
+}
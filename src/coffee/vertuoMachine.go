@@ -0,0 +1,22 @@
+package coffee
+
+const MachineModelVertuo = "vertuo"
+
+// vertuoRecipes only differ in name, as the machine reads the cup size from the capsule
+var vertuoRecipes = []RecipeConfig{
+	{Name: BrewEspresso, Steps: []RecipeStep{{WarmUp: "brew"}, {Press: "brew"}}, Capsule: BrewEspresso, WaterMl: 40},
+	{Name: BrewLungo, Steps: []RecipeStep{{WarmUp: "brew"}, {Press: "brew"}}, Capsule: BrewLungo, WaterMl: 150},
+}
+
+func init() {
+	RegisterMachine(MachineModelVertuo, NewVertuoMachine)
+}
+
+// NewVertuoMachine drives a Nespresso Vertuo machine. Its single button, wired like the espresso button,
+// switches it on when pressed while it is off, and makes coffee when pressed while it is on.
+func NewVertuoMachine(cfg MachineConfig, raspi *raspberrypi) CoffeeMachine {
+	buttons := map[string]func(press bool){
+		"brew": raspi.ActivateEspressoButton,
+	}
+	return newButtonMachine(cfg, buttons, vertuoRecipes)
+}
@@ -3,28 +3,33 @@ package main
 // These are the libraries we are going to use
 // Both "fmt" and "net" are part of the Go standard library
 import (
-	// "fmt" has methods for formatted I/O operations (like printing to the console)
-	"fmt"
 	"log"
 	"os"
 	"os/signal"
-	"strings"
 	"syscall"
 
 	// The "net/http" library has methods to implement HTTP clients and servers
 	"net/http"
 
-	"html/template"
-
 	"github.com/tfaber42/coffeepixie/src/coffee"
 	"gopkg.in/natefinch/lumberjack.v2"
 	"gopkg.in/yaml.v2"
 )
 
 type Config struct {
-	RaspberryPi      coffee.RaspiConfig            `yaml:"raspberry_pi"`
-	NespressoMachine coffee.NespressoMachineConfig `yaml:"nespresso_machine"`
-	Timer            coffee.CoffeeTimerConfig      `yaml:"timer"`
+	RaspberryPi    coffee.RaspiConfig         `yaml:"raspberry_pi"`
+	Machine        coffee.MachineConfig       `yaml:"machine"`
+	Timer          coffee.CoffeeTimerConfig   `yaml:"timer"`
+	Alarms         []coffee.AlarmConfig       `yaml:"alarms,omitempty"`
+	State          coffee.StateStoreConfig    `yaml:"state"`
+	SkipCalendar   coffee.SkipCalendarConfig  `yaml:"skip_calendar"`
+	Location       *coffee.GeoLocation        `yaml:"location,omitempty"`
+	Snooze         coffee.SnoozeConfig        `yaml:"snooze"`
+	MissedTriggers coffee.MissedTriggerConfig `yaml:"missed_triggers"`
+	BrewExecutor   coffee.BrewExecutorConfig  `yaml:"brew_executor"`
+	Inventory      coffee.InventoryConfig     `yaml:"inventory"`
+	Maintenance    coffee.MaintenanceConfig   `yaml:"maintenance"`
+	CurrentSensor  coffee.CurrentSensorConfig `yaml:"current_sensor"`
 }
 
 func main() {
@@ -40,19 +45,174 @@ func main() {
 
 	cfg := readConfig("config.yml")
 
-	raspi := coffee.NewRaspi(cfg.RaspberryPi)
+	hw, err := coffee.NewHardware(cfg.RaspberryPi.Backend)
+	if err != nil {
+		log.Fatal(err)
+	}
+	raspi, err := coffee.NewRaspi(cfg.RaspberryPi, hw)
+	if err != nil {
+		log.Fatal(err)
+	}
 	defer raspi.Disconnect()
 
-	pixie := coffee.NewNespressoMachine(cfg.NespressoMachine, raspi)
+	pixie, err := coffee.NewCoffeeMachine(cfg.Machine, raspi)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	inventory := coffee.NewInventory(cfg.Inventory)
+	pixie.SetInventory(inventory)
+	maintenance := coffee.NewMaintenance(cfg.Maintenance)
+	pixie.SetMaintenance(maintenance)
+	if raspi.HasCupSensor() {
+		pixie.SetSensor(coffee.SensorCup, raspi.CupPresent)
+	}
+	if cfg.CurrentSensor.Driver != "" {
+		// without the sensor, the machine still makes coffee, only without confirming it
+		if sensor, err := coffee.NewCurrentSensor(cfg.CurrentSensor, hw, pixie.State); err != nil {
+			log.Println("Not confirming brews:", err)
+		} else {
+			pixie.SetCurrentSensor(sensor, cfg.CurrentSensor)
+		}
+	}
+
+	// the timer and the web UI both make coffee through the executor, so their button sequences never overlap
+	executor := coffee.NewBrewExecutor(cfg.BrewExecutor, pixie)
+
+	scheduler := coffee.NewScheduler(raspi, coffee.SystemClock)
+	scheduler.SetBrewFunc(executor.BrewFunc("timer"))
+	scheduler.SetPowerOnFunc(executor.PowerOnFunc("timer"), pixie.WarmUpLead())
+	scheduler.SetMissedTriggerConfig(cfg.MissedTriggers)
+	scheduler.SetRecipes(pixie.Recipes())
+	if cfg.Location != nil {
+		scheduler.SetGeoLocation(*cfg.Location)
+	}
+	if inventory.RefusesArmingWhenEmpty() {
+		scheduler.SetArmCheckFunc(pixie.CheckStock)
+	}
+
+	// the timer section of the config is the default alarm, any further alarms are optional
+	_, err = scheduler.AddAlarm(coffee.AlarmConfig{ID: "default", Label: "Default", Enabled: true, CoffeeTimerConfig: cfg.Timer})
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, alarmCfg := range cfg.Alarms {
+		if _, err := scheduler.AddAlarm(alarmCfg); err != nil {
+			log.Println("Skipping alarm from config:", err)
+		}
+	}
+
+	var skipCalendar *coffee.SkipCalendar
+	if cfg.SkipCalendar.ICSFile != "" {
+		skipCalendar, err = coffee.LoadSkipCalendar(cfg.SkipCalendar)
+		if err != nil {
+			// keep the calendar anyway, so it can be reloaded from the web UI once the file has been fixed
+			log.Println("Could not import skip calendar:", err)
+		}
+		scheduler.SetSkipCalendar(skipCalendar)
+	}
+
+	// anything changed in the web UI since the config was written is in the saved state, which takes precedence
+	store, err := coffee.NewStateStore(cfg.State)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var state coffee.SchedulerState
+	if ok, err := store.Load("scheduler", &state); err != nil {
+		log.Println("Could not restore saved state, starting from config:", err)
+	} else if ok {
+		log.Println("Restoring saved state")
+		scheduler.Restore(state)
+	} else {
+		log.Println("No saved state found, starting from config")
+	}
+
+	// the display, if there is one, shows what the timer and the machine are up to, and the last thing that went wrong
+	var display *coffee.StatusDisplay
+	if raspi.Display() != nil {
+		display = coffee.NewStatusDisplay(raspi.Display(), func() coffee.DisplayStatus {
+			status := coffee.DisplayStatus{Armed: scheduler.IsArmed()}
+			if _, triggerTime, brew, ok := scheduler.Next(); ok {
+				status.Next, status.NextBrew = triggerTime, brew
+			}
+			status.Machine, _ = pixie.State()
+			return status
+		})
+	}
+	saveScheduler := store.Autosave("scheduler", func() interface{} { return scheduler.State() })
+	scheduler.SetStateChangedFunc(func() {
+		saveScheduler()
+		display.Refresh()
+	})
+	pixie.SetStateChangedFunc(func(coffee.MachineState) { display.Refresh() })
+	pixie.SetHeldBackFunc(func(reason string) {
+		raspi.SignalHeldBack(reason)
+		display.SetLastError(reason)
+	})
+	executor.SetStartedFunc(func(job coffee.BrewJob) {
+		if job.Kind == coffee.JobBrew {
+			raspi.PlayTune(coffee.TuneBrewStarted)
+		}
+	})
+	executor.SetFinishedFunc(func(job coffee.BrewJob) {
+		if job.Err != nil {
+			display.SetLastError(job.Err.Error())
+			raspi.PlayTune(coffee.TuneError)
+		} else if job.Kind == coffee.JobBrew {
+			raspi.PlayTune(coffee.TuneBrewComplete)
+		}
+	})
+
+	var inventoryState coffee.InventoryState
+	if ok, err := store.Load("inventory", &inventoryState); err != nil {
+		log.Println("Could not restore capsule inventory:", err)
+	} else if ok {
+		inventory.Restore(inventoryState)
+	}
+	saveInventory := store.Autosave("inventory", func() interface{} { return inventory.State() })
+	inventory.SetChangedFunc(func() {
+		saveInventory()
+		if inventory.RefusesArmingWhenEmpty() {
+			scheduler.RecheckArming()
+		}
+	})
 
-	coffeeTimer := coffee.NewCoffeeTimer(cfg.Timer, raspi)
+	var maintenanceState coffee.MaintenanceState
+	if ok, err := store.Load("maintenance", &maintenanceState); err != nil {
+		log.Println("Could not restore maintenance counters:", err)
+	} else if ok {
+		maintenance.Restore(maintenanceState)
+	}
+	maintenance.SetChangedFunc(store.Autosave("maintenance", func() interface{} { return maintenance.State() }))
 
-	coffeeTimer.SetTriggerFunc(pixie.MakeEspresso)
+	if inventory.RefusesArmingWhenEmpty() {
+		// the saved state may have been armed with capsules that have run out since
+		scheduler.RecheckArming()
+	}
 
-	raspi.SetShowArmedStatusFunc(coffeeTimer.ShowArmedStatus)
-	raspi.SetToggleArmedStatusFunc(coffeeTimer.ToggleArmedStatus)
+	raspi.SetActionFunc(coffee.ActionShowStatus, scheduler.ShowArmedStatus)
+	raspi.SetActionFunc(coffee.ActionToggleArm, scheduler.ToggleArmedStatus)
+	raspi.SetActionFunc(coffee.ActionSnooze, func() { scheduler.Snooze(cfg.Snooze.Duration()) })
+	raspi.SetActionFunc(coffee.ActionResetMaintenance, maintenance.ResetDue)
+	raspi.SetActionFunc(coffee.ActionBrewNow, func() {
+		brew := cfg.RaspberryPi.BrewNow
+		if brew == "" {
+			// the next coffee's brew, or the first recipe if none is pending
+			if _, _, next, ok := scheduler.Next(); ok && next != coffee.BrewNone {
+				brew = next
+			} else if recipes := pixie.Recipes(); len(recipes) > 0 {
+				brew = recipes[0]
+			} else {
+				log.Println("Not brewing from the button: no recipe to brew")
+				return
+			}
+		}
+		if _, err := executor.Brew(brew, "button"); err != nil {
+			log.Println("Not brewing from the button:", err)
+		}
+	})
 
-	coffeeTimer.ShowArmedStatus()
+	scheduler.ShowArmedStatus()
 
 	// Clean up on ctrl-c and turn lights out
 	c := make(chan os.Signal, 1)
@@ -60,6 +220,7 @@ func main() {
 	go func() {
 		<-c
 		log.Println("SIGTERM received")
+		executor.Shutdown()
 		raspi.Disconnect()
 		log.Println()
 		log.Println()
@@ -69,7 +230,7 @@ func main() {
 	port := "3000"
 
 	fs := http.FileServer(http.Dir("src/html/assets"))
-	ph := pixieHandler{coffeeTimer: coffeeTimer, nespressoMachine: &pixie}
+	ph := pixieHandler{scheduler: scheduler, executor: executor, inventory: inventory, maintenance: maintenance, skipCalendar: skipCalendar, snooze: cfg.Snooze, brews: append(pixie.Recipes(), coffee.BrewNone)}
 
 	mux := http.NewServeMux()
 	mux.Handle("/assets/", http.StripPrefix("/assets/", fs))
@@ -77,98 +238,26 @@ func main() {
 	log.Fatal(http.ListenAndServe(":"+port, mux))
 }
 
-var tpl = template.Must(template.ParseFiles("src/html/index.html"))
-
-type pageData struct {
-	EspressoChecked, LungoChecked, NoCoffeeChecked string
-	TriggerTime                                    string
-	Status                                         template.HTML
-}
-
-type pixieHandler struct {
-	coffeeTimer      *coffee.CoffeeTimer
-	nespressoMachine *coffee.NespressoMachine
-}
-
-func (ph pixieHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-
-	//u, err := url.Parse(r.URL.String())
-	err := r.ParseForm()
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	/*
-			params := u.Query()
-			triggerTime := params.Get("trigger-time")
-			triggerType := params.Get("trigger-type")
-
-
-		fmt.Println("triggerTime is: ", triggerTime)
-		fmt.Println("triggerType is: ", triggerType)
-	*/
-
-	triggerTime := r.PostFormValue("trigger-time")
-	triggerType := r.PostFormValue("trigger-type")
-
-	var pd pageData
-
-	// rudimentary string validation
-	if len(strings.Split(triggerTime, ":")) == 2 {
-		ph.coffeeTimer.SetTriggerTime(triggerTime)
-		pd.TriggerTime = triggerTime
-	} else {
-		pd.TriggerTime = ph.coffeeTimer.GetTriggerTime()
-	}
-
-	switch triggerType {
-	case "espresso":
-		ph.coffeeTimer.SetTriggerFunc(ph.nespressoMachine.MakeEspresso)
-		ph.coffeeTimer.Arm()
-	case "lungo":
-		ph.coffeeTimer.SetTriggerFunc(ph.nespressoMachine.MakeLungo)
-		ph.coffeeTimer.Arm()
-	case "none":
-		ph.coffeeTimer.Disarm()
-	default:
-		if ph.coffeeTimer.IsArmed() {
-			triggerType = "espresso"
-		} else {
-
-			triggerType = "none"
-		}
-	}
-	ph.coffeeTimer.ShowArmedStatus()
-
-	switch triggerType {
-	case "espresso":
-		pd.EspressoChecked = "checked"
-		pd.Status = template.HTML(fmt.Sprintf("Pixie is making <b>ESPRESSO</b> at %s", pd.TriggerTime))
-	case "lungo":
-		pd.LungoChecked = "checked"
-		pd.Status = template.HTML(fmt.Sprintf("Pixie is making <b>LUNGO</b> at %s", pd.TriggerTime))
-	case "none":
-		pd.NoCoffeeChecked = "checked"
-		pd.Status = template.HTML("Pixie is NOT MAKING COFFEE")
-	}
-
-	tpl.Execute(w, pd)
-}
-
 func readConfig(fileName string) Config {
+
 	var cfg Config
-	cfgFile, err := os.Open(fileName)
-	if err != nil {
-		// set defaults and write file
 
-		// TODO: reactivate the input buttons when the hardware interference issue is resolved
-		cfg.RaspberryPi = coffee.RaspiConfigNoInputButtons
+	cfg.RaspberryPi = coffee.RaspiConfigDefaults
 
-		cfg.NespressoMachine = coffee.NespressoMachineConfigDefaults
-		cfg.Timer = coffee.CoffeeTimerConfigDefaults
+	cfg.Machine = coffee.MachineConfigDefaults
+	cfg.Timer = coffee.CoffeeTimerConfigDefaults
+	cfg.State = coffee.StateStoreConfigDefaults
+	cfg.Snooze = coffee.SnoozeConfigDefaults
+	cfg.MissedTriggers = coffee.MissedTriggerConfigDefaults
+	cfg.BrewExecutor = coffee.BrewExecutorConfigDefaults
+	cfg.Inventory = coffee.InventoryConfigDefaults
+	cfg.Maintenance = coffee.MaintenanceConfigDefaults
+	cfg.CurrentSensor = coffee.CurrentSensorConfigDefaults
 
-		cfgFile, err = os.Create("config.yml")
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		// write the defaults
+		cfgFile, err := os.Create("config.yml")
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -180,13 +269,30 @@ func readConfig(fileName string) Config {
 			log.Fatal(err)
 		}
 	} else {
-		defer cfgFile.Close()
+		// settings missing from a config file written by an older version keep their defaults,
+		// e.g. pins added since then stay unused rather than becoming GPIO 0
+		err = yaml.Unmarshal(data, &cfg)
+		if err != nil {
+			log.Fatal(err)
+		}
 
-		decoder := yaml.NewDecoder(cfgFile)
-		err = decoder.Decode(&cfg)
+		var deprecated bool
+		cfg.Machine, deprecated, err = coffee.ReadMachineConfig(data)
 		if err != nil {
 			log.Fatal(err)
 		}
+		if deprecated {
+			log.Println("Config section nespresso_machine is deprecated, please rename it to machine and add model: nespresso")
+		}
+	}
+
+	// the current sensor's ADC takes the I2C bus too, so its pins can't be used for anything else
+	raspiCfg := cfg.RaspberryPi
+	if cfg.CurrentSensor.Driver == coffee.CurrentSensorADS1115 {
+		raspiCfg.EnabledBuses = append([]string{coffee.BusI2C}, raspiCfg.EnabledBuses...)
+	}
+	if err := raspiCfg.Validate(); err != nil {
+		log.Fatal(err)
 	}
 	return cfg
 }
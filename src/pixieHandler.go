@@ -0,0 +1,368 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tfaber42/coffeepixie/src/coffee"
+)
+
+var tpl = template.Must(template.ParseFiles("src/html/index.html"))
+
+// upcomingCount is how many upcoming coffees are previewed for each alarm and for a trigger rule
+const upcomingCount = 5
+
+// skipPreviewDays is how far ahead the page lists coffees that are skipped because of the skip calendar
+const skipPreviewDays = 30
+
+type dayData struct {
+	Key, Name  string
+	Time, Brew string
+}
+
+type alarmData struct {
+	ID, Label      string
+	Enabled        bool
+	Next           string
+	NotArmed       string
+	Days           []dayData
+	Rule, RuleBrew string
+	Upcoming       []string
+}
+
+type skipData struct {
+	When, Brew, Alarm, Reason string
+}
+
+type missedData struct {
+	When, Brew, Alarm, Late string
+}
+
+type capsuleData struct {
+	Capsule string
+	Count   int
+	Low     bool
+}
+
+type maintenanceData struct {
+	Task, Since    string
+	Brews, WaterMl int
+	Due            bool
+}
+
+type jobData struct {
+	ID       int
+	Recipe   string
+	Source   string
+	Progress string
+}
+
+type pageData struct {
+	Armed              bool
+	Pending            bool
+	SnoozeMinutes      int
+	Alarms             []alarmData
+	Brews              []string
+	CalendarConfigured bool
+	Skipped            []skipData
+	Missed             []missedData
+	PreviewExpr        string
+	Preview            []string
+	PreviewError       string
+	MachineState       string
+	Capsules           []capsuleData
+	Maintenance        []maintenanceData
+	Job                *jobData
+	Queued             []jobData
+	LastJob            string
+	Status             template.HTML
+}
+
+type pixieHandler struct {
+	scheduler    *coffee.Scheduler
+	executor     *coffee.BrewExecutor
+	inventory    *coffee.Inventory
+	maintenance  *coffee.Maintenance
+	skipCalendar *coffee.SkipCalendar
+	snooze       coffee.SnoozeConfig
+	brews        []string // the configured recipes, and none
+}
+
+func (ph pixieHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	pd := pageData{Brews: ph.brews, CalendarConfigured: ph.skipCalendar != nil, SnoozeMinutes: int(ph.snooze.Duration() / time.Minute)}
+
+	if r.Method == http.MethodPost {
+		switch r.PostFormValue("action") {
+		case "arm":
+			switch r.PostFormValue("armed") {
+			case "on":
+				ph.scheduler.Arm()
+			case "off":
+				ph.scheduler.Disarm()
+			}
+		case "add":
+			triggerTime := r.PostFormValue("trigger-time")
+			if rule := strings.TrimSpace(r.PostFormValue("rule")); rule != "" {
+				if err = ph.scheduler.ValidateTriggerRule(rule); err != nil {
+					break
+				}
+				triggerTime = rule
+			} else if err = coffee.ValidateClockTime(triggerTime); err != nil {
+				// an empty time would make an alarm at midnight
+				break
+			}
+			alarmCfg := coffee.AlarmConfig{
+				Label:             r.PostFormValue("label"),
+				Enabled:           true,
+				CoffeeTimerConfig: coffee.CoffeeTimerConfig{TriggerTime: triggerTime, Brew: ph.formBrew(r, "brew")},
+			}
+			_, err = ph.scheduler.AddAlarm(alarmCfg)
+		case "save":
+			err = ph.saveAlarm(r)
+		case "delete":
+			err = ph.scheduler.DeleteAlarm(r.PostFormValue("id"))
+		case "snooze":
+			err = ph.postpone(r)
+		case "dismiss-missed":
+			ph.scheduler.DismissMissedTriggers()
+		case "reload-calendar":
+			err = ph.scheduler.ReloadSkipCalendar()
+		case "brew":
+			brew := ph.formBrew(r, "brew")
+			if brew == "" || brew == coffee.BrewNone {
+				err = fmt.Errorf("unknown recipe '%s'", r.PostFormValue("brew"))
+				break
+			}
+			_, err = ph.executor.Brew(brew, "web")
+		case "cancel":
+			err = ph.cancelJob(r)
+		case "refill", "set-stock":
+			err = ph.updateStock(r)
+		case "reset-maintenance":
+			err = ph.maintenance.Reset(r.PostFormValue("task"))
+		case "preview":
+			// shows when a cron expression or sunrise/sunset rule would fire, without saving it
+			pd.PreviewExpr = r.PostFormValue("rule")
+			pd.Preview, pd.PreviewError = ph.previewRule(pd.PreviewExpr)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	ph.scheduler.ShowArmedStatus()
+
+	pd.Armed = ph.scheduler.IsArmed()
+
+	for _, skip := range ph.scheduler.SkippedTriggers(time.Now().AddDate(0, 0, skipPreviewDays)) {
+		pd.Skipped = append(pd.Skipped, skipData{When: skip.Time.Format("Mon 2 Jan 15:04"), Brew: skip.Brew, Alarm: skip.Alarm.Label(), Reason: skip.Event.Summary})
+	}
+
+	for _, missed := range ph.scheduler.MissedTriggers() {
+		pd.Missed = append(pd.Missed, missedData{When: missed.Time.Format("Mon 2 Jan 15:04"), Brew: missed.Brew, Alarm: missed.Alarm.Label(), Late: missed.Late.String()})
+	}
+
+	for _, alarm := range ph.scheduler.Alarms() {
+		ad := alarmData{ID: alarm.ID(), Label: alarm.Label(), Enabled: alarm.IsEnabled()}
+		if triggerTime, brew, ok := alarm.Timer().NextTrigger(); ok {
+			ad.Next = fmt.Sprintf("next: %s on %s", brew, triggerTime.Format("Monday at 15:04"))
+		}
+		if err := alarm.Timer().ArmError(); err != nil {
+			ad.NotArmed = err.Error()
+		}
+		for _, day := range coffee.Weekdays {
+			triggerTime, brew := alarm.Timer().DaySchedule(day)
+			ad.Days = append(ad.Days, dayData{Key: strings.ToLower(day.String()), Name: day.String(), Time: triggerTime, Brew: brew})
+		}
+		ad.Rule, ad.RuleBrew = alarm.Timer().TriggerRule()
+		for _, upcoming := range alarm.Timer().Upcoming(upcomingCount) {
+			ad.Upcoming = append(ad.Upcoming, fmt.Sprintf("%s: %s", upcoming.Time.Format("Mon 2 Jan 15:04"), upcoming.Brew))
+		}
+		pd.Alarms = append(pd.Alarms, ad)
+	}
+
+	ph.addJobs(&pd)
+
+	for _, stock := range ph.inventory.Stock() {
+		pd.Capsules = append(pd.Capsules, capsuleData{Capsule: stock.Capsule, Count: stock.Count, Low: stock.Low})
+	}
+
+	for _, c := range ph.maintenance.Counters() {
+		pd.Maintenance = append(pd.Maintenance, maintenanceData{Task: c.Task, Since: c.Since.Format("2 Jan 2006"), Brews: c.Brews, WaterMl: c.WaterMl, Due: c.Due})
+	}
+
+	if alarm, triggerTime, brew, ok := ph.scheduler.Next(); ok {
+		pd.Pending = true
+		pd.Status = template.HTML(fmt.Sprintf("Pixie is making <b>%s</b> on %s (%s)",
+			template.HTMLEscapeString(strings.ToUpper(brew)), triggerTime.Format("Monday at 15:04"), template.HTMLEscapeString(alarm.Label())))
+		if scheduled, ok := alarm.Timer().SnoozedFrom(); ok {
+			pd.Status += template.HTML(fmt.Sprintf(", postponed from %s", scheduled.Format("15:04")))
+		}
+	} else {
+		pd.Status = template.HTML("Pixie is NOT MAKING COFFEE")
+	}
+
+	tpl.Execute(w, pd)
+}
+
+// saveAlarm applies the label, enabled flag and weekly schedule posted for one alarm.
+// Everything is validated first, so an invalid field leaves the alarm as it was rather than half saved.
+func (ph pixieHandler) saveAlarm(r *http.Request) error {
+
+	id := r.PostFormValue("id")
+	alarm, ok := ph.scheduler.Alarm(id)
+	if !ok {
+		return fmt.Errorf("no alarm with ID '%s'", id)
+	}
+
+	type dayChange struct {
+		day         time.Weekday
+		triggerTime string
+		brew        string
+	}
+	var changes []dayChange
+	for _, day := range coffee.Weekdays {
+		key := strings.ToLower(day.String())
+		triggerTime := r.PostFormValue("time-" + key)
+		brew := ph.formBrew(r, "brew-"+key)
+
+		// only touch the days that have actually been changed, as every change re-arms the timer
+		currentTime, currentBrew := alarm.Timer().DaySchedule(day)
+		if triggerTime == currentTime && brew == currentBrew {
+			continue
+		}
+		if triggerTime != "" {
+			if err := coffee.ValidateClockTime(triggerTime); err != nil {
+				return fmt.Errorf("%s: %w", day, err)
+			}
+		}
+		changes = append(changes, dayChange{day, triggerTime, brew})
+	}
+
+	rule, ruleBrew := r.PostFormValue("rule"), ph.formBrew(r, "rule-brew")
+	currentRule, currentRuleBrew := alarm.Timer().TriggerRule()
+	ruleChanged := rule != currentRule || ruleBrew != currentRuleBrew
+	if ruleChanged {
+		if err := ph.scheduler.ValidateTriggerRule(rule); err != nil {
+			return err
+		}
+	}
+
+	for _, c := range changes {
+		if err := alarm.Timer().SetDaySchedule(c.day, c.triggerTime, c.brew); err != nil {
+			return err
+		}
+	}
+	if ruleChanged {
+		if err := alarm.Timer().SetTriggerRule(rule, ruleBrew); err != nil {
+			return err
+		}
+	}
+
+	return ph.scheduler.UpdateAlarm(id, r.PostFormValue("label"), r.PostFormValue("enabled") == "on")
+}
+
+// postpone shifts the next coffee by the posted number of minutes
+func (ph pixieHandler) postpone(r *http.Request) error {
+
+	minutes, err := strconv.Atoi(r.PostFormValue("minutes"))
+	if err != nil || minutes <= 0 {
+		return fmt.Errorf("invalid number of minutes '%s'", r.PostFormValue("minutes"))
+	}
+
+	_, _, err = ph.scheduler.Postpone(time.Duration(minutes) * time.Minute)
+	return err
+}
+
+// updateStock adds the posted number of capsules to the stock of their type, or sets it to that number
+func (ph pixieHandler) updateStock(r *http.Request) error {
+
+	count, err := strconv.Atoi(r.PostFormValue("count"))
+	if err != nil {
+		return fmt.Errorf("invalid number of capsules '%s'", r.PostFormValue("count"))
+	}
+	capsule := strings.TrimSpace(r.PostFormValue("capsule"))
+
+	if r.PostFormValue("action") == "set-stock" {
+		return ph.inventory.SetStock(capsule, count)
+	}
+	return ph.inventory.Refill(capsule, count)
+}
+
+// cancelJob cancels the running or a queued job by its posted ID
+func (ph pixieHandler) cancelJob(r *http.Request) error {
+
+	id, err := strconv.Atoi(r.PostFormValue("job"))
+	if err != nil {
+		return fmt.Errorf("invalid job '%s'", r.PostFormValue("job"))
+	}
+	return ph.executor.Cancel(id)
+}
+
+// addJobs shows what the machine is doing, what is waiting for it and how the last job went
+func (ph pixieHandler) addJobs(pd *pageData) {
+
+	state, since := ph.executor.Machine().State()
+	pd.MachineState = fmt.Sprintf("%s since %s", state, since.Format("15:04"))
+
+	if job, ok := ph.executor.Current(); ok {
+		jd := jobData{ID: job.ID, Recipe: job.Recipe, Source: job.Source, Progress: "starting"}
+		if job.Kind == coffee.JobWarmUp {
+			jd.Recipe = "warming up for " + job.Recipe
+		}
+		if job.Steps > 0 {
+			jd.Progress = fmt.Sprintf("step %d of %d: %s", job.Step, job.Steps, job.StepName)
+		}
+		pd.Job = &jd
+	}
+
+	for _, job := range ph.executor.Queued() {
+		pd.Queued = append(pd.Queued, jobData{ID: job.ID, Recipe: job.Recipe, Source: job.Source})
+	}
+
+	if job, ok := ph.executor.Last(); ok {
+		pd.LastJob = fmt.Sprintf("%s from %s at %s", job.Recipe, job.Source, job.Finished.Format("15:04"))
+		if job.Err != nil {
+			pd.LastJob += " failed: " + job.Err.Error()
+		} else {
+			pd.LastJob += " done"
+		}
+	}
+}
+
+// previewRule lists when a cron expression or sunrise/sunset rule fires next, or returns why it cannot be parsed
+func (ph pixieHandler) previewRule(rule string) ([]string, string) {
+
+	upcoming, err := ph.scheduler.Preview(rule, upcomingCount)
+	if err != nil {
+		return nil, err.Error()
+	}
+
+	var preview []string
+	for _, u := range upcoming {
+		preview = append(preview, u.Time.Format("Mon 2 Jan 2006 15:04:05"))
+	}
+	return preview, ""
+}
+
+// formBrew returns the posted brew type, or "" if it is not one of the configured recipes
+func (ph pixieHandler) formBrew(r *http.Request, key string) string {
+	brew := r.PostFormValue(key)
+	for _, b := range ph.brews {
+		if b == brew {
+			return brew
+		}
+	}
+	return ""
+}